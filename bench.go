@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lizhaoliu/konsen/v2/client"
+	"github.com/lizhaoliu/konsen/v2/core"
+	"github.com/lizhaoliu/konsen/v2/security"
+	"github.com/sirupsen/logrus"
+)
+
+const benchUsage = `Usage: konsen bench [flags]
+
+Sends a mix of reads and writes to a running cluster through the Go client, and reports throughput and latency
+percentiles of each operation. Keys are "<key_prefix><n>" with n in [0, keys), picked uniformly or with a zipfian
+distribution (n=0 being the hottest key).
+
+Flags:
+`
+
+// benchConfig is the workload of a benchmark.
+type benchConfig struct {
+	Endpoints       []string `json:"endpoints"`
+	Duration        string   `json:"duration,omitempty"`
+	Requests        int64    `json:"requests,omitempty"`
+	Concurrency     int      `json:"concurrency"`
+	Rate            float64  `json:"rate"`
+	ReadRatio       float64  `json:"readRatio"`
+	Keys            int      `json:"keys"`
+	KeyPrefix       string   `json:"keyPrefix"`
+	KeyDistribution string   `json:"keyDistribution"`
+	ZipfS           float64  `json:"zipfS,omitempty"`
+	ValueSize       int      `json:"valueSize"`
+	ValueSizeMax    int      `json:"valueSizeMax,omitempty"`
+	Prepopulate     bool     `json:"prepopulate"`
+	Retries         int      `json:"retries"`
+	Consistency     string   `json:"consistency"`
+}
+
+// benchResult is the result of a benchmark, written as JSON with --json.
+type benchResult struct {
+	Config          benchConfig `json:"config"`
+	StartTime       time.Time   `json:"startTime"`
+	DurationSeconds float64     `json:"durationSeconds"`
+	Total           *opStats    `json:"total"`
+	Reads           *opStats    `json:"reads"`
+	Writes          *opStats    `json:"writes"`
+}
+
+// opStats are the statistics of an operation.
+type opStats struct {
+	Requests   int64            `json:"requests"`
+	Errors     int64            `json:"errors"`
+	Throughput float64          `json:"throughput"` // Successful requests per second.
+	Latency    latencyStats     `json:"latencyMs"`  // Latency of successful requests.
+	ErrorTypes map[string]int64 `json:"errorTypes,omitempty"`
+}
+
+// latencyStats are latency percentiles, in milliseconds.
+type latencyStats struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	P999 float64 `json:"p99.9"`
+	Max  float64 `json:"max"`
+}
+
+// benchRecorder collects the results of one operation by a worker.
+type benchRecorder struct {
+	latencies []time.Duration
+	errors    map[string]int64
+}
+
+func newBenchRecorder() *benchRecorder {
+	return &benchRecorder{errors: make(map[string]int64)}
+}
+
+func (r *benchRecorder) record(latency time.Duration, err error) {
+	if err != nil {
+		r.errors[benchErrorType(err)]++
+		return
+	}
+	r.latencies = append(r.latencies, latency)
+}
+
+// benchErrorType classifies an error for the report.
+func benchErrorType(err error) string {
+	switch {
+	case errors.Is(err, client.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, client.ErrOverloaded):
+		return "overloaded"
+	case errors.Is(err, client.ErrNoQuorum):
+		return "no_quorum"
+	case errors.Is(err, client.ErrNotLeader):
+		return "not_leader"
+	case errors.Is(err, client.ErrUnauthenticated), errors.Is(err, client.ErrPermissionDenied):
+		return "auth"
+	default:
+		return "other"
+	}
+}
+
+// runBench runs the "bench" subcommand with given arguments.
+func runBench(args []string) {
+	logrus.SetOutput(os.Stderr)
+	logrus.SetLevel(logrus.WarnLevel)
+
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), benchUsage)
+		fs.PrintDefaults()
+	}
+	clusterConfigPath := fs.String("cluster_config_path", "", "Cluster configuration file path, HTTP endpoints of servers are taken from it.")
+	endpoints := fs.String("endpoints", "", "Comma separated HTTP endpoints of servers, used if cluster_config_path is unspecified.")
+	caFile := fs.String("ca_file", "", "CA certificates to verify servers with, enables TLS. Defaults to the one in cluster configuration.")
+	certFile := fs.String("cert_file", "", "Client certificate, for servers that require mutual TLS. Defaults to the one in cluster configuration.")
+	keyFile := fs.String("key_file", "", "Private key of the client certificate.")
+	user := fs.String("user", "", "User name and password to authenticate with, as <name>:<password>.")
+	token := fs.String("token", "", "Token to authenticate with, instead of user.")
+	timeout := fs.Duration("timeout", 10*time.Second, "Timeout of each request.")
+	duration := fs.Duration("duration", 10*time.Second, "Duration of the benchmark, unless requests is set.")
+	var config benchConfig
+	fs.Int64Var(&config.Requests, "requests", 0, "Total number of requests to send, overrides duration if set.")
+	fs.IntVar(&config.Concurrency, "concurrency", 16, "Number of concurrent workers, each sending one request at a time.")
+	fs.Float64Var(&config.Rate, "rate", 0, "Maximum total requests per second, 0 means unlimited.")
+	fs.Float64Var(&config.ReadRatio, "read_ratio", 0.5, "Fraction of requests that are reads, the others are writes.")
+	fs.IntVar(&config.Keys, "keys", 1000, "Number of distinct keys.")
+	fs.StringVar(&config.KeyPrefix, "key_prefix", "bench/", "Prefix of keys.")
+	fs.StringVar(&config.KeyDistribution, "key_distribution", "uniform", "Distribution of keys, one of: uniform, zipfian.")
+	fs.Float64Var(&config.ZipfS, "zipf_s", 1.1, "Exponent of the zipfian distribution, must be greater than 1. Greater values make hot keys hotter.")
+	fs.IntVar(&config.ValueSize, "value_size", 128, "Size of written values in bytes.")
+	fs.IntVar(&config.ValueSizeMax, "value_size_max", 0, "If greater than value_size, value sizes are picked uniformly from [value_size, value_size_max].")
+	fs.BoolVar(&config.Prepopulate, "prepopulate", false, "Write all keys before the benchmark, so that reads find values.")
+	fs.IntVar(&config.Retries, "retries", 0, "Number of retries of a failed request by the client, retried requests count as one.")
+	fs.StringVar(&config.Consistency, "consistency", "linearizable", "Consistency of reads, one of: linearizable, leader-local, stale, bounded-staleness=<duration or index lag>.")
+	jsonPath := fs.String("json", "", "Also write the result as JSON to this file (\"-\" for stdout), for regression tracking.")
+	fs.Parse(args)
+
+	if config.Requests == 0 {
+		config.Duration = duration.String()
+	}
+	err := func() error {
+		if err := config.validate(); err != nil {
+			return err
+		}
+		tlsConfig, err := benchClientTLS(*clusterConfigPath, *endpoints, *caFile, *certFile, *keyFile, &config)
+		if err != nil {
+			return err
+		}
+		username, password := *user, ""
+		if i := strings.IndexByte(*user, ':'); i >= 0 {
+			username, password = (*user)[:i], (*user)[i+1:]
+		}
+		maxRetries := config.Retries
+		if maxRetries == 0 {
+			maxRetries = -1 // Disables retries of the client.
+		}
+		c, err := client.NewClient(client.ClientConfig{
+			Endpoints:      config.Endpoints,
+			RequestTimeout: *timeout,
+			MaxRetries:     maxRetries,
+			TLS:            tlsConfig,
+			Username:       username,
+			Password:       password,
+			Token:          *token,
+
+			ReadConsistency: config.Consistency,
+		})
+		if err != nil {
+			return err
+		}
+
+		result, err := bench(c, &config, *duration)
+		if err != nil {
+			return err
+		}
+		if err := printBenchResult(os.Stdout, result); err != nil {
+			return err
+		}
+		if *jsonPath != "" {
+			return writeBenchJSON(*jsonPath, result)
+		}
+		return nil
+	}()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func (c *benchConfig) validate() error {
+	switch {
+	case c.Concurrency <= 0:
+		return fmt.Errorf("concurrency must be positive, got %d", c.Concurrency)
+	case c.Rate < 0:
+		return fmt.Errorf("rate must not be negative, got %v", c.Rate)
+	case c.ReadRatio < 0 || c.ReadRatio > 1:
+		return fmt.Errorf("read_ratio must be within [0, 1], got %v", c.ReadRatio)
+	case c.Keys <= 0:
+		return fmt.Errorf("keys must be positive, got %d", c.Keys)
+	case c.ValueSize < 0:
+		return fmt.Errorf("value_size must not be negative, got %d", c.ValueSize)
+	case c.Retries < 0:
+		return fmt.Errorf("retries must not be negative, got %d", c.Retries)
+	}
+	if _, err := core.ParseReadConsistency(c.Consistency); err != nil {
+		return err
+	}
+	switch c.KeyDistribution {
+	case "uniform":
+		c.ZipfS = 0
+	case "zipfian":
+		if c.ZipfS <= 1 {
+			return fmt.Errorf("zipf_s must be greater than 1, got %v", c.ZipfS)
+		}
+	default:
+		return fmt.Errorf("unknown key distribution %q, expecting one of: uniform, zipfian", c.KeyDistribution)
+	}
+	if c.ValueSizeMax <= c.ValueSize {
+		c.ValueSizeMax = 0
+	}
+	return nil
+}
+
+// benchClientTLS resolves the endpoints of the cluster into config, and returns the TLS configuration of the client.
+func benchClientTLS(clusterConfigPath, endpoints, caFile, certFile, keyFile string, config *benchConfig) (*tls.Config, error) {
+	if clusterConfigPath != "" {
+		cluster, err := core.LoadClusterConfig(clusterConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		if cluster.TLS != nil && caFile == "" {
+			caFile, certFile, keyFile = cluster.TLS.CAFile, cluster.TLS.CertFile, cluster.TLS.KeyFile
+		}
+		for _, endpoint := range cluster.HttpServers {
+			config.Endpoints = append(config.Endpoints, endpoint)
+		}
+		sort.Strings(config.Endpoints)
+	} else if endpoints != "" {
+		for _, endpoint := range strings.Split(endpoints, ",") {
+			config.Endpoints = append(config.Endpoints, strings.TrimSpace(endpoint))
+		}
+	} else {
+		return nil, fmt.Errorf("either cluster_config_path or endpoints must be specified")
+	}
+	if caFile == "" {
+		return nil, nil
+	}
+	return security.LoadClientConfig(caFile, certFile, keyFile)
+}
+
+// bench runs the benchmark for given duration, or until config.Requests requests are sent if it is set.
+func bench(c *client.Client, config *benchConfig, duration time.Duration) (*benchResult, error) {
+	if config.Prepopulate {
+		if err := benchPrepopulate(c, config); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if config.Requests == 0 {
+		ctx, cancel = context.WithTimeout(ctx, duration)
+		defer cancel()
+	}
+
+	// With a rate, workers take a token before each request, tokens are sent at evenly spaced times.
+	var tokens chan struct{}
+	if config.Rate > 0 {
+		tokens = make(chan struct{})
+		go func() {
+			interval := time.Duration(float64(time.Second) / config.Rate)
+			next := time.Now()
+			for {
+				if d := time.Until(next); d > 0 {
+					time.Sleep(d)
+				}
+				select {
+				case tokens <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+				next = next.Add(interval)
+			}
+		}()
+	}
+
+	fmt.Fprintf(os.Stderr, "Benchmarking %s with %d workers...\n", strings.Join(config.Endpoints, ","), config.Concurrency)
+	var sent int64
+	reads := make([]*benchRecorder, config.Concurrency)
+	writes := make([]*benchRecorder, config.Concurrency)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < config.Concurrency; i++ {
+		reads[i], writes[i] = newBenchRecorder(), newBenchRecorder()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(time.Now().UnixNano() + int64(i)))
+			nextKey := benchKeyPicker(rnd, config)
+			value := make([]byte, config.ValueSize)
+			if config.ValueSizeMax > 0 {
+				value = make([]byte, config.ValueSizeMax)
+			}
+			rnd.Read(value)
+			for ctx.Err() == nil {
+				if config.Requests > 0 && atomic.AddInt64(&sent, 1) > config.Requests {
+					return
+				}
+				if tokens != nil {
+					select {
+					case <-tokens:
+					case <-ctx.Done():
+						return
+					}
+				}
+				key := nextKey()
+				if rnd.Float64() < config.ReadRatio {
+					begin := time.Now()
+					_, err := c.Get(ctx, key)
+					if ctx.Err() == nil {
+						reads[i].record(time.Since(begin), err)
+					}
+					continue
+				}
+				size := config.ValueSize
+				if config.ValueSizeMax > 0 {
+					size += rnd.Intn(config.ValueSizeMax - config.ValueSize + 1)
+				}
+				begin := time.Now()
+				err := c.Put(ctx, key, value[:size])
+				if ctx.Err() == nil {
+					writes[i].record(time.Since(begin), err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	result := &benchResult{
+		Config:          *config,
+		StartTime:       start,
+		DurationSeconds: elapsed.Seconds(),
+		Reads:           mergeBenchStats(reads, elapsed),
+		Writes:          mergeBenchStats(writes, elapsed),
+		Total:           mergeBenchStats(append(append([]*benchRecorder(nil), reads...), writes...), elapsed),
+	}
+	return result, nil
+}
+
+// benchKeyPicker returns a function that picks the next key.
+func benchKeyPicker(rnd *rand.Rand, config *benchConfig) func() string {
+	width := len(fmt.Sprint(config.Keys - 1))
+	format := fmt.Sprintf("%s%%0%dd", config.KeyPrefix, width)
+	if config.KeyDistribution == "zipfian" {
+		zipf := rand.NewZipf(rnd, config.ZipfS, 1, uint64(config.Keys-1))
+		return func() string { return fmt.Sprintf(format, zipf.Uint64()) }
+	}
+	return func() string { return fmt.Sprintf(format, rnd.Intn(config.Keys)) }
+}
+
+// benchPrepopulate writes all keys, in batches.
+func benchPrepopulate(c *client.Client, config *benchConfig) error {
+	const batchSize = 100
+	fmt.Fprintf(os.Stderr, "Writing %d keys...\n", config.Keys)
+	width := len(fmt.Sprint(config.Keys - 1))
+	value := make([]byte, config.ValueSize)
+	rand.Read(value)
+	for from := 0; from < config.Keys; from += batchSize {
+		kvs := make(map[string][]byte)
+		for i := from; i < from+batchSize && i < config.Keys; i++ {
+			kvs[fmt.Sprintf("%s%0*d", config.KeyPrefix, width, i)] = value
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err := c.PutAll(ctx, kvs)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to prepopulate keys: %v", err)
+		}
+	}
+	return nil
+}
+
+// mergeBenchStats computes the statistics of the results recorded by all workers.
+func mergeBenchStats(recorders []*benchRecorder, elapsed time.Duration) *opStats {
+	stats := &opStats{ErrorTypes: make(map[string]int64)}
+	var latencies []time.Duration
+	for _, r := range recorders {
+		latencies = append(latencies, r.latencies...)
+		for typ, n := range r.errors {
+			stats.ErrorTypes[typ] += n
+			stats.Errors += n
+		}
+	}
+	stats.Requests = int64(len(latencies)) + stats.Errors
+	stats.Throughput = float64(len(latencies)) / elapsed.Seconds()
+	if len(latencies) == 0 {
+		return stats
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var sum time.Duration
+	for _, l := range latencies {
+		sum += l
+	}
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	percentile := func(p float64) float64 {
+		i := int(p*float64(len(latencies))+0.5) - 1
+		if i < 0 {
+			i = 0
+		}
+		if i >= len(latencies) {
+			i = len(latencies) - 1
+		}
+		return ms(latencies[i])
+	}
+	stats.Latency = latencyStats{
+		Mean: ms(sum / time.Duration(len(latencies))),
+		P50:  percentile(0.5),
+		P90:  percentile(0.9),
+		P95:  percentile(0.95),
+		P99:  percentile(0.99),
+		P999: percentile(0.999),
+		Max:  ms(latencies[len(latencies)-1]),
+	}
+	return stats
+}
+
+func printBenchResult(out io.Writer, result *benchResult) error {
+	fmt.Fprintf(out, "Duration:   %.2fs\n", result.DurationSeconds)
+	fmt.Fprintf(out, "Throughput: %.1f ops/s\n\n", result.Total.Throughput)
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "\tREQUESTS\tERRORS\tOPS/S\tMEAN\tP50\tP90\tP95\tP99\tP99.9\tMAX\t")
+	for _, op := range []struct {
+		name  string
+		stats *opStats
+	}{{"read", result.Reads}, {"write", result.Writes}, {"total", result.Total}} {
+		s, l := op.stats, op.stats.Latency
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f\t%.2fms\t%.2fms\t%.2fms\t%.2fms\t%.2fms\t%.2fms\t%.2fms\t\n", op.name,
+			s.Requests, s.Errors, s.Throughput, l.Mean, l.P50, l.P90, l.P95, l.P99, l.P999, l.Max)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(result.Total.ErrorTypes) > 0 {
+		var types []string
+		for typ, n := range result.Total.ErrorTypes {
+			types = append(types, fmt.Sprintf("%s=%d", typ, n))
+		}
+		sort.Strings(types)
+		fmt.Fprintf(out, "\nErrors: %s\n", strings.Join(types, " "))
+	}
+	return nil
+}
+
+func writeBenchJSON(path string, result *benchResult) error {
+	if path == "-" {
+		return writeJSON(os.Stdout, result)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(f, result); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
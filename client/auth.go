@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// User is a user of the cluster.
+type User struct {
+	Name     string   `json:"name"`
+	Password string   `json:"password,omitempty"` // Only set when adding or updating a user.
+	Roles    []string `json:"roles"`
+}
+
+// Role grants permissions to the users that have it.
+type Role struct {
+	Name        string       `json:"name"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// Permission grants access to keys starting with Prefix (all keys if it is empty).
+type Permission struct {
+	Prefix string `json:"prefix"`
+	Type   string `json:"type"` // One of: read, write, readwrite.
+}
+
+// Token is a token issued for a user.
+type Token struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// The changes below are proposed through the leader by whichever server receives them, they require the root role
+// once authentication is enabled.
+
+// EnableAuth enables authentication, the "root" user with the "root" role must have been added.
+func (c *Client) EnableAuth(ctx context.Context) error {
+	return c.authRequest(ctx, http.MethodPost, "/enable", struct{}{}, nil)
+}
+
+// DisableAuth disables authentication, and revokes all issued tokens.
+func (c *Client) DisableAuth(ctx context.Context) error {
+	return c.authRequest(ctx, http.MethodPost, "/disable", struct{}{}, nil)
+}
+
+// PutUser adds or updates a user, the password of an existing user is unchanged if it is empty.
+func (c *Client) PutUser(ctx context.Context, user User) error {
+	return c.authRequest(ctx, http.MethodPut, "/users/"+url.PathEscape(user.Name), user, nil)
+}
+
+// DeleteUser deletes a user.
+func (c *Client) DeleteUser(ctx context.Context, name string) error {
+	return c.authRequest(ctx, http.MethodDelete, "/users/"+url.PathEscape(name), nil, nil)
+}
+
+// Users lists the users.
+func (c *Client) Users(ctx context.Context) ([]User, error) {
+	var users []User
+	if err := c.authRequest(ctx, http.MethodGet, "/users", nil, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// PutRole adds or updates a role.
+func (c *Client) PutRole(ctx context.Context, role Role) error {
+	return c.authRequest(ctx, http.MethodPut, "/roles/"+url.PathEscape(role.Name), role, nil)
+}
+
+// DeleteRole deletes a role.
+func (c *Client) DeleteRole(ctx context.Context, name string) error {
+	return c.authRequest(ctx, http.MethodDelete, "/roles/"+url.PathEscape(name), nil, nil)
+}
+
+// Roles lists the roles.
+func (c *Client) Roles(ctx context.Context) ([]Role, error) {
+	var roles []Role
+	if err := c.authRequest(ctx, http.MethodGet, "/roles", nil, &roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// IssueToken returns a token for the client's user name and password, which expires after ttl (an hour if 0).
+func (c *Client) IssueToken(ctx context.Context, ttl time.Duration) (*Token, error) {
+	token := &Token{}
+	req := struct {
+		TTLSeconds int64 `json:"ttlSeconds"`
+	}{int64(ttl / time.Second)}
+	if err := c.authRequest(ctx, http.MethodPost, "/token", req, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// authRequest sends a request to the authentication API, with a JSON body if in is not nil, and decodes the response
+// into out if it is not nil.
+func (c *Client) authRequest(ctx context.Context, method string, path string, in interface{}, out interface{}) error {
+	return c.withLeader(ctx, method != http.MethodGet, func(endpoint string) error {
+		var body []byte
+		var err error
+		if in != nil {
+			body, err = c.doJSON(ctx, method, endpoint, authPath+path, in)
+		} else {
+			body, err = c.do(ctx, method, endpoint, authPath+path, nil, nil)
+		}
+		if err != nil || out == nil {
+			return err
+		}
+		return decodeJSON(endpoint, body, out)
+	})
+}
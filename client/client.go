@@ -0,0 +1,482 @@
+// Package client is a Go client of a konsen cluster, it talks to the HTTP API of the servers.
+//
+// The client finds the current leader among the configured endpoints and sends requests to it, and retries a request
+// with exponential backoff when it fails because of a leader change, an unreachable server, a missing quorum or a
+// timeout. Writes are not idempotent, so they are only retried when they have certainly not taken effect: the request
+// never reached a server, or the server rejected it before appending it to the log (ErrNotLeader or ErrOverloaded). A
+// write that fails otherwise, e.g. with ErrTimeout, may or may not have taken effect. Reads whose consistency allows
+// any server to serve them are spread over all endpoints instead.
+//
+// The client keeps the highest revision of the writes and reads it has done, with ReadYourWrites its reads wait for
+// the server to apply that revision, so that they observe its own writes even when served by a follower.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRequestTimeout = 10 * time.Second
+	defaultMaxRetries     = 10
+	defaultInitialBackoff = 50 * time.Millisecond
+	defaultMaxBackoff     = 2 * time.Second
+)
+
+// HTTP API of the servers.
+const (
+	servicePath        = "/konsen"
+	scanPath           = "/konsen/scan"
+	statusPath         = "/status"
+	membersPath        = "/admin/members"
+	transferLeaderPath = "/admin/transfer-leader"
+	authPath           = "/v2/auth"
+
+	revisionHeader            = "X-Konsen-Revision"
+	errorCodeHeader           = "X-Konsen-Error"
+	errorCodeNoQuorum         = "no_quorum"
+	errorCodeNotLeader        = "not_leader"
+	errorCodeTimeout          = "timeout"
+	errorCodeOverloaded       = "overloaded"
+	errorCodeUnauthenticated  = "unauthenticated"
+	errorCodePermissionDenied = "permission_denied"
+)
+
+// Status is the Raft status of a server.
+type Status struct {
+	Server      string `json:"server"`               // Server name.
+	Role        string `json:"role"`                 // Current role.
+	Term        uint64 `json:"term"`                 // Current term.
+	Leader      string `json:"leader"`               // Current leader, empty if unknown.
+	LeaderHttp  string `json:"leaderHttp,omitempty"` // HTTP endpoint of current leader.
+	CommitIndex uint64 `json:"commitIndex"`          // Index of highest log entry known to be committed.
+	LastApplied uint64 `json:"lastApplied"`          // Index of highest log entry applied to state machine.
+	Corruption  string `json:"corruption,omitempty"` // Log corruption detected on the server, if any.
+}
+
+// Member is a server in the cluster.
+type Member struct {
+	Name         string `json:"name"`         // Server name.
+	Endpoint     string `json:"endpoint"`     // Raft (gRPC) endpoint.
+	HttpEndpoint string `json:"httpEndpoint"` // HTTP endpoint.
+}
+
+// KeyValue is a key-value pair.
+type KeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// ClientConfig
+type ClientConfig struct {
+	Endpoints      []string      // HTTP endpoints of (some of) the servers in the cluster.
+	RequestTimeout time.Duration // Timeout of each attempt of a request, defaults to 10s.
+	MaxRetries     int           // Maximum number of retries of a request, defaults to 10, negative disables retries.
+	InitialBackoff time.Duration // Wait time before the first retry, doubled for each following retry, defaults to 50ms.
+	MaxBackoff     time.Duration // Maximum wait time between retries, defaults to 2s.
+	TLS            *tls.Config   // TLS of connections to servers, plaintext if nil.
+	Username       string        // User name to authenticate as, if the cluster has authentication enabled.
+	Password       string        // Password of the user.
+	Token          string        // Token to authenticate with instead of user name and password.
+	// Consistency of Get and Scan, one of: linearizable (default), leader-local, stale, bounded-staleness=<duration>
+	// and bounded-staleness=<index lag>. Stale and bounded staleness reads are sent to a random endpoint, and to the
+	// leader if that server can not serve them.
+	ReadConsistency string
+	// Whether Get and Scan observe the writes and reads done with this client before, on any server: a server serves
+	// them once it has applied the log up to Revision, and the leader serves them if that server is too far behind.
+	ReadYourWrites bool
+}
+
+// Client is a client of a konsen cluster, it is safe for concurrent use.
+type Client struct {
+	endpoints      []string
+	scheme         string
+	httpClient     *http.Client
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	username       string
+	password       string
+	token          string
+
+	readConsistency string
+	anyServerReads  bool // Whether reads can be served by any server.
+	readYourWrites  bool
+
+	mu       sync.Mutex
+	leader   string // HTTP endpoint of current leader, empty if unknown.
+	revision uint64 // Highest revision of the writes and reads done with the client.
+}
+
+// NewClient creates a new client.
+func NewClient(config ClientConfig) (*Client, error) {
+	if len(config.Endpoints) == 0 {
+		return nil, fmt.Errorf("no endpoint is specified")
+	}
+	c := &Client{
+		endpoints:      config.Endpoints,
+		scheme:         "http",
+		httpClient:     &http.Client{Timeout: config.RequestTimeout},
+		maxRetries:     config.MaxRetries,
+		initialBackoff: config.InitialBackoff,
+		maxBackoff:     config.MaxBackoff,
+		username:       config.Username,
+		password:       config.Password,
+		token:          config.Token,
+
+		readConsistency: config.ReadConsistency,
+		anyServerReads:  config.ReadConsistency == "stale" || strings.HasPrefix(config.ReadConsistency, "bounded-staleness="),
+		readYourWrites:  config.ReadYourWrites,
+	}
+	if config.TLS != nil {
+		c.scheme = "https"
+		c.httpClient.Transport = &http.Transport{TLSClientConfig: config.TLS}
+	}
+	if c.httpClient.Timeout == 0 {
+		c.httpClient.Timeout = defaultRequestTimeout
+	}
+	if c.readConsistency == "" {
+		// Servers read stale by default on the endpoints the client uses.
+		c.readConsistency = "linearizable"
+	}
+	if c.maxRetries == 0 {
+		c.maxRetries = defaultMaxRetries
+	}
+	if c.initialBackoff == 0 {
+		c.initialBackoff = defaultInitialBackoff
+	}
+	if c.maxBackoff == 0 {
+		c.maxBackoff = defaultMaxBackoff
+	}
+	return c, nil
+}
+
+// Get returns the value of the key, or nil if the key does not exist.
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := c.withReadEndpoint(ctx, func(endpoint string) error {
+		body, err := c.do(ctx, http.MethodGet, endpoint, servicePath, c.readQuery(url.Values{"key": {key}}), nil)
+		if err != nil {
+			return err
+		}
+		value = body
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(value) == 0 {
+		return nil, nil
+	}
+	return value, nil
+}
+
+// Put sets the value of the key, it returns after the change is committed and applied on the leader.
+func (c *Client) Put(ctx context.Context, key string, value []byte) error {
+	return c.PutAll(ctx, map[string][]byte{key: value})
+}
+
+// PutAll sets the values of the keys atomically, it returns after the change is committed and applied on the leader.
+func (c *Client) PutAll(ctx context.Context, kvs map[string][]byte) error {
+	form := url.Values{}
+	for k, v := range kvs {
+		form.Set(k, string(v))
+	}
+	return c.withLeader(ctx, true, func(endpoint string) error {
+		_, err := c.do(ctx, http.MethodPost, endpoint, servicePath, nil, form)
+		return err
+	})
+}
+
+// Delete deletes the key, it returns after the change is committed and applied on the leader.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.withLeader(ctx, true, func(endpoint string) error {
+		_, err := c.do(ctx, http.MethodDelete, endpoint, servicePath, url.Values{"key": {key}}, nil)
+		return err
+	})
+}
+
+// Scan returns key-value pairs with keys starting with prefix in key order, at most limit pairs are returned if it is
+// positive.
+func (c *Client) Scan(ctx context.Context, prefix string, limit int) ([]KeyValue, error) {
+	var kvs []KeyValue
+	query := c.readQuery(url.Values{"prefix": {prefix}, "limit": {strconv.Itoa(limit)}})
+	err := c.withReadEndpoint(ctx, func(endpoint string) error {
+		body, err := c.do(ctx, http.MethodGet, endpoint, scanPath, query, nil)
+		if err != nil {
+			return err
+		}
+		return decodeJSON(endpoint, body, &kvs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return kvs, nil
+}
+
+// TransferLeadership transfers leadership to the given server, it returns after the server has been elected.
+func (c *Client) TransferLeadership(ctx context.Context, server string) error {
+	// Transferring leadership to the same server again is harmless, so it is retried like a read.
+	err := c.withLeader(ctx, false, func(endpoint string) error {
+		_, err := c.do(ctx, http.MethodPost, endpoint, transferLeaderPath, url.Values{"target": {server}}, nil)
+		return err
+	})
+	c.resetLeader()
+	return err
+}
+
+// Status returns the status of the server at the given HTTP endpoint, it is not retried.
+func (c *Client) Status(ctx context.Context, endpoint string) (*Status, error) {
+	body, err := c.do(ctx, http.MethodGet, endpoint, statusPath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	status := &Status{}
+	if err := decodeJSON(endpoint, body, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// Members returns the servers in the cluster, as configured on any reachable server.
+func (c *Client) Members(ctx context.Context) ([]Member, error) {
+	var errs []string
+	for _, endpoint := range c.endpoints {
+		body, err := c.do(ctx, http.MethodGet, endpoint, membersPath, nil, nil)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		var members []Member
+		if err := decodeJSON(endpoint, body, &members); err != nil {
+			return nil, err
+		}
+		return members, nil
+	}
+	return nil, fmt.Errorf("failed to list members from any endpoint: %s", strings.Join(errs, "; "))
+}
+
+// Leader returns the HTTP endpoint of current leader.
+func (c *Client) Leader(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	leader := c.leader
+	c.mu.Unlock()
+	if leader != "" {
+		return leader, nil
+	}
+
+	// Prefer the server that claims to be the leader with the highest term, over the leader known by other servers.
+	var errs []string
+	var leaderTerm uint64
+	for _, endpoint := range c.endpoints {
+		status, err := c.Status(ctx, endpoint)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if status.Role == "LEADER" && status.Term >= leaderTerm {
+			leader, leaderTerm = endpoint, status.Term
+		} else if leader == "" {
+			leader = status.LeaderHttp
+		}
+	}
+	if leader == "" {
+		msg := "no leader is elected"
+		if len(errs) > 0 {
+			msg = fmt.Sprintf("no leader is found: %s", strings.Join(errs, "; "))
+		}
+		return "", &Error{kind: ErrNoQuorum, msg: msg}
+	}
+
+	c.mu.Lock()
+	c.leader = leader
+	c.mu.Unlock()
+	return leader, nil
+}
+
+func (c *Client) resetLeader() {
+	c.mu.Lock()
+	c.leader = ""
+	c.mu.Unlock()
+}
+
+// withLeader runs fn with the endpoint of current leader, and retries with backoff on retryable errors. If write is
+// true, fn is only retried if it failed without taking effect.
+func (c *Client) withLeader(ctx context.Context, write bool, fn func(endpoint string) error) error {
+	backoff := c.initialBackoff
+	for retries := 0; ; retries++ {
+		leader, err := c.Leader(ctx)
+		retryable := isRetryable
+		if err == nil {
+			err = fn(leader)
+			if write {
+				retryable = isRetryableWrite
+			}
+		}
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return contextError(ctx.Err())
+		}
+		if !retryable(err) || retries >= c.maxRetries {
+			return err
+		}
+		// Leader may have changed or is down.
+		c.resetLeader()
+
+		// Wait at least half of the backoff, so that retries span a leader election.
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-ctx.Done():
+			return contextError(ctx.Err())
+		case <-time.After(wait):
+		}
+		if backoff *= 2; backoff > c.maxBackoff {
+			backoff = c.maxBackoff
+		}
+	}
+}
+
+// withReadEndpoint runs a read with fn: on a random endpoint if any server can serve it, and otherwise (or if that
+// fails) with withLeader.
+func (c *Client) withReadEndpoint(ctx context.Context, fn func(endpoint string) error) error {
+	if c.anyServerReads {
+		err := fn(c.endpoints[rand.Intn(len(c.endpoints))])
+		if err == nil || !isRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+	}
+	return c.withLeader(ctx, false, fn)
+}
+
+// readQuery adds the read consistency to the query of a read.
+func (c *Client) readQuery(query url.Values) url.Values {
+	query.Set("consistency", c.readConsistency)
+	if revision := c.Revision(); c.readYourWrites && revision > 0 {
+		query.Set("min_index", strconv.FormatUint(revision, 10))
+	}
+	return query
+}
+
+// Revision returns the highest revision of the writes and reads done with the client, it can be passed to
+// ObserveRevision of another client to continue the session there.
+func (c *Client) Revision() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.revision
+}
+
+// ObserveRevision records that the session of the client has observed the given revision, reads with ReadYourWrites
+// wait for it to be applied.
+func (c *Client) ObserveRevision(revision uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if revision > c.revision {
+		c.revision = revision
+	}
+}
+
+// do sends a request to the server at endpoint, and returns the response body if successful.
+func (c *Client) do(ctx context.Context, method string, endpoint string, path string, query url.Values, form url.Values) ([]byte, error) {
+	u := url.URL{Scheme: c.scheme, Host: endpoint, Path: path, RawQuery: query.Encode()}
+	var req *http.Request
+	var err error
+	if form != nil {
+		req, err = http.NewRequestWithContext(ctx, method, u.String(), strings.NewReader(form.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		req, err = http.NewRequestWithContext(ctx, method, u.String(), nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.send(ctx, endpoint, req)
+}
+
+// doJSON sends a request with a JSON body to the server at endpoint, and returns the response body if successful.
+func (c *Client) doJSON(ctx context.Context, method string, endpoint string, path string, body interface{}) ([]byte, error) {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	u := url.URL{Scheme: c.scheme, Host: endpoint, Path: path}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.send(ctx, endpoint, req)
+}
+
+// send sends the request with the client's credentials, and returns the response body if successful.
+func (c *Client) send(ctx context.Context, endpoint string, req *http.Request) ([]byte, error) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, contextError(ctx.Err())
+		}
+		// The request is not sent if the connection can not be established, so it is safe to retry even for writes.
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return nil, &Error{kind: errUnavailable, msg: err.Error(), Endpoint: endpoint, notSent: true}
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, &Error{kind: ErrTimeout, msg: err.Error(), Endpoint: endpoint}
+		}
+		return nil, &Error{kind: errUnavailable, msg: err.Error(), Endpoint: endpoint}
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &Error{kind: errUnavailable, msg: fmt.Sprintf("failed to read response: %v", err), Endpoint: endpoint}
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(body))
+		// Errors of the JSON API are {"error": {"code": ..., "message": ...}}.
+		var v2Error struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(body, &v2Error) == nil && v2Error.Error.Message != "" {
+			msg = v2Error.Error.Message
+		}
+		if msg == "" {
+			msg = resp.Status
+		}
+		return nil, &Error{kind: errorKind(resp), msg: msg, Endpoint: endpoint, StatusCode: resp.StatusCode}
+	}
+	if revision, err := strconv.ParseUint(resp.Header.Get(revisionHeader), 10, 64); err == nil {
+		c.ObserveRevision(revision)
+	}
+	return body, nil
+}
+
+func decodeJSON(endpoint string, body []byte, v interface{}) error {
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to decode response from %s: %v", endpoint, err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestServer starts a server that claims to be the leader, and fails every write with the given error code.
+func newTestServer(t *testing.T, errorCode string, writes *int32) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == statusPath:
+			json.NewEncoder(w).Encode(&Status{Role: "LEADER", Term: 1})
+		case r.URL.Path == servicePath && r.Method == http.MethodPost:
+			atomic.AddInt32(writes, 1)
+			w.Header().Set(errorCodeHeader, errorCode)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestWriteRetries(t *testing.T) {
+	for _, tc := range []struct {
+		errorCode string
+		want      error
+		attempts  int32
+	}{
+		{errorCodeNotLeader, ErrNotLeader, 3},
+		{errorCodeOverloaded, ErrOverloaded, 3},
+		// The write may have been appended, retrying it could apply it twice.
+		{errorCodeTimeout, ErrTimeout, 1},
+		{errorCodeNoQuorum, ErrNoQuorum, 1},
+	} {
+		t.Run(tc.errorCode, func(t *testing.T) {
+			var writes int32
+			c, err := NewClient(ClientConfig{
+				Endpoints:      []string{newTestServer(t, tc.errorCode, &writes)},
+				MaxRetries:     2,
+				InitialBackoff: time.Millisecond,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Put(context.Background(), "k", []byte("v")); !errors.Is(err, tc.want) {
+				t.Fatalf("got error %v, want %v", err, tc.want)
+			}
+			if writes != tc.attempts {
+				t.Fatalf("got %d attempts, want %d", writes, tc.attempts)
+			}
+		})
+	}
+}
+
+// A write to a server that refuses the connection has not been sent, so it is retried.
+func TestWriteRetriedWhenNotSent(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := l.Addr().String()
+	l.Close()
+
+	c, err := NewClient(ClientConfig{Endpoints: []string{endpoint}, MaxRetries: 2, InitialBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, "http://"+endpoint+servicePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.send(context.Background(), endpoint, req); !isRetryableWrite(err) {
+		t.Fatalf("got error %v, want a retryable write error", err)
+	}
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// ErrNotLeader is returned when the request reached a server that can not serve it as the leader, e.g. the
+	// leader is transferring leadership. The request has not taken effect, it is retried after looking up the leader
+	// again.
+	ErrNotLeader = errors.New("not leader")
+	// ErrNoQuorum is returned when no leader is elected or the leader is not reachable, the cluster can not make
+	// progress until a quorum of servers is up A write that fails with it may still take effect, if the leader has received it.
+	ErrNoQuorum = errors.New("no quorum")
+	// ErrTimeout is returned when a request, or the context of a call, times out. A timed out write may still take
+	// effect later.
+	ErrTimeout = errors.New("timeout")
+	// ErrOverloaded is returned when the leader rejects a request because too many requests are pending, the request
+	// has not taken effect. It is retried with backoff.
+	ErrOverloaded = errors.New("overloaded")
+	// ErrUnauthenticated is returned when the cluster has authentication enabled and the client has no valid
+	// credentials.
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrPermissionDenied is returned when the user is not allowed to do the operation.
+	ErrPermissionDenied = errors.New("permission denied")
+
+	// errUnavailable is returned when a server is not reachable.
+	errUnavailable = errors.New("unavailable")
+)
+
+// Error is an error returned by a request, it matches one of ErrNotLeader, ErrNoQuorum, ErrTimeout,
+// ErrOverloaded, ErrUnauthenticated and ErrPermissionDenied with errors.Is if the cause is known.
+type Error struct {
+	Endpoint   string // HTTP endpoint of the server the request was sent to, if any.
+	StatusCode int    // HTTP status code of the response, 0 if there is no response.
+
+	kind    error
+	msg     string
+	notSent bool // The request has not been sent to the server, e.g. the connection is refused.
+}
+
+func (e *Error) Error() string {
+	if e.Endpoint == "" {
+		return e.msg
+	}
+	return fmt.Sprintf("%s: %s", e.Endpoint, e.msg)
+}
+
+func (e *Error) Unwrap() error {
+	return e.kind
+}
+
+// errorKind returns the cause of an unsuccessful response.
+func errorKind(resp *http.Response) error {
+	switch resp.Header.Get(errorCodeHeader) {
+	case errorCodeNoQuorum:
+		return ErrNoQuorum
+	case errorCodeNotLeader:
+		return ErrNotLeader
+	case errorCodeTimeout:
+		return ErrTimeout
+	case errorCodeOverloaded:
+		return ErrOverloaded
+	case errorCodeUnauthenticated:
+		return ErrUnauthenticated
+	case errorCodePermissionDenied:
+		return ErrPermissionDenied
+	}
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		return errUnavailable
+	}
+	return nil
+}
+
+// contextError converts an error of a done context, the returned error matches both ErrTimeout and the original error
+// if the deadline is exceeded.
+func contextError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &Error{kind: deadlineExceeded{}, msg: err.Error()}
+	}
+	return err
+}
+
+// deadlineExceeded matches both ErrTimeout and context.DeadlineExceeded.
+type deadlineExceeded struct{}
+
+func (deadlineExceeded) Error() string {
+	return context.DeadlineExceeded.Error()
+}
+
+func (deadlineExceeded) Is(target error) bool {
+	return target == ErrTimeout || target == context.DeadlineExceeded
+}
+
+// isRetryable returns true if a read may succeed if retried.
+func isRetryable(err error) bool {
+	return errors.Is(err, ErrNotLeader) ||
+		errors.Is(err, ErrNoQuorum) ||
+		errors.Is(err, ErrTimeout) ||
+		errors.Is(err, ErrOverloaded) ||
+		errors.Is(err, errUnavailable)
+}
+
+// isRetryableWrite returns true if a write has certainly not taken effect, and may succeed if retried.
+func isRetryableWrite(err error) bool {
+	var e *Error
+	if errors.As(err, &e) && e.notSent {
+		return true
+	}
+	return errors.Is(err, ErrNotLeader) || errors.Is(err, ErrOverloaded)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lizhaoliu/konsen/v2/client"
+)
+
+func (c *ctl) auth(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: auth enable|disable|token")
+	}
+	switch args[0] {
+	case "enable":
+		return c.client.EnableAuth(ctx)
+	case "disable":
+		return c.client.DisableAuth(ctx)
+	case "token":
+		var ttl time.Duration
+		fs := flag.NewFlagSet("auth token", flag.ExitOnError)
+		fs.DurationVar(&ttl, "ttl", time.Hour, "Lifetime of the token.")
+		fs.Parse(args[1:])
+		t, err := c.client.IssueToken(ctx, ttl)
+		if err != nil {
+			return err
+		}
+		if output == "json" {
+			return writeJSON(os.Stdout, t)
+		}
+		fmt.Println(t.Token)
+		return nil
+	default:
+		return fmt.Errorf("unknown auth command %q", args[0])
+	}
+}
+
+func (c *ctl) user(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: user list|put|delete")
+	}
+	switch args[0] {
+	case "list":
+		users, err := c.client.Users(ctx)
+		if err != nil {
+			return err
+		}
+		if output == "json" {
+			return writeJSON(os.Stdout, users)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tROLES")
+		for _, u := range users {
+			fmt.Fprintf(w, "%s\t%s\n", u.Name, strings.Join(u.Roles, ","))
+		}
+		return w.Flush()
+	case "put":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: user put <name> [--password p] [--roles r1,r2]")
+		}
+		var password, roles string
+		fs := flag.NewFlagSet("user put", flag.ExitOnError)
+		fs.StringVar(&password, "password", "", "Password, unchanged for an existing user if empty.")
+		fs.StringVar(&roles, "roles", "", "Comma separated roles.")
+		fs.Parse(args[2:])
+		u := client.User{Name: args[1], Password: password, Roles: []string{}}
+		if roles != "" {
+			u.Roles = strings.Split(roles, ",")
+		}
+		return c.client.PutUser(ctx, u)
+	case "delete":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: user delete <name>")
+		}
+		return c.client.DeleteUser(ctx, args[1])
+	default:
+		return fmt.Errorf("unknown user command %q", args[0])
+	}
+}
+
+func (c *ctl) role(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: role list|put|delete")
+	}
+	switch args[0] {
+	case "list":
+		roles, err := c.client.Roles(ctx)
+		if err != nil {
+			return err
+		}
+		if output == "json" {
+			return writeJSON(os.Stdout, roles)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tPERMISSIONS")
+		for _, r := range roles {
+			var perms []string
+			for _, p := range r.Permissions {
+				perms = append(perms, p.Type+":"+p.Prefix)
+			}
+			fmt.Fprintf(w, "%s\t%s\n", r.Name, strings.Join(perms, " "))
+		}
+		return w.Flush()
+	case "put":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: role put <name> [read|write|readwrite:prefix]...")
+		}
+		r := client.Role{Name: args[1], Permissions: []client.Permission{}}
+		for _, arg := range args[2:] {
+			i := strings.IndexByte(arg, ':')
+			if i < 0 {
+				return fmt.Errorf("invalid permission %q, expecting <type>:<prefix>", arg)
+			}
+			r.Permissions = append(r.Permissions, client.Permission{Type: arg[:i], Prefix: arg[i+1:]})
+		}
+		return c.client.PutRole(ctx, r)
+	case "delete":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: role delete <name>")
+		}
+		return c.client.DeleteRole(ctx, args[1])
+	default:
+		return fmt.Errorf("unknown role command %q", args[0])
+	}
+}
@@ -0,0 +1,324 @@
+// Command konsenctl is a command-line client of a konsen cluster.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lizhaoliu/konsen/v2/client"
+	"github.com/lizhaoliu/konsen/v2/core"
+	"github.com/lizhaoliu/konsen/v2/security"
+)
+
+const usage = `Usage: konsenctl [flags] <command> [args]
+
+Commands:
+  get <key>                  Print the value of a key.
+  put <key> <value>          Set the value of a key.
+  delete <key>               Delete a key.
+  scan [--prefix p] [--limit n]
+                             Print key-value pairs with keys starting with prefix.
+  status                     Print role, term, leader, commit and applied index of each server.
+  members                    List the servers in the cluster.
+  transfer-leader <server>   Transfer leadership to the given server.
+  auth enable|disable        Enable or disable authentication.
+  auth token [--ttl d]       Print a token of the user, for --token.
+  user list                  List users.
+  user put <name> [--password p] [--roles r1,r2]
+                             Add or update a user.
+  user delete <name>         Delete a user.
+  role list                  List roles.
+  role put <name> [read|write|readwrite:prefix]...
+                             Add or update a role with permissions on key prefixes.
+  role delete <name>         Delete a role.
+
+Flags:
+`
+
+var (
+	clusterConfigPath string
+	endpoints         string
+	output            string
+	timeout           time.Duration
+	caFile            string
+	certFile          string
+	keyFile           string
+	user              string
+	token             string
+	consistency       string
+	minIndex          uint64
+)
+
+func init() {
+	flag.StringVar(&clusterConfigPath, "cluster_config_path", "", "Cluster configuration file path, HTTP endpoints of servers are taken from it.")
+	flag.StringVar(&endpoints, "endpoints", "", "Comma separated HTTP endpoints of servers, used if cluster_config_path is unspecified.")
+	flag.StringVar(&output, "output", "table", "Output format, one of: table, json.")
+	flag.DurationVar(&timeout, "timeout", 10*time.Second, "Timeout of each request.")
+	flag.StringVar(&caFile, "ca_file", "", "CA certificates to verify servers with, enables TLS. Defaults to the one in cluster configuration.")
+	flag.StringVar(&certFile, "cert_file", "", "Client certificate, for servers that require mutual TLS. Defaults to the one in cluster configuration.")
+	flag.StringVar(&keyFile, "key_file", "", "Private key of the client certificate.")
+	flag.StringVar(&user, "user", "", "User name and password to authenticate with, as <name>:<password>.")
+	flag.StringVar(&token, "token", "", "Token to authenticate with, instead of user.")
+	flag.StringVar(&consistency, "consistency", "linearizable", "Consistency of get and scan, one of: linearizable, leader-local, stale, bounded-staleness=<duration or index lag>.")
+	flag.Uint64Var(&minIndex, "min_index", 0, "Log index get and scan wait for the server to apply, e.g. the revision of a put printed with --output json.")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+}
+
+// ctl sends requests to the servers of a cluster.
+type ctl struct {
+	client  *client.Client
+	members []client.Member // Servers in the cluster, sorted by name.
+}
+
+// newCtl resolves the servers in the cluster either from cluster config file or from any of the given endpoints.
+func newCtl(ctx context.Context) (*ctl, error) {
+	var members []client.Member
+	var httpEndpoints []string
+	ca, cert, key := caFile, certFile, keyFile
+	if clusterConfigPath != "" {
+		cluster, err := core.LoadClusterConfig(clusterConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		if cluster.TLS != nil && ca == "" {
+			ca, cert, key = cluster.TLS.CAFile, cluster.TLS.CertFile, cluster.TLS.KeyFile
+		}
+		for name, endpoint := range cluster.Servers {
+			members = append(members, client.Member{
+				Name:         name,
+				Endpoint:     endpoint,
+				HttpEndpoint: cluster.HttpServers[name],
+			})
+			httpEndpoints = append(httpEndpoints, cluster.HttpServers[name])
+		}
+	} else if endpoints != "" {
+		for _, endpoint := range strings.Split(endpoints, ",") {
+			httpEndpoints = append(httpEndpoints, strings.TrimSpace(endpoint))
+		}
+	} else {
+		return nil, fmt.Errorf("either cluster_config_path or endpoints must be specified")
+	}
+
+	var tlsConfig *tls.Config
+	if ca != "" {
+		var err error
+		if tlsConfig, err = security.LoadClientConfig(ca, cert, key); err != nil {
+			return nil, err
+		}
+	}
+
+	username, password := user, ""
+	if i := strings.IndexByte(user, ':'); i >= 0 {
+		username, password = user[:i], user[i+1:]
+	}
+	c, err := client.NewClient(client.ClientConfig{
+		Endpoints:      httpEndpoints,
+		RequestTimeout: timeout,
+		TLS:            tlsConfig,
+		Username:       username,
+		Password:       password,
+		Token:          token,
+
+		ReadConsistency: consistency,
+		ReadYourWrites:  minIndex > 0,
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.ObserveRevision(minIndex)
+	if members == nil {
+		if members, err = c.Members(ctx); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return &ctl{client: c, members: members}, nil
+}
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if output != "table" && output != "json" {
+		fmt.Fprintf(os.Stderr, "Error: unknown output format %q\n", output)
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	c, err := newCtl(ctx)
+	if err == nil {
+		err = run(ctx, c, args[0], args[1:])
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, c *ctl, cmd string, args []string) error {
+	switch cmd {
+	case "get":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: get <key>")
+		}
+		return c.get(ctx, args[0])
+	case "put":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: put <key> <value>")
+		}
+		return c.put(ctx, args[0], args[1])
+	case "delete":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: delete <key>")
+		}
+		return c.delete(ctx, args[0])
+	case "scan":
+		return c.scan(ctx, args)
+	case "status":
+		return c.printStatus(ctx)
+	case "members":
+		return c.printMembers()
+	case "transfer-leader":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: transfer-leader <server>")
+		}
+		return c.transferLeader(ctx, args[0])
+	case "auth":
+		return c.auth(ctx, args)
+	case "user":
+		return c.user(ctx, args)
+	case "role":
+		return c.role(ctx, args)
+	default:
+		return fmt.Errorf("unknown command %q, run \"konsenctl -h\" for usage", cmd)
+	}
+}
+
+func (c *ctl) get(ctx context.Context, key string) error {
+	value, err := c.client.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if output == "json" {
+		return writeJSON(os.Stdout, client.KeyValue{Key: key, Value: string(value)})
+	}
+	fmt.Println(string(value))
+	return nil
+}
+
+func (c *ctl) put(ctx context.Context, key string, value string) error {
+	if err := c.client.Put(ctx, key, []byte(value)); err != nil {
+		return err
+	}
+	return c.printRevision()
+}
+
+func (c *ctl) delete(ctx context.Context, key string) error {
+	if err := c.client.Delete(ctx, key); err != nil {
+		return err
+	}
+	return c.printRevision()
+}
+
+// printRevision prints the revision of a write with --output json.
+func (c *ctl) printRevision() error {
+	if output != "json" {
+		return nil
+	}
+	return writeJSON(os.Stdout, struct {
+		Revision uint64 `json:"revision"`
+	}{c.client.Revision()})
+}
+
+func (c *ctl) scan(ctx context.Context, args []string) error {
+	var prefix string
+	var limit int
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	fs.StringVar(&prefix, "prefix", "", "Key prefix, an empty prefix matches all keys.")
+	fs.IntVar(&limit, "limit", 0, "Maximum number of key-value pairs to print, 0 means no limit.")
+	fs.Parse(args)
+
+	kvs, err := c.client.Scan(ctx, prefix, limit)
+	if err != nil {
+		return err
+	}
+
+	if output == "json" {
+		return writeJSON(os.Stdout, kvs)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE")
+	for _, kv := range kvs {
+		fmt.Fprintf(w, "%s\t%s\n", kv.Key, kv.Value)
+	}
+	return w.Flush()
+}
+
+// serverStatus is the status of a server, or the error getting it.
+type serverStatus struct {
+	*client.Status
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+func (c *ctl) printStatus(ctx context.Context) error {
+	statuses := make([]serverStatus, len(c.members))
+	for i, member := range c.members {
+		status, err := c.client.Status(ctx, member.HttpEndpoint)
+		statuses[i] = serverStatus{Status: status, Name: member.Name}
+		if err != nil {
+			statuses[i].Error = err.Error()
+		}
+	}
+
+	if output == "json" {
+		return writeJSON(os.Stdout, statuses)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tROLE\tTERM\tLEADER\tCOMMIT\tAPPLIED\tERROR")
+	for _, s := range statuses {
+		if s.Status == nil {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\t-\t%s\n", s.Name, s.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\t%d\t%s\n", s.Name, s.Role, s.Term, s.Leader, s.CommitIndex, s.LastApplied, s.Corruption)
+	}
+	return w.Flush()
+}
+
+func (c *ctl) printMembers() error {
+	if output == "json" {
+		return writeJSON(os.Stdout, c.members)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tENDPOINT\tHTTP ENDPOINT")
+	for _, m := range c.members {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", m.Name, m.Endpoint, m.HttpEndpoint)
+	}
+	return w.Flush()
+}
+
+func (c *ctl) transferLeader(ctx context.Context, target string) error {
+	return c.client.TransferLeadership(ctx, target)
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
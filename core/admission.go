@@ -0,0 +1,64 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/lizhaoliu/konsen/v2/metrics"
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+)
+
+// proposalLimiter bounds the proposals on a server that are admitted but not yet answered, i.e. waiting for the message
+// loop, being forwarded to the leader, or waiting to be committed and applied. Proposals beyond the limits are rejected
+// right away instead of piling up, so that an overloaded server sheds load and keeps serving Raft traffic in time.
+type proposalLimiter struct {
+	maxProposals int   // Maximum number of pending proposals, 0 means unlimited.
+	maxBytes     int64 // Maximum total data size of pending proposals, 0 means unlimited.
+	metrics      *metrics.Metrics
+
+	mu        sync.Mutex
+	proposals int
+	bytes     int64
+}
+
+// acquire admits a proposal of given data size, returns false if it would exceed the limits. A proposal is always
+// admitted when nothing else is pending, so that one larger than maxBytes is not rejected forever.
+func (l *proposalLimiter) acquire(size int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.proposals > 0 {
+		if l.maxProposals > 0 && l.proposals+1 > l.maxProposals {
+			return false
+		}
+		if l.maxBytes > 0 && l.bytes+size > l.maxBytes {
+			return false
+		}
+	}
+	l.proposals++
+	l.bytes += size
+	l.metrics.PendingProposals.Set(float64(l.proposals))
+	l.metrics.PendingProposalBytes.Set(float64(l.bytes))
+	return true
+}
+
+// release releases an admitted proposal after it is answered.
+func (l *proposalLimiter) release(size int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.proposals--
+	l.bytes -= size
+	l.metrics.PendingProposals.Set(float64(l.proposals))
+	l.metrics.PendingProposalBytes.Set(float64(l.bytes))
+}
+
+// overloadedResp returns the response of a proposal rejected by the limiter.
+func (l *proposalLimiter) overloadedResp() *konsen.AppendDataResp {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return &konsen.AppendDataResp{
+		Success: false,
+		Error:   konsen.AppendDataError_OVERLOADED,
+		ErrorMessage: fmt.Sprintf("server is overloaded: %d pending proposals of %d bytes (limits: %d proposals, %d bytes)",
+			l.proposals, l.bytes, l.maxProposals, l.maxBytes),
+	}
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+)
+
+func pendingProposals(sm *StateMachine) int {
+	sm.proposals.mu.Lock()
+	defer sm.proposals.mu.Unlock()
+	return sm.proposals.proposals
+}
+
+// A proposal keeps its slot after the caller gives up, until the proposal is answered.
+func TestProposalReleasedWhenAnswered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "konsen-core-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storage := openTestStorage(t, dir)
+	defer storage.Close()
+	sm := newTestStateMachine(t, storage)
+	defer sm.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := sm.AppendData(ctx, &konsen.AppendDataReq{Data: []byte("data"), Type: konsen.LogType_DATA})
+		errCh <- err
+	}()
+
+	// The message loop is not running, the proposal is taken from its queue instead.
+	var msg appendDataMsg
+	select {
+	case m := <-sm.msgCh:
+		msg = m.(appendDataMsg)
+	case <-time.After(10 * time.Second):
+		t.Fatal("proposal is not enqueued")
+	}
+	cancel()
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	if n := pendingProposals(sm); n != 1 {
+		t.Fatalf("got %d pending proposals after the caller returned, want 1", n)
+	}
+
+	msg.reply(&konsen.AppendDataResp{Success: true, Index: 1})
+	if n := pendingProposals(sm); n != 0 {
+		t.Fatalf("got %d pending proposals after the proposal is answered, want 0", n)
+	}
+}
@@ -0,0 +1,550 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+	"github.com/lizhaoliu/konsen/v2/store"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Authentication and authorization of the key-value API:
+//
+// Users have passwords and roles, and roles grant read and/or write access to key prefixes. Changes of users and roles
+// are proposed as AUTH logs, so every server applies them in the same order and enforces the same rules. The applied
+// user/role database is stored in the key-value store under a reserved key, so that a restarted server enforces the
+// rules before it catches up with the log, and is never rolled back by replaying older AUTH logs.
+//
+// Authentication is disabled until it is enabled by an admin, and it can only be enabled when the root user exists.
+// The root role (which can not be changed) grants access to all keys and to admin operations.
+
+const (
+	RootUser = "root" // Name of the user that authentication can not be enabled without.
+	RootRole = "root" // Name of the role that grants access to everything.
+)
+
+const (
+	DefaultTokenTTL = time.Hour
+	MaxTokenTTL     = 24 * time.Hour
+)
+
+var (
+	// ErrUnauthenticated is returned when authentication is enabled and the request has no valid credentials.
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrPermissionDenied is returned when the user of the request is not allowed to do the operation.
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrInvalidAuth is returned when a change of users or roles is invalid.
+	ErrInvalidAuth = errors.New("invalid auth request")
+)
+
+var (
+	reservedKeyPrefix = []byte("\x00konsen/")     // Keys of internal state, not accessible by clients.
+	authStateKey      = []byte("\x00konsen/auth") // Key of the applied konsen.AuthState.
+)
+
+// Credentials identify the client of a request.
+type Credentials struct {
+	Username string
+	Password string
+	Token    string // Token issued by IssueToken, used instead of username and password if set.
+}
+
+type credentialsKey struct{}
+
+// WithCredentials returns a copy of ctx carrying the client credentials, which requests made with the context are
+// authenticated with.
+func WithCredentials(ctx context.Context, creds *Credentials) context.Context {
+	return context.WithValue(ctx, credentialsKey{}, creds)
+}
+
+// CredentialsFrom returns the client credentials carried by ctx, or nil if there are none.
+func CredentialsFrom(ctx context.Context) *Credentials {
+	creds, _ := ctx.Value(credentialsKey{}).(*Credentials)
+	return creds
+}
+
+// authStore is the applied user/role database, it is updated by the message loop and read by request goroutines.
+type authStore struct {
+	mu       sync.RWMutex
+	state    *konsen.AuthState
+	verified map[string][sha256.Size]byte // Digests of passwords verified against bcrypt hashes, by user name.
+}
+
+// loadAuthState reads the user/role database from the key-value store.
+func loadAuthState(kv store.KVStore) (*konsen.AuthState, error) {
+	state := &konsen.AuthState{}
+	buf, err := kv.GetValue(authStateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get auth state: %w", err)
+	}
+	if err := proto.Unmarshal(buf, state); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal auth state: %w", err)
+	}
+	return state, nil
+}
+
+func (a *authStore) getState() *konsen.AuthState {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.state
+}
+
+// apply applies an AUTH log at index, it only records the applied index if the state already includes the log.
+func (a *authStore) apply(kv store.KVStore, index uint64, op *konsen.AuthOp) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if index <= a.state.GetIndex() {
+		return kv.ApplyKVs(index, nil)
+	}
+
+	state := proto.Clone(a.state).(*konsen.AuthState)
+	state.Index = index
+	if state.Users == nil {
+		state.Users = make(map[string]*konsen.AuthUser)
+	}
+	if state.Roles == nil {
+		state.Roles = make(map[string]*konsen.AuthRole)
+	}
+	switch v := op.GetOp().(type) {
+	case *konsen.AuthOp_Enable:
+		state.Enabled, state.TokenSecret = true, v.Enable
+	case *konsen.AuthOp_Disable:
+		state.Enabled, state.TokenSecret = false, nil
+	case *konsen.AuthOp_PutUser:
+		state.Users[v.PutUser.GetName()] = v.PutUser
+	case *konsen.AuthOp_DeleteUser:
+		delete(state.Users, v.DeleteUser)
+	case *konsen.AuthOp_PutRole:
+		state.Roles[v.PutRole.GetName()] = v.PutRole
+	case *konsen.AuthOp_DeleteRole:
+		delete(state.Roles, v.DeleteRole)
+	default:
+		return fmt.Errorf("unrecognized auth op: %v", op)
+	}
+
+	buf, err := proto.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal auth state: %w", err)
+	}
+	if err := kv.ApplyKVs(index, []*konsen.KV{{Key: authStateKey, Value: buf}}); err != nil {
+		return fmt.Errorf("failed to store auth state: %w", err)
+	}
+	a.state = state
+	a.verified = nil
+	return nil
+}
+
+// authenticate returns the user of the credentials in ctx, or nil if authentication is disabled.
+func (a *authStore) authenticate(ctx context.Context) (*konsen.AuthUser, error) {
+	state := a.getState()
+	if !state.GetEnabled() {
+		return nil, nil
+	}
+	creds := CredentialsFrom(ctx)
+	if creds == nil {
+		return nil, &requestError{kind: ErrUnauthenticated, msg: "authentication is required"}
+	}
+	if creds.Token != "" {
+		return a.verifyToken(state, creds.Token)
+	}
+	user, ok := state.GetUsers()[creds.Username]
+	if !ok {
+		return nil, &requestError{kind: ErrUnauthenticated, msg: "invalid user name or password"}
+	}
+	if !a.verifyPassword(state, user, creds.Password) {
+		return nil, &requestError{kind: ErrUnauthenticated, msg: "invalid user name or password"}
+	}
+	return user, nil
+}
+
+// verifyPassword checks password against the user's bcrypt hash, which is slow, so verified passwords are remembered
+// until the user/role database changes.
+func (a *authStore) verifyPassword(state *konsen.AuthState, user *konsen.AuthUser, password string) bool {
+	digest := sha256.Sum256(append(append([]byte(nil), user.GetPasswordHash()...), password...))
+	a.mu.RLock()
+	verified, ok := a.verified[user.GetName()]
+	a.mu.RUnlock()
+	if ok && hmac.Equal(verified[:], digest[:]) {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword(user.GetPasswordHash(), []byte(password)) != nil {
+		return false
+	}
+	a.mu.Lock()
+	if a.state == state {
+		if a.verified == nil {
+			a.verified = make(map[string][sha256.Size]byte)
+		}
+		a.verified[user.GetName()] = digest
+	}
+	a.mu.Unlock()
+	return true
+}
+
+// Tokens are "<base64 of user name and expiry>.<base64 of its HMAC-SHA256 signature>", signed with the token secret
+// of the user/role database, so any server can verify them, and disabling authentication revokes them all.
+
+func signToken(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (a *authStore) verifyToken(state *konsen.AuthState, token string) (*konsen.AuthUser, error) {
+	invalid := &requestError{kind: ErrUnauthenticated, msg: "invalid token"}
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return nil, invalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(token[:i])
+	if err != nil {
+		return nil, invalid
+	}
+	if !hmac.Equal([]byte(signToken(state.GetTokenSecret(), string(payload))), []byte(token)) {
+		return nil, invalid
+	}
+	fields := strings.Split(string(payload), "\n")
+	if len(fields) != 2 {
+		return nil, invalid
+	}
+	expiry, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, invalid
+	}
+	if time.Now().Unix() >= expiry {
+		return nil, &requestError{kind: ErrUnauthenticated, msg: "token has expired"}
+	}
+	user, ok := state.GetUsers()[fields[0]]
+	if !ok {
+		return nil, invalid
+	}
+	return user, nil
+}
+
+// permissions returns the permissions granted to the user, and true if the user has the root role.
+func permissions(state *konsen.AuthState, user *konsen.AuthUser) ([]*konsen.Permission, bool) {
+	var perms []*konsen.Permission
+	for _, name := range user.GetRoles() {
+		if name == RootRole {
+			return nil, true
+		}
+		perms = append(perms, state.GetRoles()[name].GetPermissions()...)
+	}
+	return perms, false
+}
+
+// grants returns true if the permission of type have grants access of type want.
+func grants(have konsen.Permission_Type, want konsen.Permission_Type) bool {
+	return have == want || have == konsen.Permission_READWRITE
+}
+
+// authorizeRange checks that the client of ctx has access of type typ to all keys in [startKey, endKey), a nil endKey
+// means the single key startKey, and an empty one means all keys from startKey on.
+func (sm *StateMachine) authorizeRange(ctx context.Context, typ konsen.Permission_Type, startKey []byte, endKey []byte) error {
+	if endKey == nil {
+		if err := checkNotReserved(startKey); err != nil {
+			return err
+		}
+	}
+	user, err := sm.auth.authenticate(ctx)
+	if err != nil || user == nil {
+		return err
+	}
+	perms, root := permissions(sm.auth.getState(), user)
+	if root {
+		return nil
+	}
+	for _, p := range perms {
+		if !grants(p.GetType(), typ) || !bytes.HasPrefix(startKey, p.GetPrefix()) {
+			continue
+		}
+		if endKey == nil {
+			return nil
+		}
+		// The range must not go beyond the keys with the prefix.
+		if end := store.PrefixEnd(p.GetPrefix()); end == nil || (len(endKey) > 0 && bytes.Compare(endKey, end) <= 0) {
+			return nil
+		}
+	}
+	if endKey == nil {
+		return &requestError{kind: ErrPermissionDenied, msg: fmt.Sprintf("user %q has no %v permission on key %q", user.GetName(), typ, startKey)}
+	}
+	return &requestError{kind: ErrPermissionDenied, msg: fmt.Sprintf("user %q has no %v permission on keys [%q, %q)", user.GetName(), typ, startKey, endKey)}
+}
+
+// authorizeKey checks that the client of ctx has access of type typ to key.
+func (sm *StateMachine) authorizeKey(ctx context.Context, typ konsen.Permission_Type, key []byte) error {
+	return sm.authorizeRange(ctx, typ, key, nil)
+}
+
+// authorizeKVs checks that the client of ctx can write (or delete) all the key-value pairs.
+func (sm *StateMachine) authorizeKVs(ctx context.Context, kvs []*konsen.KV) error {
+	for _, kv := range kvs {
+		if err := sm.authorizeKey(ctx, konsen.Permission_WRITE, kv.GetKey()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authorizeTxn checks that the client of ctx can read the compared keys, and write all keys the transaction may write.
+func (sm *StateMachine) authorizeTxn(ctx context.Context, txn *konsen.TxnReq) error {
+	for _, c := range txn.GetCompares() {
+		if err := sm.authorizeKey(ctx, konsen.Permission_READ, c.GetKey()); err != nil {
+			return err
+		}
+	}
+	if err := sm.authorizeKVs(ctx, txn.GetSuccess()); err != nil {
+		return err
+	}
+	return sm.authorizeKVs(ctx, txn.GetFailure())
+}
+
+// checkNotReserved returns an error if key is a key of internal state.
+func checkNotReserved(key []byte) error {
+	if bytes.HasPrefix(key, reservedKeyPrefix) {
+		return &requestError{kind: ErrPermissionDenied, msg: fmt.Sprintf("key %q is reserved", key)}
+	}
+	return nil
+}
+
+// AuthorizeProposal checks a proposal received through the Raft service, from a follower forwarding a client write to
+// this server. Followers only forward valid writes of client keys they have authorized (AUTH entries are sent to the
+// leader instead), so AUTH entries, invalid writes (see validateKVs) and writes of reserved keys are rejected. Unless
+// the caller is authenticated as a peer, the keys are also authorized with the client credentials in ctx (forwarded by
+// the follower), like writes through the KV API.
+func (sm *StateMachine) AuthorizeProposal(ctx context.Context, req *konsen.AppendDataReq, fromPeer bool) error {
+	var keys [][]byte
+	authorize := func() error { return nil }
+	switch req.GetType() {
+	case konsen.LogType_DATA:
+		kvs := &konsen.KVList{}
+		if err := proto.Unmarshal(req.GetData(), kvs); err != nil {
+			return fmt.Errorf("failed to unmarshal proposal: %w", err)
+		}
+		if err := validateKVs(kvs.GetKvList()); err != nil {
+			return err
+		}
+		for _, kv := range kvs.GetKvList() {
+			keys = append(keys, kv.GetKey())
+		}
+		authorize = func() error { return sm.authorizeKVs(ctx, kvs.GetKvList()) }
+	case konsen.LogType_TXN:
+		txn := &konsen.TxnReq{}
+		if err := proto.Unmarshal(req.GetData(), txn); err != nil {
+			return fmt.Errorf("failed to unmarshal proposal: %w", err)
+		}
+		if err := validateTxn(txn); err != nil {
+			return err
+		}
+		for _, c := range txn.GetCompares() {
+			keys = append(keys, c.GetKey())
+		}
+		for _, kv := range append(append([]*konsen.KV(nil), txn.GetSuccess()...), txn.GetFailure()...) {
+			keys = append(keys, kv.GetKey())
+		}
+		authorize = func() error { return sm.authorizeTxn(ctx, txn) }
+	default:
+		return &requestError{kind: ErrPermissionDenied, msg: fmt.Sprintf("%v entries can not be proposed by other servers", req.GetType())}
+	}
+	for _, key := range keys {
+		if err := checkNotReserved(key); err != nil {
+			return err
+		}
+	}
+	if fromPeer {
+		return nil
+	}
+	return authorize()
+}
+
+// authorizeAdmin checks that the client of ctx has the root role, if authentication is enabled.
+func (sm *StateMachine) authorizeAdmin(ctx context.Context) error {
+	user, err := sm.auth.authenticate(ctx)
+	if err != nil || user == nil {
+		return err
+	}
+	if _, root := permissions(sm.auth.getState(), user); !root {
+		return &requestError{kind: ErrPermissionDenied, msg: fmt.Sprintf("user %q does not have the %s role", user.GetName(), RootRole)}
+	}
+	return nil
+}
+
+// applyAuth applies an AUTH log to the user/role database.
+func (sm *StateMachine) applyAuth(entry *konsen.Log) error {
+	op := &konsen.AuthOp{}
+	if err := proto.Unmarshal(entry.GetData(), op); err != nil {
+		return err
+	}
+	return sm.auth.apply(sm.kv, entry.GetIndex(), op)
+}
+
+// proposeAuth proposes a change of the user/role database by an admin.
+func (sm *StateMachine) proposeAuth(ctx context.Context, op *konsen.AuthOp) error {
+	if err := sm.authorizeAdmin(ctx); err != nil {
+		return err
+	}
+	buf, err := proto.Marshal(op)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	_, err = sm.propose(ctx, &konsen.AppendDataReq{Data: buf, Type: konsen.LogType_AUTH})
+	return err
+}
+
+func invalidAuth(format string, args ...interface{}) error {
+	return &requestError{kind: ErrInvalidAuth, msg: fmt.Sprintf(format, args...)}
+}
+
+// AuthEnabled returns true if clients must authenticate.
+func (sm *StateMachine) AuthEnabled() bool {
+	return sm.auth.getState().GetEnabled()
+}
+
+// EnableAuth enables authentication, the root user must have been added. Servers must authenticate each other, or
+// anyone that can reach the Raft service could write without credentials.
+func (sm *StateMachine) EnableAuth(ctx context.Context) error {
+	if !sm.cluster.PeersAuthenticated() {
+		return invalidAuth("servers must authenticate each other with peerSecretFile or mutual TLS before enabling authentication")
+	}
+	root, ok := sm.auth.getState().GetUsers()[RootUser]
+	if !ok {
+		return invalidAuth("user %q must be added before enabling authentication", RootUser)
+	}
+	if _, isRoot := permissions(sm.auth.getState(), root); !isRoot {
+		return invalidAuth("user %q must have the %s role", RootUser, RootRole)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return fmt.Errorf("failed to generate token secret: %w", err)
+	}
+	return sm.proposeAuth(ctx, &konsen.AuthOp{Op: &konsen.AuthOp_Enable{Enable: secret}})
+}
+
+// DisableAuth disables authentication, and revokes all issued tokens.
+func (sm *StateMachine) DisableAuth(ctx context.Context) error {
+	return sm.proposeAuth(ctx, &konsen.AuthOp{Op: &konsen.AuthOp_Disable{Disable: true}})
+}
+
+// PutUser adds or updates a user, the password of an existing user is unchanged if password is empty.
+func (sm *StateMachine) PutUser(ctx context.Context, name string, password string, roles []string) error {
+	if name == "" || strings.ContainsAny(name, "\n") {
+		return invalidAuth("invalid user name %q", name)
+	}
+	state := sm.auth.getState()
+	user := &konsen.AuthUser{Name: name, Roles: roles}
+	if password == "" {
+		existing, ok := state.GetUsers()[name]
+		if !ok {
+			return invalidAuth("password of new user %q is empty", name)
+		}
+		user.PasswordHash = existing.GetPasswordHash()
+	} else {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return fmt.Errorf("failed to hash password: %w", err)
+		}
+		user.PasswordHash = hash
+	}
+	isRoot := false
+	for _, role := range roles {
+		if _, ok := state.GetRoles()[role]; !ok && role != RootRole {
+			return invalidAuth("role %q does not exist", role)
+		}
+		isRoot = isRoot || role == RootRole
+	}
+	if name == RootUser && !isRoot && state.GetEnabled() {
+		return invalidAuth("user %q must have the %s role while authentication is enabled", RootUser, RootRole)
+	}
+	return sm.proposeAuth(ctx, &konsen.AuthOp{Op: &konsen.AuthOp_PutUser{PutUser: user}})
+}
+
+// DeleteUser deletes a user.
+func (sm *StateMachine) DeleteUser(ctx context.Context, name string) error {
+	if name == RootUser && sm.AuthEnabled() {
+		return invalidAuth("user %q can not be deleted while authentication is enabled", RootUser)
+	}
+	return sm.proposeAuth(ctx, &konsen.AuthOp{Op: &konsen.AuthOp_DeleteUser{DeleteUser: name}})
+}
+
+// PutRole adds or updates a role.
+func (sm *StateMachine) PutRole(ctx context.Context, role *konsen.AuthRole) error {
+	if role.GetName() == "" {
+		return invalidAuth("role name is empty")
+	}
+	if role.GetName() == RootRole {
+		return invalidAuth("role %q can not be changed", RootRole)
+	}
+	return sm.proposeAuth(ctx, &konsen.AuthOp{Op: &konsen.AuthOp_PutRole{PutRole: role}})
+}
+
+// DeleteRole deletes a role, users that have it lose its permissions.
+func (sm *StateMachine) DeleteRole(ctx context.Context, name string) error {
+	if name == RootRole {
+		return invalidAuth("role %q can not be deleted", RootRole)
+	}
+	return sm.proposeAuth(ctx, &konsen.AuthOp{Op: &konsen.AuthOp_DeleteRole{DeleteRole: name}})
+}
+
+// Users lists the users without their password hashes, sorted by name.
+func (sm *StateMachine) Users(ctx context.Context) ([]*konsen.AuthUser, error) {
+	if err := sm.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	var users []*konsen.AuthUser
+	for _, user := range sm.auth.getState().GetUsers() {
+		users = append(users, &konsen.AuthUser{Name: user.GetName(), Roles: user.GetRoles()})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].GetName() < users[j].GetName() })
+	return users, nil
+}
+
+// Roles lists the roles, sorted by name.
+func (sm *StateMachine) Roles(ctx context.Context) ([]*konsen.AuthRole, error) {
+	if err := sm.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	var roles []*konsen.AuthRole
+	for _, role := range sm.auth.getState().GetRoles() {
+		roles = append(roles, role)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].GetName() < roles[j].GetName() })
+	return roles, nil
+}
+
+// IssueToken returns a token of the user of the credentials in ctx, which expires after ttl (DefaultTokenTTL if 0).
+func (sm *StateMachine) IssueToken(ctx context.Context, ttl time.Duration) (string, time.Time, error) {
+	if ttl == 0 {
+		ttl = DefaultTokenTTL
+	}
+	if ttl < 0 || ttl > MaxTokenTTL {
+		return "", time.Time{}, invalidAuth("token TTL must be within (0, %v]", MaxTokenTTL)
+	}
+	state := sm.auth.getState()
+	if !state.GetEnabled() {
+		return "", time.Time{}, invalidAuth("authentication is disabled")
+	}
+	if creds := CredentialsFrom(ctx); creds != nil && creds.Token != "" {
+		return "", time.Time{}, invalidAuth("tokens are issued for user name and password only")
+	}
+	user, err := sm.auth.authenticate(ctx)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiry := time.Now().Add(ttl).Truncate(time.Second)
+	return signToken(state.GetTokenSecret(), user.GetName()+"\n"+strconv.FormatInt(expiry.Unix(), 10)), expiry, nil
+}
@@ -0,0 +1,130 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func marshalTestProposal(t *testing.T, logType konsen.LogType, msg proto.Message) *konsen.AppendDataReq {
+	t.Helper()
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &konsen.AppendDataReq{Data: data, Type: logType}
+}
+
+// Proposals received through the Raft service may come from anyone that can reach it, so only writes of client keys
+// are accepted, and they are authorized with the forwarded credentials unless the caller is a peer.
+func TestAuthorizeProposal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "konsen-core-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storage := openTestStorage(t, dir)
+	defer storage.Close()
+	sm := newTestStateMachine(t, storage)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, op := range []*konsen.AuthOp{
+		{Op: &konsen.AuthOp_PutRole{PutRole: &konsen.AuthRole{Name: "app", Permissions: []*konsen.Permission{
+			{Prefix: []byte("app/"), Type: konsen.Permission_READWRITE}}}}},
+		{Op: &konsen.AuthOp_PutUser{PutUser: &konsen.AuthUser{Name: RootUser, PasswordHash: hash, Roles: []string{RootRole}}}},
+		{Op: &konsen.AuthOp_PutUser{PutUser: &konsen.AuthUser{Name: "alice", PasswordHash: hash, Roles: []string{"app"}}}},
+		{Op: &konsen.AuthOp_Enable{Enable: []byte("secret")}},
+	} {
+		if err := sm.auth.apply(storage, uint64(i+1), op); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alice := WithCredentials(context.Background(), &Credentials{Username: "alice", Password: "pw"})
+	root := WithCredentials(context.Background(), &Credentials{Username: RootUser, Password: "pw"})
+	put := func(key string) *konsen.AppendDataReq {
+		return marshalTestProposal(t, konsen.LogType_DATA, &konsen.KVList{KvList: []*konsen.KV{{Key: []byte(key), Value: []byte("v")}}})
+	}
+	txn := func(key string) *konsen.AppendDataReq {
+		return marshalTestProposal(t, konsen.LogType_TXN, &konsen.TxnReq{Failure: []*konsen.KV{{Key: []byte(key), Value: []byte("v")}}})
+	}
+	auth := marshalTestProposal(t, konsen.LogType_AUTH, &konsen.AuthOp{Op: &konsen.AuthOp_Disable{Disable: true}})
+
+	for _, test := range []struct {
+		name     string
+		ctx      context.Context
+		req      *konsen.AppendDataReq
+		fromPeer bool
+		want     error
+	}{
+		{"write by peer", context.Background(), put("other/1"), true, nil},
+		{"write with credentials", alice, put("app/1"), false, nil},
+		{"transaction with credentials", alice, txn("app/1"), false, nil},
+		{"write without credentials", context.Background(), put("app/1"), false, ErrUnauthenticated},
+		{"write without permission", alice, put("other/1"), false, ErrPermissionDenied},
+		{"transaction without permission", alice, txn("other/1"), false, ErrPermissionDenied},
+		{"reserved key by peer", context.Background(), put(string(authStateKey)), true, ErrPermissionDenied},
+		{"reserved key by root", root, txn(string(authStateKey)), false, ErrPermissionDenied},
+		{"auth by peer", context.Background(), auth, true, ErrPermissionDenied},
+		{"auth by root", root, auth, false, ErrPermissionDenied},
+		{"empty key by peer", context.Background(), put(""), true, ErrInvalidArgument},
+		{"large key by peer", context.Background(), txn(string(make([]byte, MaxKeySize+1))), true, ErrInvalidArgument},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := sm.AuthorizeProposal(test.ctx, test.req, test.fromPeer)
+			if test.want == nil && err != nil || test.want != nil && !errors.Is(err, test.want) {
+				t.Fatalf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+// Credentials of clients protect nothing if anyone can propose writes through the Raft service, so authentication is
+// only enabled, and a server with authentication enabled only starts, if servers authenticate each other.
+func TestAuthRequiresPeerAuthentication(t *testing.T) {
+	storage := openTestStorage(t, tempDir(t))
+	defer storage.Close()
+	sm := newTestStateMachine(t, storage)
+	hash, err := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.auth.apply(storage, 1, &konsen.AuthOp{Op: &konsen.AuthOp_PutUser{
+		PutUser: &konsen.AuthUser{Name: RootUser, PasswordHash: hash, Roles: []string{RootRole}}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.EnableAuth(context.Background()); !errors.Is(err, ErrInvalidAuth) {
+		t.Fatalf("got error %v enabling authentication without peer authentication, want %v", err, ErrInvalidAuth)
+	}
+
+	if err := sm.auth.apply(storage, 2, &konsen.AuthOp{Op: &konsen.AuthOp_Enable{Enable: []byte("secret")}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name    string
+		cluster ClusterConfig
+		wantErr bool
+	}{
+		{"no peer authentication", ClusterConfig{}, true},
+		{"mutual TLS", ClusterConfig{TLS: &TLSConfig{ClientAuth: true}}, false},
+		{"peer secret", ClusterConfig{PeerSecretFile: "secret"}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cluster := test.cluster
+			cluster.Servers, cluster.LocalServerName = map[string]string{"node1": "127.0.0.1:0"}, "node1"
+			_, err := NewStateMachine(StateMachineConfig{Storage: storage, Cluster: &cluster})
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("got error %v starting with authentication enabled, want error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
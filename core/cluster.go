@@ -1,6 +1,7 @@
 package core
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 
@@ -12,10 +13,47 @@ type ClusterConfig struct {
 	Servers         map[string]string `yaml:"servers"`                   // All servers in the cluster, a map of "serverName": "serverAddress".
 	HttpServers     map[string]string `yaml:"httpServers"`               // All HTTP servers in the cluster, a map of "serverName": "httpServerAddress".
 	LocalServerName string            `yaml:"localServerName,omitempty"` // Local server name.
+	TLS             *TLSConfig        `yaml:"tls,omitempty"`             // TLS of peer gRPC and client APIs, plaintext if unset.
+	// File of a secret shared by all servers, which they authenticate to each other's Raft service with when mutual
+	// TLS is not used. Without either, anyone that can reach the gRPC port can call the Raft service.
+	PeerSecretFile string `yaml:"peerSecretFile,omitempty"`
 }
 
-// ParseClusterConfig parses given config YAML file.
-func ParseClusterConfig(cfgFilePath string) (*ClusterConfig, error) {
+// TLSConfig is the TLS configuration of a server, used for both peer gRPC and client APIs (HTTP and gRPC). Server
+// certificates must be valid for the server name (e.g. a DNS name "node1"), and for the hosts clients connect to.
+type TLSConfig struct {
+	CAFile   string `yaml:"caFile"`   // PEM encoded CA certificates to verify peers and clients with.
+	CertFile string `yaml:"certFile"` // PEM encoded certificate of the local server.
+	KeyFile  string `yaml:"keyFile"`  // PEM encoded private key of the certificate.
+	// Require clients to present a certificate signed by the CA (mutual TLS). Peers must present a certificate that
+	// is valid for the server name they claim to be.
+	ClientAuth bool `yaml:"clientAuth"`
+}
+
+// PeersAuthenticated returns true if servers authenticate each other on the Raft service, with a peer secret or mutual
+// TLS.
+func (c *ClusterConfig) PeersAuthenticated() bool {
+	return c.PeerSecretFile != "" || c.TLS != nil && c.TLS.ClientAuth
+}
+
+// ReadPeerSecret reads the peer secret file, it returns nil if the file is unset.
+func (c *ClusterConfig) ReadPeerSecret() ([]byte, error) {
+	if c.PeerSecretFile == "" {
+		return nil, nil
+	}
+	buf, err := ioutil.ReadFile(c.PeerSecretFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read peer secret file: %v", err)
+	}
+	secret := bytes.TrimSpace(buf)
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("peer secret file %q is empty", c.PeerSecretFile)
+	}
+	return secret, nil
+}
+
+// LoadClusterConfig reads given config YAML file without validating it, e.g. for clients that have no local server.
+func LoadClusterConfig(cfgFilePath string) (*ClusterConfig, error) {
 	buf, err := ioutil.ReadFile(cfgFilePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read cluster config file: %v", err)
@@ -25,11 +63,24 @@ func ParseClusterConfig(cfgFilePath string) (*ClusterConfig, error) {
 	if err := yaml.Unmarshal(buf, cluster); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal cluster config file: %v", err)
 	}
+	return cluster, nil
+}
+
+// ParseClusterConfig parses and validates given config YAML file.
+func ParseClusterConfig(cfgFilePath string) (*ClusterConfig, error) {
+	cluster, err := LoadClusterConfig(cfgFilePath)
+	if err != nil {
+		return nil, err
+	}
 
 	if cluster.LocalServerName == "" {
 		return nil, fmt.Errorf("local server is unspecified")
 	}
 
+	if tls := cluster.TLS; tls != nil && (tls.CAFile == "" || tls.CertFile == "" || tls.KeyFile == "") {
+		return nil, fmt.Errorf("caFile, certFile and keyFile must all be specified for TLS")
+	}
+
 	numNodes := len(cluster.Servers)
 	if numNodes%2 != 1 {
 		return nil, fmt.Errorf("number of nodes in a cluster must be odd, got: %d", numNodes)
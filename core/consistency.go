@@ -0,0 +1,225 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+	"github.com/lizhaoliu/konsen/v2/store"
+)
+
+// Number of recent local state hashes kept on each server for the leader to compare against.
+const numRetainedStateHashes = 16
+
+// ConsistencyReport summarizes cross-replica state consistency checks: periodically the leader proposes a consistency
+// check log entry, every server hashes its key-value state when applying it, and followers report their hashes back in
+// AppendEntries responses so that the leader can compare them with its own.
+type ConsistencyReport struct {
+	Index      uint64                         `json:"index"`      // Index of the latest consistency check log hashed locally.
+	Hash       string                         `json:"hash"`       // Hex encoded local state hash at Index.
+	Replicas   map[string]*ReplicaConsistency `json:"replicas"`   // Latest check result of each follower, only available on leader.
+	Mismatches uint64                         `json:"mismatches"` // Number of mismatches detected by this server while it was leader.
+}
+
+// ReplicaConsistency is the result of comparing a follower's state hash with the leader's.
+type ReplicaConsistency struct {
+	Index      uint64    `json:"index"`      // Index of the consistency check log the follower hashed its state at.
+	Hash       string    `json:"hash"`       // Hex encoded follower state hash.
+	Consistent bool      `json:"consistent"` // True if the follower's state hash equals the leader's at Index.
+	CheckedAt  time.Time `json:"checkedAt"`  // Time of the comparison.
+}
+
+// getConsistencyReportMsg represents a message to retrieve the consistency report.
+type getConsistencyReportMsg struct {
+	ch chan<- *ConsistencyReport
+}
+
+// consistencyCheckMsg represents a message to propose a consistency check log.
+type consistencyCheckMsg struct{}
+
+// stateHashMsg represents a message that carries the local state hash at a consistency check log.
+type stateHashMsg struct {
+	index uint64
+	hash  []byte
+	err   error
+}
+
+// errHashAborted is returned by hashKVState when the state machine is shut down while hashing.
+var errHashAborted = errors.New("hashing is aborted")
+
+// hashKVState computes a hash of all key-value pairs in the snapshot, it gives up once stopCh is closed.
+func hashKVState(kv store.KVSnapshot, stopCh <-chan struct{}) ([]byte, error) {
+	h := sha256.New()
+	var lenBuf [binary.MaxVarintLen64]byte
+	aborted := false
+	if err := kv.ScanValues(nil, nil, func(key []byte, value []byte) bool {
+		select {
+		case <-stopCh:
+			aborted = true
+			return false
+		default:
+		}
+		n := binary.PutUvarint(lenBuf[:], uint64(len(key)))
+		h.Write(lenBuf[:n])
+		h.Write(key)
+		n = binary.PutUvarint(lenBuf[:], uint64(len(value)))
+		h.Write(lenBuf[:n])
+		h.Write(value)
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	if aborted {
+		return nil, errHashAborted
+	}
+	return h.Sum(nil), nil
+}
+
+// recordStateHash starts hashing the local key-value state at the consistency check log with given index. The state is
+// hashed on a snapshot in another goroutine, so that the message loop goes on meanwhile, and the hash is recorded by
+// handleStateHash. The check is skipped if the previous one is still being hashed.
+func (sm *StateMachine) recordStateHash(logIndex uint64) error {
+	if sm.hashingState {
+		sm.log().Warnf("Skipped consistency check at index %d, the state at a previous one is still being hashed.", logIndex)
+		return nil
+	}
+	snapshot, err := sm.kv.SnapshotValues()
+	if err != nil {
+		return fmt.Errorf("failed to snapshot key-value state: %w", err)
+	}
+	sm.hashingState = true
+	sm.wg.Add(1)
+	go func() {
+		defer sm.wg.Done()
+
+		start := time.Now()
+		hash, err := hashKVState(snapshot, sm.stopCh)
+		// Closed before waiting for the message loop, which may be writing to the store and waiting for the snapshot.
+		snapshot.Close()
+		if err == errHashAborted {
+			return
+		}
+		sm.logger.Debugf("State hash at index %d: %x, took %v.", logIndex, hash, time.Since(start))
+		sm.enqueue(context.Background(), stateHashMsg{index: logIndex, hash: hash, err: err})
+	}()
+	return nil
+}
+
+// handleStateHash records the local state hash at a consistency check log.
+func (sm *StateMachine) handleStateHash(msg stateHashMsg) {
+	sm.hashingState = false
+	if msg.err != nil {
+		sm.log().Errorf("Failed to hash key-value state at index %d: %v", msg.index, msg.err)
+		return
+	}
+	sm.stateHashes[msg.index] = msg.hash
+	sm.stateHashIndex = msg.index
+	// Drop the oldest hashes.
+	for index := range sm.stateHashes {
+		if index+numRetainedStateHashes <= msg.index {
+			delete(sm.stateHashes, index)
+		}
+	}
+}
+
+// checkReplicaStateHash compares the state hash reported by a follower with local state hash at the same index.
+func (sm *StateMachine) checkReplicaStateHash(server string, resp *konsen.AppendEntriesResp) {
+	index := resp.GetStateHashIndex()
+	if index == 0 {
+		return
+	}
+	if prev, ok := sm.replicaConsistency[server]; ok && prev.Index >= index {
+		return
+	}
+	localHash, ok := sm.stateHashes[index]
+	if !ok {
+		// Local hash at that index is either not computed yet or already dropped.
+		return
+	}
+	result := &ReplicaConsistency{
+		Index:      index,
+		Hash:       hex.EncodeToString(resp.GetStateHash()),
+		Consistent: string(localHash) == string(resp.GetStateHash()),
+		CheckedAt:  time.Now(),
+	}
+	sm.replicaConsistency[server] = result
+	if !result.Consistent {
+		sm.consistencyMismatches++
+		sm.metrics.ConsistencyMismatches.Inc()
+		sm.log().Errorf("State of %q diverged at index %d: local hash %x, replica hash %s.", server, index, localHash, result.Hash)
+	}
+}
+
+// handleConsistencyCheck proposes a consistency check log if this server is the leader.
+func (sm *StateMachine) handleConsistencyCheck() error {
+	if sm.role != konsen.Role_LEADER {
+		return nil
+	}
+	if _, err := sm.writeToLogs(nil, konsen.LogType_CONSISTENCY_CHECK); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetConsistencyReport returns the results of cross-replica consistency checks.
+func (sm *StateMachine) GetConsistencyReport(ctx context.Context) (*ConsistencyReport, error) {
+	ch := make(chan *ConsistencyReport, 1)
+	if err := sm.enqueue(ctx, getConsistencyReportMsg{ch: ch}); err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-sm.corruptedCh:
+		return nil, sm.corruption
+	case report := <-ch:
+		return report, nil
+	}
+}
+
+func (sm *StateMachine) handleGetConsistencyReport() *ConsistencyReport {
+	report := &ConsistencyReport{
+		Index:      sm.stateHashIndex,
+		Hash:       hex.EncodeToString(sm.stateHashes[sm.stateHashIndex]),
+		Replicas:   make(map[string]*ReplicaConsistency),
+		Mismatches: sm.consistencyMismatches,
+	}
+	if sm.role == konsen.Role_LEADER {
+		for server, result := range sm.replicaConsistency {
+			r := *result
+			report.Replicas[server] = &r
+		}
+	}
+	return report
+}
+
+// startConsistencyCheckLoop starts the loop that periodically asks the state machine to propose a consistency check.
+func (sm *StateMachine) startConsistencyCheckLoop(ctx context.Context) {
+	if sm.consistencyCheckInterval <= 0 {
+		return
+	}
+	sm.wg.Add(1)
+	go func() {
+		defer sm.wg.Done()
+
+		ticker := time.NewTicker(sm.consistencyCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sm.stopCh:
+				return
+			case <-ticker.C:
+				if err := sm.enqueue(ctx, consistencyCheckMsg{}); err != nil {
+					return
+				}
+			}
+		}
+	}()
+}
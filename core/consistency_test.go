@@ -0,0 +1,66 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+)
+
+// The state is hashed as of the consistency check log, even though the following logs are applied meanwhile.
+func TestStateHashAtConsistencyCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "konsen-core-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage := openTestStorage(t, dir)
+	defer storage.Close()
+	writeTestLog(t, storage, 1, konsen.LogType_DATA, &konsen.KVList{KvList: []*konsen.KV{{Key: []byte("k"), Value: []byte("a")}}})
+	writeTestLog(t, storage, 2, konsen.LogType_CONSISTENCY_CHECK, &konsen.KVList{})
+	writeTestLog(t, storage, 3, konsen.LogType_DATA, &konsen.KVList{KvList: []*konsen.KV{{Key: []byte("k"), Value: []byte("b")}}})
+
+	sm := newTestStateMachine(t, storage)
+	defer sm.Close()
+	sm.commitIndex = 1
+	if err := sm.maybeApplyLogs(sm.applyLog); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := storage.SnapshotValues()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := hashKVState(snapshot, nil)
+	snapshot.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm.commitIndex = 3
+	if err := sm.maybeApplyLogs(sm.applyLog); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, storage, "k", "b")
+
+	// The message loop is not running, the hash is taken from its queue instead.
+	select {
+	case msg := <-sm.msgCh:
+		v, ok := msg.(stateHashMsg)
+		if !ok {
+			t.Fatalf("got message %T, want stateHashMsg", msg)
+		}
+		if v.err != nil || v.index != 2 || !bytes.Equal(v.hash, want) {
+			t.Fatalf("got state hash %x at index %d (error %v), want %x at index 2", v.hash, v.index, v.err, want)
+		}
+		sm.handleStateHash(v)
+	case <-time.After(10 * time.Second):
+		t.Fatal("state hash is not reported")
+	}
+	if report := sm.handleGetConsistencyReport(); report.Index != 2 {
+		t.Fatalf("got consistency report at index %d, want 2", report.Index)
+	}
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+)
+
+// A server without peers is the majority by itself: it is elected by its own vote, commits its logs once they are
+// written and confirms its leadership once a round of AppendEntries is sent, and stays the leader after election
+// timeouts.
+func TestSingleServerElection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "konsen-core-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storage := openTestStorage(t, dir)
+	defer storage.Close()
+	sm := newTestStateMachine(t, storage)
+	defer sm.Close()
+
+	if err := sm.handleElectionTimeout(); err != nil {
+		t.Fatal(err)
+	}
+	if sm.role != konsen.Role_LEADER || sm.term != 1 {
+		t.Fatalf("got %v in term %d after an election timeout, want %v in term 1", sm.role, sm.term, konsen.Role_LEADER)
+	}
+	// The no-op entry of the term.
+	if sm.commitIndex != 1 || sm.termStartIndex != 1 {
+		t.Fatalf("got commit index %d and term start index %d, want 1 and 1", sm.commitIndex, sm.termStartIndex)
+	}
+	if sm.confirmedRound != sm.round || sm.confirmedAt.IsZero() {
+		t.Fatalf("got round %d confirmed up to %d at %v, want it confirmed", sm.round, sm.confirmedRound, sm.confirmedAt)
+	}
+
+	entry, err := sm.writeToLogs([]byte("data"), konsen.LogType_DATA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sm.commitIndex != entry.GetIndex() {
+		t.Fatalf("got commit index %d after writing log %d, want it committed", sm.commitIndex, entry.GetIndex())
+	}
+
+	// The timer gate opened by the previous timeout is taken by the election loop, which is not running.
+	<-sm.timerGateCh
+	if err := sm.handleElectionTimeout(); err != nil {
+		t.Fatal(err)
+	}
+	if sm.role != konsen.Role_LEADER || sm.term != 1 {
+		t.Fatalf("got %v in term %d after another election timeout, want %v in term 1", sm.role, sm.term, konsen.Role_LEADER)
+	}
+}
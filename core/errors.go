@@ -0,0 +1,59 @@
+package core
+
+import (
+	"errors"
+
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+)
+
+var (
+	// ErrNoQuorum is returned when no leader is elected or the leader is not reachable, the cluster can not make
+	// progress until a quorum of servers is up.
+	ErrNoQuorum = errors.New("no quorum")
+	// ErrNotLeader is returned when the request must be served by the leader but this server is not (or no longer)
+	// able to.
+	ErrNotLeader = errors.New("not leader")
+	// ErrTimeout is returned when the request is not completed in time, it may still take effect later.
+	ErrTimeout = errors.New("timeout")
+	// ErrOverloaded is returned when too many proposals are pending, the request is rejected without taking effect
+	// and can be retried later.
+	ErrOverloaded = errors.New("overloaded")
+	// ErrTooStale is returned when a read with bounded staleness can not be served by this server because its state is
+	// too far behind the leader, the leader may be able to serve it.
+	ErrTooStale = errors.New("too stale")
+	// ErrInvalidArgument is returned when a request is rejected because of its content, such as an empty key, without
+	// taking effect.
+	ErrInvalidArgument = errors.New("invalid argument")
+)
+
+// requestError is an error with a detailed message that matches one of the errors above with errors.Is.
+type requestError struct {
+	kind error
+	msg  string
+}
+
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+func (e *requestError) Unwrap() error {
+	return e.kind
+}
+
+// appendDataError converts an unsuccessful AppendData response to error.
+func appendDataError(resp *konsen.AppendDataResp) error {
+	var kind error
+	switch resp.GetError() {
+	case konsen.AppendDataError_NO_QUORUM:
+		kind = ErrNoQuorum
+	case konsen.AppendDataError_NOT_LEADER:
+		kind = ErrNotLeader
+	case konsen.AppendDataError_TIMEOUT:
+		kind = ErrTimeout
+	case konsen.AppendDataError_OVERLOADED:
+		kind = ErrOverloaded
+	default:
+		return errors.New(resp.GetErrorMessage())
+	}
+	return &requestError{kind: kind, msg: resp.GetErrorMessage()}
+}
@@ -0,0 +1,260 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+)
+
+const (
+	// MaxKeySize is the maximum size of a key in bytes, within the key size limits of all storage engines.
+	MaxKeySize = 32 << 10
+	// MaxValueSize is the maximum size of a value in bytes, so that its log entry fits in a message between servers.
+	MaxValueSize = 1 << 20
+)
+
+// validateKVs returns an error if a key is empty or a key or value is too large. Such a write would fail to be applied
+// on every server once committed, so it must be rejected before it is proposed.
+func validateKVs(kvs []*konsen.KV) error {
+	for _, kv := range kvs {
+		if len(kv.GetKey()) == 0 {
+			return &requestError{kind: ErrInvalidArgument, msg: "key must not be empty"}
+		}
+		if len(kv.GetKey()) > MaxKeySize {
+			return &requestError{kind: ErrInvalidArgument, msg: fmt.Sprintf("key of %d bytes is larger than %d", len(kv.GetKey()), MaxKeySize)}
+		}
+		if len(kv.GetValue()) > MaxValueSize {
+			return &requestError{kind: ErrInvalidArgument, msg: fmt.Sprintf("value of key %q of %d bytes is larger than %d",
+				kv.GetKey(), len(kv.GetValue()), MaxValueSize)}
+		}
+	}
+	return nil
+}
+
+// validateTxn returns an error if a write of the transaction is invalid, see validateKVs.
+func validateTxn(txn *konsen.TxnReq) error {
+	if err := validateKVs(txn.GetSuccess()); err != nil {
+		return err
+	}
+	return validateKVs(txn.GetFailure())
+}
+
+// propose appends data to the logs on the leader, and returns after it is committed and applied locally on the leader.
+func (sm *StateMachine) propose(ctx context.Context, req *konsen.AppendDataReq) (*konsen.AppendDataResp, error) {
+	resp, err := sm.AppendData(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.GetSuccess() {
+		return nil, appendDataError(resp)
+	}
+	return resp, nil
+}
+
+// proposeKVs sets (or deletes) key-value pairs atomically, returns the index of the log entry they are written to.
+func (sm *StateMachine) proposeKVs(ctx context.Context, kvs *konsen.KVList) (uint64, error) {
+	buf, err := proto.Marshal(kvs)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal: %w", err)
+	}
+	resp, err := sm.propose(ctx, &konsen.AppendDataReq{Data: buf, Type: konsen.LogType_DATA})
+	if err != nil {
+		return 0, err
+	}
+	return resp.GetIndex(), nil
+}
+
+func (sm *StateMachine) SetKeyValue(ctx context.Context, kv *konsen.KVList) error {
+	_, err := sm.Write(ctx, kv)
+	return err
+}
+
+// Write sets (or deletes) key-value pairs atomically, and returns the revision they are written at.
+func (sm *StateMachine) Write(ctx context.Context, kvs *konsen.KVList) (uint64, error) {
+	if err := validateKVs(kvs.GetKvList()); err != nil {
+		return 0, err
+	}
+	if err := sm.authorizeKVs(ctx, kvs.GetKvList()); err != nil {
+		return 0, err
+	}
+	return sm.proposeKVs(ctx, kvs)
+}
+
+// Put sets the value of a key.
+func (sm *StateMachine) Put(ctx context.Context, req *konsen.PutReq) (*konsen.PutResp, error) {
+	kvs := []*konsen.KV{{Key: req.GetKey(), Value: req.GetValue()}}
+	if err := validateKVs(kvs); err != nil {
+		return nil, err
+	}
+	if err := sm.authorizeKey(ctx, konsen.Permission_WRITE, req.GetKey()); err != nil {
+		return nil, err
+	}
+	index, err := sm.proposeKVs(ctx, &konsen.KVList{KvList: kvs})
+	if err != nil {
+		return nil, err
+	}
+	return &konsen.PutResp{Revision: index}, nil
+}
+
+// Delete deletes a key.
+func (sm *StateMachine) Delete(ctx context.Context, req *konsen.DeleteReq) (*konsen.DeleteResp, error) {
+	kvs := []*konsen.KV{{Key: req.GetKey(), Delete: true}}
+	if err := validateKVs(kvs); err != nil {
+		return nil, err
+	}
+	if err := sm.authorizeKey(ctx, konsen.Permission_WRITE, req.GetKey()); err != nil {
+		return nil, err
+	}
+	index, err := sm.proposeKVs(ctx, &konsen.KVList{KvList: kvs})
+	if err != nil {
+		return nil, err
+	}
+	return &konsen.DeleteResp{Revision: index}, nil
+}
+
+// Txn applies a transaction, the comparisons are evaluated when the transaction log is applied.
+func (sm *StateMachine) Txn(ctx context.Context, req *konsen.TxnReq) (*konsen.TxnResp, error) {
+	if err := validateTxn(req); err != nil {
+		return nil, err
+	}
+	if err := sm.authorizeTxn(ctx, req); err != nil {
+		return nil, err
+	}
+	buf, err := proto.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal: %w", err)
+	}
+	resp, err := sm.propose(ctx, &konsen.AppendDataReq{Data: buf, Type: konsen.LogType_TXN})
+	if err != nil {
+		return nil, err
+	}
+	return &konsen.TxnResp{Succeeded: resp.GetTxnSucceeded(), Revision: resp.GetIndex()}, nil
+}
+
+// Get gets the value of a key from local key-value store, with the consistency in request options.
+func (sm *StateMachine) Get(ctx context.Context, req *konsen.GetReq) (*konsen.GetResp, error) {
+	if err := sm.authorizeKey(ctx, konsen.Permission_READ, req.GetKey()); err != nil {
+		return nil, err
+	}
+	ch := make(chan *konsen.GetResp, 1)
+	errCh := make(chan error, 1)
+	if err := sm.enqueue(ctx, getMsg{ctx: ctx, req: req, ch: ch, errCh: errCh}); err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-sm.stopCh:
+		return nil, &requestError{kind: ErrNotLeader, msg: "server has been shut down"}
+	case <-sm.corruptedCh:
+		return nil, sm.corruption
+	case err := <-errCh:
+		return nil, err
+	case resp := <-ch:
+		return resp, nil
+	}
+}
+
+// GetValue returns the value of given key from local key-value store with given consistency, nil if the key does not
+// exist.
+func (sm *StateMachine) GetValue(ctx context.Context, key []byte, consistency ReadConsistency) ([]byte, error) {
+	resp, err := sm.Get(ctx, &konsen.GetReq{Key: key, Options: consistency.Options()})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetValue(), nil
+}
+
+// Range gets key-value pairs in a key range from local key-value store, with the consistency in request options.
+func (sm *StateMachine) Range(ctx context.Context, req *konsen.RangeReq) (*konsen.RangeResp, error) {
+	endKey := req.GetEndKey()
+	if endKey == nil {
+		endKey = []byte{} // All keys from the start key on.
+	}
+	if err := sm.authorizeRange(ctx, konsen.Permission_READ, req.GetStartKey(), endKey); err != nil {
+		return nil, err
+	}
+	ch := make(chan *konsen.RangeResp, 1)
+	errCh := make(chan error, 1)
+	if err := sm.enqueue(ctx, rangeMsg{ctx: ctx, req: req, ch: ch, errCh: errCh}); err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-sm.stopCh:
+		return nil, &requestError{kind: ErrNotLeader, msg: "server has been shut down"}
+	case <-sm.corruptedCh:
+		return nil, sm.corruption
+	case err := <-errCh:
+		return nil, err
+	case resp := <-ch:
+		return resp, nil
+	}
+}
+
+func (sm *StateMachine) handleGet(req *konsen.GetReq) (*konsen.GetResp, error) {
+	value, err := sm.kv.GetValue(req.GetKey())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get value: %w", err)
+	}
+	return &konsen.GetResp{Value: value, Revision: sm.lastApplied}, nil
+}
+
+func (sm *StateMachine) handleRange(req *konsen.RangeReq) (*konsen.RangeResp, error) {
+	var endKey []byte
+	if len(req.GetEndKey()) > 0 {
+		endKey = req.GetEndKey()
+	}
+	limit := int(req.GetLimit())
+	resp := &konsen.RangeResp{Revision: sm.lastApplied}
+	if err := sm.kv.ScanValues(req.GetStartKey(), endKey, func(key []byte, value []byte) bool {
+		if bytes.HasPrefix(key, reservedKeyPrefix) {
+			return true
+		}
+		if limit > 0 && len(resp.Kvs) == limit {
+			resp.More = true
+			return false
+		}
+		resp.Kvs = append(resp.Kvs, &konsen.KV{Key: append([]byte(nil), key...), Value: append([]byte(nil), value...)})
+		return true
+	}); err != nil {
+		return nil, fmt.Errorf("failed to scan values: %w", err)
+	}
+	return resp, nil
+}
+
+// applyTxn applies a transaction from the log at index to local key-value store, returns true if all comparisons held.
+// The comparisons read the state left by the previous log, so the transaction must be applied exactly once.
+func (sm *StateMachine) applyTxn(index uint64, txn *konsen.TxnReq) (bool, error) {
+	succeeded := true
+	for _, c := range txn.GetCompares() {
+		value, err := sm.kv.GetValue(c.GetKey())
+		if err != nil {
+			return false, err
+		}
+		cmp := bytes.Compare(value, c.GetValue())
+		var ok bool
+		switch c.GetOp() {
+		case konsen.Compare_EQUAL:
+			ok = cmp == 0
+		case konsen.Compare_NOT_EQUAL:
+			ok = cmp != 0
+		case konsen.Compare_LESS:
+			ok = cmp < 0
+		case konsen.Compare_GREATER:
+			ok = cmp > 0
+		}
+		if !ok {
+			succeeded = false
+			break
+		}
+	}
+	if succeeded {
+		return true, sm.kv.ApplyKVs(index, txn.GetSuccess())
+	}
+	return false, sm.kv.ApplyKVs(index, txn.GetFailure())
+}
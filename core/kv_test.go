@@ -0,0 +1,173 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+	"github.com/lizhaoliu/konsen/v2/store"
+)
+
+// openTestStorage opens a BoltDB storage in dir.
+func openTestStorage(t *testing.T, dir string) *store.BoltDB {
+	t.Helper()
+	storage, err := store.NewBoltDB(store.BoltDBConfig{FilePath: filepath.Join(dir, "db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage
+}
+
+// newTestStateMachine creates a state machine of a single server cluster on storage, which is not started.
+func newTestStateMachine(t *testing.T, storage store.Storage) *StateMachine {
+	t.Helper()
+	sm, err := NewStateMachine(StateMachineConfig{
+		Storage: storage,
+		Cluster: &ClusterConfig{Servers: map[string]string{"node1": "127.0.0.1:0"}, LocalServerName: "node1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sm
+}
+
+func writeTestLog(t *testing.T, storage store.Storage, index uint64, logType konsen.LogType, msg proto.Message) {
+	t.Helper()
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := &konsen.Log{Index: index, Term: 1, Data: data, Type: logType}
+	log.Checksum = store.LogChecksum(log)
+	if err := storage.WriteLog(log); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkValue(t *testing.T, kv store.KVStore, key string, want string) {
+	t.Helper()
+	value, err := kv.GetValue([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != want {
+		t.Fatalf("got %q = %q, want %q", key, value, want)
+	}
+}
+
+// Transactions read the state left by the logs before them, so applying one again after a restart could take the other
+// branch and make the replica diverge.
+func TestTxnNotReappliedAfterRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "konsen-core-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage := openTestStorage(t, dir)
+	// k is set to "a" if it is missing, "b" otherwise.
+	writeTestLog(t, storage, 1, konsen.LogType_TXN, &konsen.TxnReq{
+		Compares: []*konsen.Compare{{Key: []byte("k"), Op: konsen.Compare_EQUAL}},
+		Success:  []*konsen.KV{{Key: []byte("k"), Value: []byte("a")}},
+		Failure:  []*konsen.KV{{Key: []byte("k"), Value: []byte("b")}},
+	})
+	// n is set to "1" if k is "a", "2" otherwise.
+	writeTestLog(t, storage, 2, konsen.LogType_TXN, &konsen.TxnReq{
+		Compares: []*konsen.Compare{{Key: []byte("k"), Op: konsen.Compare_EQUAL, Value: []byte("a")}},
+		Success:  []*konsen.KV{{Key: []byte("n"), Value: []byte("1")}},
+		Failure:  []*konsen.KV{{Key: []byte("n"), Value: []byte("2")}},
+	})
+	writeTestLog(t, storage, 3, konsen.LogType_DATA, &konsen.KVList{KvList: []*konsen.KV{{Key: []byte("k"), Delete: true}}})
+
+	sm := newTestStateMachine(t, storage)
+	sm.commitIndex = 2
+	if err := sm.maybeApplyLogs(sm.applyLog); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, storage, "k", "a")
+	checkValue(t, storage, "n", "1")
+	storage.Close()
+
+	// After a restart, logs up to the applied index are not applied again, and the following ones are.
+	storage = openTestStorage(t, dir)
+	defer storage.Close()
+	sm = newTestStateMachine(t, storage)
+	if sm.lastApplied != 2 || sm.commitIndex != 2 {
+		t.Fatalf("got last applied %d and commit index %d after restart, want 2", sm.lastApplied, sm.commitIndex)
+	}
+	if err := sm.maybeApplyLogs(sm.applyLog); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, storage, "k", "a")
+	checkValue(t, storage, "n", "1")
+
+	sm.commitIndex = 3
+	if err := sm.maybeApplyLogs(sm.applyLog); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, storage, "k", "")
+	checkValue(t, storage, "n", "1")
+	if index, err := storage.AppliedIndex(); err != nil || index != 3 {
+		t.Fatalf("got applied index %d, %v, want 3", index, err)
+	}
+}
+
+// A proposal whose log is overwritten by the log of a later leader at the same index is answered as not committed
+// when that log is applied, and pending proposals are answered when the state machine is closed.
+func TestProposalOverwritten(t *testing.T) {
+	storage := openTestStorage(t, tempDir(t))
+	defer storage.Close()
+	// Closed at the end of the test.
+	sm := newTestLeader(t, storage, 3)
+
+	replies := make(chan *konsen.AppendDataResp, 2)
+	reply := func(resp *konsen.AppendDataResp) { replies <- resp }
+	proposed, err := sm.writeToLogs([]byte("data"), konsen.LogType_DATA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm.replyWhenLogApplied(context.Background(), proposed, reply)
+
+	// The leader of term 2 replaces the log.
+	sm.setRole(konsen.Role_FOLLOWER)
+	if err := storage.DeleteLogsFrom(proposed.GetIndex()); err != nil {
+		t.Fatal(err)
+	}
+	for _, log := range []*konsen.Log{
+		{Index: proposed.GetIndex(), Term: 2, Type: konsen.LogType_NOOP},
+		{Index: proposed.GetIndex() + 1, Term: 2, Type: konsen.LogType_NOOP},
+	} {
+		if err := storage.WriteLog(log); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sm.commitIndex = proposed.GetIndex()
+	if err := sm.maybeApplyLogs(sm.applyLog); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendDataError(<-replies); !errors.Is(err, ErrNotLeader) {
+		t.Fatalf("got error %v for an overwritten log, want %v", err, ErrNotLeader)
+	}
+
+	sm.replyWhenLogApplied(context.Background(), &konsen.Log{Index: proposed.GetIndex() + 1, Term: 2}, reply)
+	closed := make(chan struct{})
+	go func() {
+		sm.Close()
+		close(closed)
+	}()
+	select {
+	case resp := <-replies:
+		if err := appendDataError(resp); !errors.Is(err, ErrNotLeader) {
+			t.Fatalf("got error %v after close, want %v", err, ErrNotLeader)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("proposal is not answered when the state machine is closed")
+	}
+	<-closed
+}
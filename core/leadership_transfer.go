@@ -0,0 +1,172 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+)
+
+// leadershipTransfer is an ongoing leadership transfer on the leader: once the target server's log is up to date, the
+// leader sends it a TimeoutNow request, which makes it start an election right away and win it with its up-to-date
+// log. The leader stops accepting new data while the transfer is in progress.
+type leadershipTransfer struct {
+	target   string       // Server to transfer leadership to.
+	deadline time.Time    // The transfer is aborted if not done by this time.
+	sent     bool         // True if TimeoutNow request has been sent to target.
+	ch       chan<- error // Receives the transfer result.
+}
+
+// transferLeadershipMsg represents a message to transfer leadership to another server.
+type transferLeadershipMsg struct {
+	target string
+	ch     chan<- error
+}
+
+// timeoutNowWrap
+type timeoutNowWrap struct {
+	req *konsen.TimeoutNowReq
+	ch  chan<- *konsen.TimeoutNowResp
+}
+
+// TransferLeadership transfers leadership from this server (must be the leader) to the target server, it returns
+// after the target server has been elected or the transfer fails.
+func (sm *StateMachine) TransferLeadership(ctx context.Context, target string) error {
+	if err := sm.authorizeAdmin(ctx); err != nil {
+		return err
+	}
+	ch := make(chan error, 1)
+	if err := sm.enqueue(ctx, transferLeadershipMsg{target: target, ch: ch}); err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-sm.corruptedCh:
+		return sm.corruption
+	case err := <-ch:
+		return err
+	}
+}
+
+// TimeoutNow puts the incoming TimeoutNow request in main message channel and waits for result.
+func (sm *StateMachine) TimeoutNow(ctx context.Context, req *konsen.TimeoutNowReq) (*konsen.TimeoutNowResp, error) {
+	ch := make(chan *konsen.TimeoutNowResp, 1)
+	if err := sm.enqueueRaft(ctx, timeoutNowWrap{req: req, ch: ch}); err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-sm.corruptedCh:
+		return nil, sm.corruption
+	case resp := <-ch:
+		return resp, nil
+	}
+}
+
+// handleTransferLeadership starts a leadership transfer.
+func (sm *StateMachine) handleTransferLeadership(target string, ch chan<- error) error {
+	if sm.role != konsen.Role_LEADER {
+		ch <- &requestError{
+			kind: ErrNotLeader,
+			msg:  fmt.Sprintf("%q is not the leader, current leader is %q", sm.cluster.LocalServerName, sm.currentLeader),
+		}
+		return nil
+	}
+	if target == sm.cluster.LocalServerName {
+		ch <- nil
+		return nil
+	}
+	if _, ok := sm.cluster.Servers[target]; !ok {
+		ch <- fmt.Errorf("server %q is not defined in cluster", target)
+		return nil
+	}
+	if sm.transfer != nil {
+		ch <- fmt.Errorf("leadership transfer to %q is already in progress", sm.transfer.target)
+		return nil
+	}
+
+	sm.log().Infof("Transfer leadership to %q.", target)
+	sm.transfer = &leadershipTransfer{
+		target:   target,
+		deadline: time.Now().Add(defaultMinTimeout + defaultTimeoutSpan),
+		ch:       ch,
+	}
+	return sm.maybeSendTimeoutNow()
+}
+
+// maybeSendTimeoutNow sends TimeoutNow request to the leadership transfer target once its log is up to date.
+func (sm *StateMachine) maybeSendTimeoutNow() error {
+	if sm.transfer == nil || sm.transfer.sent {
+		return nil
+	}
+	lastLogIndex, err := sm.logs.LastLogIndex()
+	if err != nil {
+		return fmt.Errorf("failed to get last log index: %w", err)
+	}
+	target := sm.transfer.target
+	if sm.matchIndex[target] < lastLogIndex {
+		return nil
+	}
+	currentTerm, err := sm.stable.GetCurrentTerm()
+	if err != nil {
+		return fmt.Errorf("failed to get current term: %w", err)
+	}
+
+	sm.transfer.sent = true
+	req := &konsen.TimeoutNowReq{Term: currentTerm, LeaderId: sm.cluster.LocalServerName}
+	sm.wg.Add(1)
+	go func() {
+		defer sm.wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+		defer cancel()
+		if _, err := sm.clients[target].TimeoutNow(ctx, req); err != nil {
+			sm.logger.Warnf("Failed to send TimeoutNow to %q: %v", target, err)
+		}
+	}()
+	return nil
+}
+
+// maybeFinishTransfer completes the ongoing leadership transfer once this server is no longer the leader, or aborts it
+// after the deadline.
+func (sm *StateMachine) maybeFinishTransfer() {
+	if sm.transfer == nil {
+		return
+	}
+	if sm.role != konsen.Role_LEADER {
+		sm.transfer.ch <- nil
+		sm.transfer = nil
+		return
+	}
+	if time.Now().After(sm.transfer.deadline) {
+		sm.log().Warnf("Leadership transfer to %q timed out.", sm.transfer.target)
+		sm.transfer.ch <- &requestError{
+			kind: ErrTimeout,
+			msg:  fmt.Sprintf("timed out transferring leadership to %q", sm.transfer.target),
+		}
+		sm.transfer = nil
+	}
+}
+
+// handleTimeoutNow handles a TimeoutNow request, it starts an election immediately.
+func (sm *StateMachine) handleTimeoutNow(req *konsen.TimeoutNowReq) (*konsen.TimeoutNowResp, error) {
+	sm.resetElectionTimer()
+	defer sm.openElectionTimerGate()
+
+	// If RPC request or response contains term T > currentTerm: set currentTerm = T, convert to follower.
+	currentTerm, err := sm.maybeBecomeFollower(req.GetTerm())
+	if err != nil {
+		return nil, err
+	}
+	if req.GetTerm() < currentTerm {
+		return &konsen.TimeoutNowResp{Term: currentTerm, Success: false}, nil
+	}
+
+	sm.log().Infof("Received TimeoutNow from %q, start election.", req.GetLeaderId())
+	if err := sm.startElection(); err != nil {
+		return nil, err
+	}
+	return &konsen.TimeoutNowResp{Term: currentTerm, Success: true}, nil
+}
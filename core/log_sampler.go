@@ -0,0 +1,43 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// Minimum interval between logging messages of the same kind on hot paths, such as failures of heartbeats.
+const defaultLogSampleInterval = 10 * time.Second
+
+// logSampler limits how often messages of the same kind are logged, it is safe for concurrent use.
+type logSampler struct {
+	interval time.Duration
+
+	mu         sync.Mutex
+	last       map[string]time.Time // Time a message of each kind was last logged.
+	suppressed map[string]int       // Number of messages of each kind suppressed since last logged.
+}
+
+func newLogSampler(interval time.Duration) *logSampler {
+	return &logSampler{
+		interval:   interval,
+		last:       make(map[string]time.Time),
+		suppressed: make(map[string]int),
+	}
+}
+
+// sample returns true if a message of the given kind should be logged now, along with the number of messages of the
+// kind suppressed since it was last logged.
+func (s *logSampler) sample(kind string) (bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if last, ok := s.last[kind]; ok && now.Sub(last) < s.interval {
+		s.suppressed[kind]++
+		return false, 0
+	}
+	n := s.suppressed[kind]
+	s.last[kind] = now
+	s.suppressed[kind] = 0
+	return true, n
+}
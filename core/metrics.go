@@ -0,0 +1,72 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Timeout of getting the status of the state machine on scrape.
+const collectTimeout = time.Second
+
+var (
+	termDesc         = prometheus.NewDesc("konsen_term", "Current term.", nil, nil)
+	roleDesc         = prometheus.NewDesc("konsen_role", "Current role, 1 for the role the server is in and 0 for the others.", []string{"role"}, nil)
+	commitIndexDesc  = prometheus.NewDesc("konsen_commit_index", "Index of highest log entry known to be committed.", nil, nil)
+	lastAppliedDesc  = prometheus.NewDesc("konsen_last_applied", "Index of highest log entry applied to state machine.", nil, nil)
+	lastLogIndexDesc = prometheus.NewDesc("konsen_last_log_index", "Index of the last log entry.", nil, nil)
+	followerLagDesc  = prometheus.NewDesc("konsen_follower_lag", "Number of log entries not yet known to be replicated on a follower, only reported by leader.", []string{"follower"}, nil)
+	corruptedDesc    = prometheus.NewDesc("konsen_corrupted", "1 if log corruption has been detected on this server.", nil, nil)
+)
+
+// StatusCollector is a Prometheus collector that reports the Raft status of a state machine as gauges on scrape.
+type StatusCollector struct {
+	sm *StateMachine
+}
+
+// NewStatusCollector creates a StatusCollector of the given state machine.
+func NewStatusCollector(sm *StateMachine) *StatusCollector {
+	return &StatusCollector{sm: sm}
+}
+
+func (c *StatusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- termDesc
+	ch <- roleDesc
+	ch <- commitIndexDesc
+	ch <- lastAppliedDesc
+	ch <- lastLogIndexDesc
+	ch <- followerLagDesc
+	ch <- corruptedDesc
+}
+
+func (c *StatusCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+	status, err := c.sm.GetStatus(ctx)
+	if err != nil {
+		c.sm.logger.Warnf("Failed to collect status metrics: %v", err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(termDesc, prometheus.GaugeValue, float64(status.Term))
+	for _, role := range []konsen.Role{konsen.Role_FOLLOWER, konsen.Role_CANDIDATE, konsen.Role_LEADER} {
+		v := 0.0
+		if role == status.Role {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(roleDesc, prometheus.GaugeValue, v, role.String())
+	}
+	ch <- prometheus.MustNewConstMetric(commitIndexDesc, prometheus.GaugeValue, float64(status.CommitIndex))
+	ch <- prometheus.MustNewConstMetric(lastAppliedDesc, prometheus.GaugeValue, float64(status.LastApplied))
+	ch <- prometheus.MustNewConstMetric(lastLogIndexDesc, prometheus.GaugeValue, float64(status.LastLogIndex))
+	for follower, f := range status.Followers {
+		ch <- prometheus.MustNewConstMetric(followerLagDesc, prometheus.GaugeValue, float64(f.Lag), follower)
+	}
+	corrupted := 0.0
+	if status.Corruption != "" {
+		corrupted = 1
+	}
+	ch <- prometheus.MustNewConstMetric(corruptedDesc, prometheus.GaugeValue, corrupted)
+}
@@ -0,0 +1,424 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lizhaoliu/konsen/v2/tracing"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// NodeConfigVersion is the version of node configuration files that this release reads.
+const NodeConfigVersion = 1
+
+// EnvPrefix is the prefix of environment variables that override fields of the node configuration, e.g.
+// KONSEN_STORAGE_DIR overrides storage.dir, and KONSEN_RAFT_HEARTBEAT_INTERVAL overrides raft.heartbeatInterval.
+const EnvPrefix = "KONSEN_"
+
+// NodeConfig is the configuration of a node: the cluster it belongs to and everything about the local server.
+type NodeConfig struct {
+	Version       int `yaml:"version"` // Version of the configuration format, must be NodeConfigVersion.
+	ClusterConfig `yaml:",inline"`
+
+	Listen    ListenConfig    `yaml:"listen"`
+	Advertise AdvertiseConfig `yaml:"advertise,omitempty"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Raft      RaftConfig      `yaml:"raft"`
+	HTTP      HTTPConfig      `yaml:"http"`
+	RPC       RPCConfig       `yaml:"rpc"`
+	Logging   LoggingConfig   `yaml:"logging"`
+	Tracing   TraceConfig     `yaml:"tracing"`
+	Limits    LimitsConfig    `yaml:"limits"`
+}
+
+// ListenConfig is the local addresses that servers bind to.
+type ListenConfig struct {
+	Raft  string `yaml:"raft"`  // Address of the gRPC server (Raft and KV services), defaults to the advertised one.
+	HTTP  string `yaml:"http"`  // Address of the HTTP server, defaults to the advertised one.
+	Pprof string `yaml:"pprof"` // Address of the pprof server, empty disables it.
+}
+
+// AdvertiseConfig is the addresses that peers and clients connect to, i.e. the local server's entries in servers and
+// httpServers, which they are filled into if missing.
+type AdvertiseConfig struct {
+	Raft string `yaml:"raft,omitempty"`
+	HTTP string `yaml:"http,omitempty"`
+}
+
+// StorageConfig is the local storage of Raft logs, state and key-value pairs.
+type StorageConfig struct {
+	Engine       string `yaml:"engine"`             // One of: badger, boltdb, wal.
+	Dir          string `yaml:"dir"`                // Directory of all data files, unless overridden below.
+	LogDir       string `yaml:"logDir,omitempty"`   // Directory of Raft logs (badger, wal), defaults to a subdirectory of dir.
+	StateDir     string `yaml:"stateDir,omitempty"` // Directory of state and key-value pairs (badger, wal), defaults to a subdirectory of dir.
+	LogCacheSize int    `yaml:"logCacheSize"`       // Number of most recent log entries cached in memory, 0 disables the cache.
+}
+
+// RaftConfig is the timing of the Raft protocol. Election timeouts are chosen randomly in [electionTimeoutMin,
+// electionTimeoutMax), which must be well above the heartbeat interval.
+type RaftConfig struct {
+	HeartbeatInterval        Duration `yaml:"heartbeatInterval"`
+	ElectionTimeoutMin       Duration `yaml:"electionTimeoutMin"`
+	ElectionTimeoutMax       Duration `yaml:"electionTimeoutMax"`
+	ConsistencyCheckInterval Duration `yaml:"consistencyCheckInterval"` // 0 disables cross-replica consistency checks.
+}
+
+// HTTPConfig is the timeouts of the HTTP server.
+type HTTPConfig struct {
+	ReadTimeout  Duration `yaml:"readTimeout"`
+	WriteTimeout Duration `yaml:"writeTimeout"`
+}
+
+// RPCConfig is the timeouts of gRPC servers and clients.
+type RPCConfig struct {
+	RequestTimeout Duration `yaml:"requestTimeout"` // Timeout of serving each request.
+	ConnectTimeout Duration `yaml:"connectTimeout"` // Timeout of connecting to each peer at startup.
+}
+
+// LoggingConfig is the level and format of logs.
+type LoggingConfig struct {
+	Level  string `yaml:"level"`  // One of: debug, info, warn, error.
+	Format string `yaml:"format"` // One of: text, json.
+}
+
+// TraceConfig is the export of OpenTelemetry spans.
+type TraceConfig struct {
+	Exporter     string  `yaml:"exporter"`     // One of: none, stdout, otlp.
+	OTLPEndpoint string  `yaml:"otlpEndpoint"` // Endpoint of the OTLP collector (gRPC).
+	SampleRatio  float64 `yaml:"sampleRatio"`  // Fraction of client requests to trace.
+}
+
+// LimitsConfig is the admission control of proposals.
+type LimitsConfig struct {
+	MaxPendingProposals     int   `yaml:"maxPendingProposals"`     // 0 means unlimited.
+	MaxPendingProposalBytes int64 `yaml:"maxPendingProposalBytes"` // 0 means unlimited.
+}
+
+// Duration is a time.Duration written as a string such as "100ms" in YAML.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+// DefaultNodeConfig returns the node configuration with default values of everything but the cluster.
+func DefaultNodeConfig() *NodeConfig {
+	return &NodeConfig{
+		Version: NodeConfigVersion,
+		Listen:  ListenConfig{Pprof: ":6060"},
+		Storage: StorageConfig{Engine: "badger", Dir: "db", LogCacheSize: 1024},
+		Raft: RaftConfig{
+			HeartbeatInterval:        Duration(defaultHeartbeat),
+			ElectionTimeoutMin:       Duration(defaultMinTimeout),
+			ElectionTimeoutMax:       Duration(defaultMinTimeout + defaultTimeoutSpan),
+			ConsistencyCheckInterval: Duration(5 * time.Minute),
+		},
+		HTTP:    HTTPConfig{ReadTimeout: Duration(10 * time.Second), WriteTimeout: Duration(10 * time.Second)},
+		RPC:     RPCConfig{RequestTimeout: Duration(10 * time.Second), ConnectTimeout: Duration(30 * time.Second)},
+		Logging: LoggingConfig{Level: "info", Format: "text"},
+		Tracing: TraceConfig{Exporter: tracing.ExporterNone, OTLPEndpoint: "localhost:4317", SampleRatio: 1},
+		Limits:  LimitsConfig{MaxPendingProposals: 1024, MaxPendingProposalBytes: 64 << 20},
+	}
+}
+
+// LoadNodeConfig reads given node config YAML file over the defaults, without validating it. Unknown fields are
+// errors, so that misspelled ones are not silently ignored.
+func LoadNodeConfig(cfgFilePath string) (*NodeConfig, error) {
+	buf, err := ioutil.ReadFile(cfgFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read node config file: %v", err)
+	}
+
+	config := DefaultNodeConfig()
+	config.Version = 0
+	if err := yaml.UnmarshalStrict(buf, config); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal node config file %s: %v", cfgFilePath, err)
+	}
+	return config, nil
+}
+
+// ApplyEnv overrides fields with the environment variables returned by lookup (e.g. os.LookupEnv), each field has a
+// variable named EnvPrefix followed by the upper snake case of its path, such as KONSEN_LISTEN_HTTP for listen.http.
+// Servers and httpServers can not be overridden.
+func (c *NodeConfig) ApplyEnv(lookup func(key string) (string, bool)) error {
+	return applyEnv(reflect.ValueOf(c).Elem(), "", lookup)
+}
+
+func applyEnv(v reflect.Value, path string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field, value := t.Field(i), v.Field(i)
+		tag := strings.Split(field.Tag.Get("yaml"), ",")
+		name := tag[0]
+		if len(tag) > 1 && tag[1] == "inline" {
+			if err := applyEnv(value, path, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		if path != "" {
+			name = path + "." + name
+		}
+
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			if err := applyEnv(value, name, lookup); err != nil {
+				return err
+			}
+		case field.Type.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.Struct:
+			// Only set the section if any of its fields is overridden.
+			section := reflect.New(field.Type.Elem())
+			if !value.IsNil() {
+				section.Elem().Set(value.Elem())
+			}
+			before := reflect.Indirect(section).Interface()
+			if err := applyEnv(section.Elem(), name, lookup); err != nil {
+				return err
+			}
+			if !value.IsNil() || !reflect.DeepEqual(before, section.Elem().Interface()) {
+				value.Set(section)
+			}
+		case field.Type.Kind() == reflect.Map:
+		default:
+			key := EnvPrefix + envName(name)
+			s, ok := lookup(key)
+			if !ok {
+				continue
+			}
+			if value.Kind() == reflect.String {
+				value.SetString(s)
+				continue
+			}
+			// Other values are parsed as YAML, like in the config file.
+			if err := yaml.Unmarshal([]byte(s), value.Addr().Interface()); err != nil {
+				return fmt.Errorf("%s: invalid value %q of %s: %v", name, s, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// envName converts a field path such as "raft.heartbeatInterval" to "RAFT_HEARTBEAT_INTERVAL".
+func envName(path string) string {
+	var b strings.Builder
+	for i, r := range path {
+		switch {
+		case r == '.':
+			b.WriteByte('_')
+		case unicode.IsUpper(r) && i > 0 && path[i-1] != '.' && !unicode.IsUpper(rune(path[i-1])):
+			b.WriteByte('_')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
+
+// configErrors collects validation errors, each pointing at the offending field.
+type configErrors []string
+
+func (e *configErrors) add(field string, format string, args ...interface{}) {
+	*e = append(*e, fmt.Sprintf("%s: %s", field, fmt.Sprintf(format, args...)))
+}
+
+// Validate checks the configuration, and fills in the advertised and listen addresses derived from the others.
+func (c *NodeConfig) Validate() error {
+	var errs configErrors
+
+	if c.Version != NodeConfigVersion {
+		errs.add("version", "unsupported version %d, expecting %d", c.Version, NodeConfigVersion)
+	}
+
+	// Cluster.
+	local := c.LocalServerName
+	if local == "" {
+		errs.add("localServerName", "must be specified")
+	}
+	if c.Servers == nil {
+		c.Servers = make(map[string]string)
+	}
+	if c.HttpServers == nil {
+		c.HttpServers = make(map[string]string)
+	}
+	c.resolveAdvertise(&errs, "advertise.raft", c.Advertise.Raft, "servers", c.Servers)
+	c.resolveAdvertise(&errs, "advertise.http", c.Advertise.HTTP, "httpServers", c.HttpServers)
+	if len(c.Servers)%2 != 1 {
+		errs.add("servers", "number of servers must be odd, got %d", len(c.Servers))
+	}
+	if local != "" {
+		if _, ok := c.Servers[local]; !ok {
+			errs.add("servers", "local server %q is not defined", local)
+		}
+		if _, ok := c.HttpServers[local]; !ok {
+			errs.add("httpServers", "local server %q is not defined", local)
+		}
+	}
+	for _, name := range sortedKeys(c.Servers) {
+		checkAddress(&errs, "servers."+name, c.Servers[name])
+	}
+	for _, name := range sortedKeys(c.HttpServers) {
+		checkAddress(&errs, "httpServers."+name, c.HttpServers[name])
+		if _, ok := c.Servers[name]; !ok {
+			errs.add("httpServers."+name, "server is not defined in servers")
+		}
+	}
+	if tls := c.TLS; tls != nil {
+		if tls.CAFile == "" {
+			errs.add("tls.caFile", "must be specified for TLS")
+		}
+		if tls.CertFile == "" {
+			errs.add("tls.certFile", "must be specified for TLS")
+		}
+		if tls.KeyFile == "" {
+			errs.add("tls.keyFile", "must be specified for TLS")
+		}
+	}
+
+	// Listen addresses.
+	if c.Listen.Raft == "" {
+		c.Listen.Raft = c.Servers[local]
+	}
+	if c.Listen.HTTP == "" {
+		c.Listen.HTTP = c.HttpServers[local]
+	}
+	// Empty ones are reported above, as the local server is not defined.
+	if c.Listen.Raft != "" {
+		checkAddress(&errs, "listen.raft", c.Listen.Raft)
+	}
+	if c.Listen.HTTP != "" {
+		checkAddress(&errs, "listen.http", c.Listen.HTTP)
+	}
+	if c.Listen.Pprof != "" {
+		checkAddress(&errs, "listen.pprof", c.Listen.Pprof)
+	}
+
+	// Storage.
+	switch c.Storage.Engine {
+	case "badger", "boltdb", "wal":
+	default:
+		errs.add("storage.engine", "unknown engine %q, expecting one of: badger, boltdb, wal", c.Storage.Engine)
+	}
+	if c.Storage.Dir == "" {
+		errs.add("storage.dir", "must be specified")
+	}
+	if c.Storage.LogCacheSize < 0 {
+		errs.add("storage.logCacheSize", "must not be negative, got %d", c.Storage.LogCacheSize)
+	}
+
+	// Raft timings.
+	raft := c.Raft
+	checkPositive(&errs, "raft.heartbeatInterval", raft.HeartbeatInterval)
+	if raft.ElectionTimeoutMin <= raft.HeartbeatInterval {
+		errs.add("raft.electionTimeoutMin", "must be greater than raft.heartbeatInterval (%v), got %v",
+			time.Duration(raft.HeartbeatInterval), time.Duration(raft.ElectionTimeoutMin))
+	}
+	if raft.ElectionTimeoutMax <= raft.ElectionTimeoutMin {
+		errs.add("raft.electionTimeoutMax", "must be greater than raft.electionTimeoutMin (%v), got %v",
+			time.Duration(raft.ElectionTimeoutMin), time.Duration(raft.ElectionTimeoutMax))
+	}
+	if raft.ConsistencyCheckInterval < 0 {
+		errs.add("raft.consistencyCheckInterval", "must not be negative, got %v", time.Duration(raft.ConsistencyCheckInterval))
+	}
+
+	// Timeouts.
+	checkPositive(&errs, "http.readTimeout", c.HTTP.ReadTimeout)
+	checkPositive(&errs, "http.writeTimeout", c.HTTP.WriteTimeout)
+	checkPositive(&errs, "rpc.requestTimeout", c.RPC.RequestTimeout)
+	checkPositive(&errs, "rpc.connectTimeout", c.RPC.ConnectTimeout)
+
+	// Logging and tracing.
+	if _, err := log.ParseLevel(c.Logging.Level); err != nil {
+		errs.add("logging.level", "unknown level %q, expecting one of: debug, info, warn, error", c.Logging.Level)
+	}
+	if c.Logging.Format != "text" && c.Logging.Format != "json" {
+		errs.add("logging.format", "unknown format %q, expecting one of: text, json", c.Logging.Format)
+	}
+	switch c.Tracing.Exporter {
+	case tracing.ExporterNone, tracing.ExporterStdout:
+	case tracing.ExporterOTLP:
+		checkAddress(&errs, "tracing.otlpEndpoint", c.Tracing.OTLPEndpoint)
+	default:
+		errs.add("tracing.exporter", "unknown exporter %q, expecting one of: none, stdout, otlp", c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs.add("tracing.sampleRatio", "must be within [0, 1], got %v", c.Tracing.SampleRatio)
+	}
+
+	// Limits.
+	if c.Limits.MaxPendingProposals < 0 {
+		errs.add("limits.maxPendingProposals", "must not be negative, got %d", c.Limits.MaxPendingProposals)
+	}
+	if c.Limits.MaxPendingProposalBytes < 0 {
+		errs.add("limits.maxPendingProposalBytes", "must not be negative, got %d", c.Limits.MaxPendingProposalBytes)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid node config:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// resolveAdvertise fills the local server's entry in servers with the advertised address, which must match the entry
+// if both are set.
+func (c *NodeConfig) resolveAdvertise(errs *configErrors, field string, address string, serversField string, servers map[string]string) {
+	if address == "" || c.LocalServerName == "" {
+		return
+	}
+	if existing, ok := servers[c.LocalServerName]; ok && existing != address {
+		errs.add(field, "%q conflicts with %s.%s (%q)", address, serversField, c.LocalServerName, existing)
+		return
+	}
+	servers[c.LocalServerName] = address
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func checkPositive(errs *configErrors, field string, d Duration) {
+	if d <= 0 {
+		errs.add(field, "must be positive, got %v", time.Duration(d))
+	}
+}
+
+func checkAddress(errs *configErrors, field string, address string) {
+	if address == "" {
+		errs.add(field, "must be specified")
+		return
+	}
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		errs.add(field, "invalid address %q: %v", address, err)
+		return
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		errs.add(field, "invalid port %q in address %q", port, address)
+	}
+}
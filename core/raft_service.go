@@ -16,4 +16,7 @@ type RaftService interface {
 
 	// AppendData sends AppendData request to the remote server.
 	AppendData(ctx context.Context, in *konsen.AppendDataReq) (*konsen.AppendDataResp, error)
+
+	// TimeoutNow sends TimeoutNow request to the remote server.
+	TimeoutNow(ctx context.Context, in *konsen.TimeoutNowReq) (*konsen.TimeoutNowResp, error)
 }
@@ -0,0 +1,320 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+)
+
+const (
+	// Number of recent AppendEntries rounds whose send times are kept on the leader.
+	numRoundTimes = 64
+	// Maximum time a read waits for logs up to its min index to be applied, before it fails as too stale and can be
+	// retried on the leader.
+	maxIndexWait = 2 * time.Second
+)
+
+// ReadConsistency is the consistency of a read, see konsen.Consistency.
+type ReadConsistency struct {
+	Level        konsen.Consistency
+	MaxStaleness time.Duration // For BOUNDED_STALENESS, see konsen.ReadOptions.
+	MaxIndexLag  uint64        // For BOUNDED_STALENESS, used if MaxStaleness is 0.
+	MinIndex     uint64        // Log index the read waits to be applied, e.g. the revision of a write to observe.
+}
+
+var (
+	Linearizable = ReadConsistency{Level: konsen.Consistency_LINEARIZABLE}
+	LeaderLocal  = ReadConsistency{Level: konsen.Consistency_LEADER_LOCAL}
+	Stale        = ReadConsistency{Level: konsen.Consistency_STALE}
+)
+
+// ParseReadConsistency parses one of: linearizable, leader-local, stale, bounded-staleness=<duration> (e.g. 500ms) and
+// bounded-staleness=<index lag> (e.g. 100). Empty is linearizable.
+func ParseReadConsistency(s string) (ReadConsistency, error) {
+	switch s {
+	case "", "linearizable":
+		return Linearizable, nil
+	case "leader-local":
+		return LeaderLocal, nil
+	case "stale":
+		return Stale, nil
+	}
+	bound := strings.TrimPrefix(s, "bounded-staleness=")
+	if bound == s {
+		return ReadConsistency{}, fmt.Errorf("invalid consistency %q, expecting one of: linearizable, leader-local, stale, bounded-staleness=<duration or index lag>", s)
+	}
+	if lag, err := strconv.ParseUint(bound, 10, 64); err == nil {
+		return ReadConsistency{Level: konsen.Consistency_BOUNDED_STALENESS, MaxIndexLag: lag}, nil
+	}
+	d, err := time.ParseDuration(bound)
+	if err != nil || d < time.Millisecond {
+		return ReadConsistency{}, fmt.Errorf("invalid bounded staleness %q, expecting a duration of at least 1ms or an index lag", bound)
+	}
+	return ReadConsistency{Level: konsen.Consistency_BOUNDED_STALENESS, MaxStaleness: d}, nil
+}
+
+// String returns the consistency in the form parsed by ParseReadConsistency, without MinIndex.
+func (c ReadConsistency) String() string {
+	switch c.Level {
+	case konsen.Consistency_LINEARIZABLE:
+		return "linearizable"
+	case konsen.Consistency_LEADER_LOCAL:
+		return "leader-local"
+	case konsen.Consistency_STALE:
+		return "stale"
+	case konsen.Consistency_BOUNDED_STALENESS:
+		if c.MaxStaleness > 0 {
+			return "bounded-staleness=" + c.MaxStaleness.String()
+		}
+		return "bounded-staleness=" + strconv.FormatUint(c.MaxIndexLag, 10)
+	default:
+		return c.Level.String()
+	}
+}
+
+// Options returns the read options of requests with this consistency.
+func (c ReadConsistency) Options() *konsen.ReadOptions {
+	return &konsen.ReadOptions{
+		Consistency:    c.Level,
+		MaxStalenessMs: c.MaxStaleness.Milliseconds(),
+		MaxIndexLag:    c.MaxIndexLag,
+		MinIndex:       c.MinIndex,
+	}
+}
+
+// pendingRead is a linearizable read waiting on the leader until its leadership is confirmed by a round of
+// AppendEntries sent after the read arrived, and logs up to the read index are applied.
+type pendingRead struct {
+	ctx   context.Context
+	index uint64       // Read index: commit index when the read arrived.
+	round uint64       // Round of AppendEntries that confirms the leadership for the read.
+	serve func() error // Serves the read from the local state, and replies to the caller.
+	errCh chan<- error // Replies an error to the caller.
+}
+
+// indexWait is a read waiting for logs up to its min index to be applied, before it is handled with its consistency.
+type indexWait struct {
+	ctx      context.Context
+	opts     *konsen.ReadOptions
+	deadline time.Time
+	serve    func() error
+	errCh    chan<- error
+}
+
+// roundTime is the time a round of AppendEntries is sent.
+type roundTime struct {
+	round  uint64
+	sentAt time.Time
+}
+
+// handleRead serves a read with given options once logs up to its min index are applied: right away if the local state
+// satisfies its consistency, or once the leadership of this server is confirmed for a linearizable read. Otherwise the
+// error is replied on errCh.
+func (sm *StateMachine) handleRead(ctx context.Context, opts *konsen.ReadOptions, errCh chan<- error, serve func() error) error {
+	if opts.GetMinIndex() > sm.lastApplied {
+		sm.indexWaits = append(sm.indexWaits, &indexWait{ctx: ctx, opts: opts, deadline: time.Now().Add(maxIndexWait), serve: serve, errCh: errCh})
+		return nil
+	}
+	switch opts.GetConsistency() {
+	case konsen.Consistency_STALE:
+		return serve()
+	case konsen.Consistency_LEADER_LOCAL:
+		if sm.role != konsen.Role_LEADER {
+			errCh <- sm.leaderReadError()
+			return nil
+		}
+		return serve()
+	case konsen.Consistency_BOUNDED_STALENESS:
+		if err := sm.checkStaleness(opts); err != nil {
+			errCh <- err
+			return nil
+		}
+		return serve()
+	case konsen.Consistency_LINEARIZABLE:
+		if sm.role != konsen.Role_LEADER {
+			errCh <- sm.leaderReadError()
+			return nil
+		}
+		// Entries committed by previous leaders are only known to be committed once the no-op entry of this term is.
+		index := sm.commitIndex
+		if index < sm.termStartIndex {
+			index = sm.termStartIndex
+		}
+		sm.pendingReads = append(sm.pendingReads, &pendingRead{ctx: ctx, index: index, round: sm.round + 1, serve: serve, errCh: errCh})
+		return nil
+	default:
+		errCh <- fmt.Errorf("unknown consistency: %v", opts.GetConsistency())
+		return nil
+	}
+}
+
+// leaderReadError returns the error of a read that must be served by the leader, on a server that is not.
+func (sm *StateMachine) leaderReadError() error {
+	if sm.currentLeader == "" {
+		return &requestError{kind: ErrNoQuorum, msg: fmt.Sprintf("no leader is elected yet (are there more than %d nodes down?)", sm.getQuorum())}
+	}
+	return &requestError{kind: ErrNotLeader, msg: fmt.Sprintf("read must be served by the leader %q", sm.currentLeader)}
+}
+
+// checkStaleness returns an error if the local state is too far behind the leader for a bounded staleness read. A
+// follower measures it from the latest AppendEntries of the leader, the leader from the latest round of AppendEntries
+// acknowledged by a quorum. A bound on the index lag alone is only meaningful as long as the leader is heard from, so it
+// also requires contact within the maximum election timeout, after which a partitioned follower would have started an
+// election.
+func (sm *StateMachine) checkStaleness(opts *konsen.ReadOptions) error {
+	contact, commit := sm.leaderContact, sm.leaderCommit
+	if sm.role == konsen.Role_LEADER {
+		contact, commit = sm.confirmedAt, sm.commitIndex
+	}
+	if contact.IsZero() {
+		return &requestError{kind: ErrTooStale, msg: fmt.Sprintf("%q has not heard from a leader", sm.cluster.LocalServerName)}
+	}
+	var lag uint64
+	if commit > sm.lastApplied {
+		lag = commit - sm.lastApplied
+	}
+	if maxStaleness := time.Duration(opts.GetMaxStalenessMs()) * time.Millisecond; maxStaleness > 0 {
+		if age := time.Since(contact); age > maxStaleness || lag > 0 {
+			return &requestError{kind: ErrTooStale, msg: fmt.Sprintf("%q heard from the leader %v ago with %d committed entries not yet applied, more than %v stale",
+				sm.cluster.LocalServerName, age.Round(time.Millisecond), lag, maxStaleness)}
+		}
+		return nil
+	}
+	if age := time.Since(contact); age > sm.electionTimeoutMax {
+		return &requestError{kind: ErrTooStale, msg: fmt.Sprintf("%q heard from the leader %v ago, more than the election timeout %v",
+			sm.cluster.LocalServerName, age.Round(time.Millisecond), sm.electionTimeoutMax)}
+	}
+	if lag > opts.GetMaxIndexLag() {
+		return &requestError{kind: ErrTooStale, msg: fmt.Sprintf("%q is %d entries behind the leader, more than %d",
+			sm.cluster.LocalServerName, lag, opts.GetMaxIndexLag())}
+	}
+	return nil
+}
+
+// maybeServeReads handles reads whose min index is applied, serves pending reads that are confirmed and whose read
+// index is applied, drops the ones whose callers have given up, and sends a new round of AppendEntries if some reads
+// wait for one and none is in flight.
+func (sm *StateMachine) maybeServeReads() error {
+	if err := sm.maybeFinishIndexWaits(); err != nil {
+		return err
+	}
+	if len(sm.pendingReads) == 0 {
+		return nil
+	}
+	remaining := sm.pendingReads[:0]
+	needRound := false
+	for _, r := range sm.pendingReads {
+		switch {
+		case r.ctx.Err() != nil:
+		case r.round <= sm.confirmedRound && r.index <= sm.lastApplied:
+			if err := r.serve(); err != nil {
+				return err
+			}
+		default:
+			remaining = append(remaining, r)
+			needRound = needRound || r.round > sm.round
+		}
+	}
+	for i := len(remaining); i < len(sm.pendingReads); i++ {
+		sm.pendingReads[i] = nil
+	}
+	sm.pendingReads = remaining
+	if needRound && sm.confirmedRound == sm.round {
+		if err := sm.sendAppendEntries(context.Background()); err != nil {
+			return err
+		}
+		// Confirmed right away without peers.
+		if sm.confirmedRound == sm.round {
+			return sm.maybeServeReads()
+		}
+	}
+	return nil
+}
+
+// maybeFinishIndexWaits handles the reads whose min index is applied with their consistency, fails the ones that have
+// waited too long, and drops the ones whose callers have given up.
+func (sm *StateMachine) maybeFinishIndexWaits() error {
+	if len(sm.indexWaits) == 0 {
+		return nil
+	}
+	waits := sm.indexWaits
+	sm.indexWaits = nil
+	now := time.Now()
+	for i, w := range waits {
+		switch {
+		case w.ctx.Err() != nil:
+		case w.opts.GetMinIndex() <= sm.lastApplied:
+			if err := sm.handleRead(w.ctx, w.opts, w.errCh, w.serve); err != nil {
+				sm.indexWaits = append(sm.indexWaits, waits[i+1:]...)
+				return err
+			}
+		case now.After(w.deadline):
+			w.errCh <- &requestError{kind: ErrTooStale, msg: fmt.Sprintf("%q has applied logs up to index %d, not %d after %v",
+				sm.cluster.LocalServerName, sm.lastApplied, w.opts.GetMinIndex(), maxIndexWait)}
+		default:
+			sm.indexWaits = append(sm.indexWaits, w)
+		}
+	}
+	return nil
+}
+
+// failPendingReads fails all pending reads, when this server stops being the leader.
+func (sm *StateMachine) failPendingReads() {
+	for _, r := range sm.pendingReads {
+		r.errCh <- &requestError{kind: ErrNotLeader, msg: "leadership is lost before the read is confirmed"}
+	}
+	sm.pendingReads = nil
+}
+
+// startRound starts a new round of AppendEntries on the leader, and returns it.
+func (sm *StateMachine) startRound() uint64 {
+	sm.round++
+	sm.roundTimes[sm.round%numRoundTimes] = roundTime{round: sm.round, sentAt: time.Now()}
+	// A leader without peers is the majority by itself.
+	if sm.getQuorum() == 0 {
+		sm.confirmRound(sm.round)
+	}
+	return sm.round
+}
+
+// ackRound records that a follower has acknowledged the leadership of this server in a round of AppendEntries.
+func (sm *StateMachine) ackRound(server string, round uint64) {
+	if round <= sm.ackedRounds[server] {
+		return
+	}
+	sm.ackedRounds[server] = round
+	rounds := make([]uint64, 0, len(sm.ackedRounds))
+	for _, r := range sm.ackedRounds {
+		rounds = append(rounds, r)
+	}
+	quorum := sm.getQuorum()
+	if len(rounds) < quorum {
+		return
+	}
+	// With the leader itself, the rounds acknowledged by the quorum-th follower have reached a majority.
+	sort.Slice(rounds, func(i, j int) bool { return rounds[i] > rounds[j] })
+	sm.confirmRound(rounds[quorum-1])
+}
+
+// confirmRound records that a quorum has acknowledged the leadership of this server in a round of AppendEntries.
+func (sm *StateMachine) confirmRound(round uint64) {
+	if round <= sm.confirmedRound {
+		return
+	}
+	sm.confirmedRound = round
+	if t := sm.roundTimes[round%numRoundTimes]; t.round == round {
+		sm.confirmedAt = t.sentAt
+	}
+}
+
+// resetRounds resets the confirmation of leadership, when this server becomes the leader.
+func (sm *StateMachine) resetRounds() {
+	sm.ackedRounds = make(map[string]uint64)
+	sm.confirmedRound = sm.round
+	sm.confirmedAt = time.Time{}
+}
@@ -0,0 +1,314 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+	"github.com/lizhaoliu/konsen/v2/store"
+)
+
+// unreachableClient is a peer that can not be reached, responses of peers are handed to the state machine by tests.
+type unreachableClient struct{}
+
+var errUnreachable = errors.New("unreachable")
+
+func (unreachableClient) AppendEntries(context.Context, *konsen.AppendEntriesReq) (*konsen.AppendEntriesResp, error) {
+	return nil, errUnreachable
+}
+
+func (unreachableClient) RequestVote(context.Context, *konsen.RequestVoteReq) (*konsen.RequestVoteResp, error) {
+	return nil, errUnreachable
+}
+
+func (unreachableClient) AppendData(context.Context, *konsen.AppendDataReq) (*konsen.AppendDataResp, error) {
+	return nil, errUnreachable
+}
+
+func (unreachableClient) TimeoutNow(context.Context, *konsen.TimeoutNowReq) (*konsen.TimeoutNowResp, error) {
+	return nil, errUnreachable
+}
+
+// newTestLeader creates the state machine of node1 in a cluster of n servers on storage, which has become the leader of
+// term 1 without starting its message loop. It must be closed.
+func newTestLeader(t *testing.T, storage store.Storage, n int) *StateMachine {
+	t.Helper()
+	cluster := &ClusterConfig{Servers: make(map[string]string), LocalServerName: "node1"}
+	clients := make(map[string]RaftService)
+	for i := 1; i <= n; i++ {
+		name := "node" + strconv.Itoa(i)
+		cluster.Servers[name] = "127.0.0.1:0"
+		if name != cluster.LocalServerName {
+			clients[name] = unreachableClient{}
+		}
+	}
+	sm, err := NewStateMachine(StateMachineConfig{Storage: storage, Cluster: cluster, Clients: clients})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 1 {
+		// Elected by its own vote.
+		err = sm.startElection()
+	} else {
+		err = sm.stable.SetCurrentTerm(1)
+		if err == nil {
+			err = sm.becomeLeader(1)
+		}
+	}
+	if err != nil {
+		sm.Close()
+		t.Fatal(err)
+	}
+	if sm.role != konsen.Role_LEADER {
+		sm.Close()
+		t.Fatalf("got role %v, want %v", sm.role, konsen.Role_LEADER)
+	}
+	return sm
+}
+
+// testReads records the reads served and failed by a state machine.
+type testReads struct {
+	served int
+	errCh  chan error
+}
+
+func newTestReads() *testReads {
+	return &testReads{errCh: make(chan error, 10)}
+}
+
+func (r *testReads) read(t *testing.T, sm *StateMachine, opts *konsen.ReadOptions) {
+	t.Helper()
+	if err := sm.handleRead(context.Background(), opts, r.errCh, func() error {
+		r.served++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (r *testReads) check(t *testing.T, served int) {
+	t.Helper()
+	select {
+	case err := <-r.errCh:
+		t.Fatalf("read failed: %v", err)
+	default:
+	}
+	if r.served != served {
+		t.Fatalf("got %d reads served, want %d", r.served, served)
+	}
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "konsen-core-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// A round of AppendEntries is confirmed once a majority (with the leader) has acknowledged it or a later round, and the
+// leadership is confirmed as of the time the round was sent.
+func TestConfirmRound(t *testing.T) {
+	storage := openTestStorage(t, tempDir(t))
+	defer storage.Close()
+	sm := newTestLeader(t, storage, 5)
+	defer sm.Close()
+	sm.startRound()
+	sm.startRound()
+	if sm.round != 3 || sm.confirmedRound != 0 {
+		t.Fatalf("got round %d confirmed up to %d, want round 3 confirmed up to 0", sm.round, sm.confirmedRound)
+	}
+
+	for _, step := range []struct {
+		server    string
+		round     uint64
+		confirmed uint64
+	}{
+		{"node2", 3, 0}, // The leader and node2 are not a majority of 5.
+		{"node3", 1, 1}, // Round 1 is acknowledged by node2 and node3.
+		{"node3", 1, 1},
+		{"node4", 2, 2},
+		{"node2", 2, 2}, // Acknowledgements of earlier rounds are ignored.
+		{"node5", 3, 3},
+	} {
+		sm.ackRound(step.server, step.round)
+		if sm.confirmedRound != step.confirmed {
+			t.Fatalf("got confirmed round %d after %s acknowledged round %d, want %d",
+				sm.confirmedRound, step.server, step.round, step.confirmed)
+		}
+		if sentAt := sm.roundTimes[step.confirmed%numRoundTimes].sentAt; step.confirmed > 0 && !sm.confirmedAt.Equal(sentAt) {
+			t.Fatalf("got leadership confirmed at %v, want %v when round %d was sent", sm.confirmedAt, sentAt, step.confirmed)
+		}
+	}
+
+	// A new term starts without any confirmation.
+	sm.resetRounds()
+	if sm.confirmedRound != sm.round || !sm.confirmedAt.IsZero() || len(sm.ackedRounds) != 0 {
+		t.Fatalf("got confirmed round %d at %v with %d acknowledgements after reset, want %d at zero time with none",
+			sm.confirmedRound, sm.confirmedAt, len(sm.ackedRounds), sm.round)
+	}
+}
+
+// A linearizable read waits for a round of AppendEntries sent after it arrived to be confirmed, and for its read index
+// to be applied. Reads that arrive while a round is in flight wait for the next one.
+func TestReadIndexRounds(t *testing.T) {
+	storage := openTestStorage(t, tempDir(t))
+	defer storage.Close()
+	sm := newTestLeader(t, storage, 3)
+	defer sm.Close()
+	// The no-op entry of the term is sent in round 1.
+	if sm.round != 1 || sm.termStartIndex != 1 || sm.commitIndex != 0 {
+		t.Fatalf("got round %d, term start index %d and commit index %d, want 1, 1 and 0", sm.round, sm.termStartIndex, sm.commitIndex)
+	}
+
+	reads := newTestReads()
+	reads.read(t, sm, Linearizable.Options())
+	if r := sm.pendingReads[0]; r.round != 2 || r.index != 1 {
+		t.Fatalf("got read in round %d with read index %d, want round 2 and the term start index 1", r.round, r.index)
+	}
+	// Round 1 is in flight, so the next one is not sent yet.
+	if err := sm.maybeServeReads(); err != nil {
+		t.Fatal(err)
+	}
+	if sm.round != 1 {
+		t.Fatalf("got round %d while round 1 is in flight, want 1", sm.round)
+	}
+
+	sm.ackRound("node2", 1)
+	if err := sm.maybeServeReads(); err != nil {
+		t.Fatal(err)
+	}
+	if sm.round != 2 {
+		t.Fatalf("got round %d after round 1 is confirmed, want 2", sm.round)
+	}
+	reads.check(t, 0)
+
+	// Arrives while round 2 is in flight.
+	reads.read(t, sm, Linearizable.Options())
+	sm.ackRound("node3", 2)
+	if err := sm.maybeServeReads(); err != nil {
+		t.Fatal(err)
+	}
+	// The first read is confirmed, but its read index is not applied yet.
+	reads.check(t, 0)
+
+	sm.commitIndex = 1
+	if err := sm.maybeApplyLogs(sm.applyLog); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.maybeServeReads(); err != nil {
+		t.Fatal(err)
+	}
+	reads.check(t, 1)
+	if sm.round != 3 || len(sm.pendingReads) != 1 {
+		t.Fatalf("got round %d with %d pending reads, want round 3 with 1", sm.round, len(sm.pendingReads))
+	}
+
+	// Pending reads fail when the leadership is lost.
+	sm.setRole(konsen.Role_FOLLOWER)
+	if err := <-reads.errCh; !errors.Is(err, ErrNotLeader) {
+		t.Fatalf("got error %v, want %v", err, ErrNotLeader)
+	}
+	reads.check(t, 1)
+}
+
+func TestBoundedStaleness(t *testing.T) {
+	storage := openTestStorage(t, tempDir(t))
+	defer storage.Close()
+	sm := newTestLeader(t, storage, 3)
+	defer sm.Close()
+	byTime := ReadConsistency{Level: konsen.Consistency_BOUNDED_STALENESS, MaxStaleness: time.Second}.Options()
+	byLag := ReadConsistency{Level: konsen.Consistency_BOUNDED_STALENESS, MaxIndexLag: 2}.Options()
+
+	// The leader measures staleness from the latest confirmed round.
+	if err := sm.checkStaleness(byTime); !errors.Is(err, ErrTooStale) {
+		t.Fatalf("got error %v before the leadership is confirmed, want %v", err, ErrTooStale)
+	}
+	sm.ackRound("node2", 1)
+	sm.commitIndex = 1
+	if err := sm.checkStaleness(byTime); !errors.Is(err, ErrTooStale) {
+		t.Fatalf("got error %v with a committed entry not applied, want %v", err, ErrTooStale)
+	}
+	if err := sm.maybeApplyLogs(sm.applyLog); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.checkStaleness(byTime); err != nil {
+		t.Fatalf("got error %v after the leadership is confirmed, want none", err)
+	}
+	sm.confirmedAt = time.Now().Add(-2 * time.Second)
+	if err := sm.checkStaleness(byTime); !errors.Is(err, ErrTooStale) {
+		t.Fatalf("got error %v with the leadership confirmed 2s ago, want %v", err, ErrTooStale)
+	}
+
+	// A follower measures it from the latest AppendEntries of the leader.
+	sm.setRole(konsen.Role_FOLLOWER)
+	sm.leaderContact, sm.leaderCommit = time.Time{}, 0
+	if err := sm.checkStaleness(byLag); !errors.Is(err, ErrTooStale) {
+		t.Fatalf("got error %v before hearing from a leader, want %v", err, ErrTooStale)
+	}
+	for _, test := range []struct {
+		age       time.Duration
+		commit    uint64
+		opts      *konsen.ReadOptions
+		wantStale bool
+	}{
+		{0, 1, byTime, false},
+		{2 * time.Second, 1, byTime, true},
+		{0, 2, byTime, true},
+		{0, 3, byLag, false},
+		{0, 4, byLag, true},
+		// Not heard from the leader within the election timeout.
+		{time.Minute, 3, byLag, true},
+	} {
+		sm.leaderContact, sm.leaderCommit = time.Now().Add(-test.age), test.commit
+		err := sm.checkStaleness(test.opts)
+		if stale := errors.Is(err, ErrTooStale); stale != test.wantStale || err != nil && !stale {
+			t.Fatalf("got error %v with leader commit %d heard %v ago and %d applied, want too stale: %v",
+				err, test.commit, test.age, sm.lastApplied, test.wantStale)
+		}
+	}
+}
+
+// A single server confirms its leadership and commits entries by itself.
+func TestSingleServerReads(t *testing.T) {
+	storage := openTestStorage(t, tempDir(t))
+	defer storage.Close()
+	sm := newTestLeader(t, storage, 1)
+	defer sm.Close()
+	if sm.commitIndex != sm.termStartIndex || sm.confirmedRound != sm.round {
+		t.Fatalf("got commit index %d of term start index %d and round %d confirmed up to %d, want both committed and confirmed",
+			sm.commitIndex, sm.termStartIndex, sm.round, sm.confirmedRound)
+	}
+
+	reads := newTestReads()
+	reads.read(t, sm, Linearizable.Options())
+	if err := sm.maybeServeReads(); err != nil {
+		t.Fatal(err)
+	}
+	// The read index is not applied yet.
+	reads.check(t, 0)
+	if err := sm.maybeApplyLogs(sm.applyLog); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.maybeServeReads(); err != nil {
+		t.Fatal(err)
+	}
+	reads.check(t, 1)
+
+	// Served right away: a new round is confirmed as soon as it is sent.
+	reads.read(t, sm, Linearizable.Options())
+	if err := sm.maybeServeReads(); err != nil {
+		t.Fatal(err)
+	}
+	reads.check(t, 2)
+
+	reads.read(t, sm, ReadConsistency{Level: konsen.Consistency_BOUNDED_STALENESS, MaxStaleness: time.Second}.Options())
+	reads.check(t, 3)
+}
@@ -2,15 +2,20 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"sync"
 	"time"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/lizhaoliu/konsen/v2/metrics"
 	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
 	"github.com/lizhaoliu/konsen/v2/store"
+	"github.com/lizhaoliu/konsen/v2/tracing"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -22,22 +27,36 @@ const (
 )
 
 // StateMachine is the state machine that implements Raft algorithm: https://raft.github.io/raft.pdf.
-// The state machine maintains a message queue (mailbox) internally, all requests/responses to the state machine are
-// processed asynchronously (although the caller still observes a synchronized behavior): they are firstly put onto the
+// The state machine maintains message queues (mailboxes) internally, all requests/responses to the state machine are
+// processed asynchronously (although the caller still observes a synchronized behavior): they are firstly put onto a
 // message queue, then the message worker (goroutine) in turns takes a message at a time and processes it, and passes
-// the result back to caller. The internal state is never directly accessed by goroutines other than the message worker.
+// the result back to caller. Raft protocol messages have their own queue, which is always served before the queue of
+// client requests, so that heartbeats and votes are handled in time (and leadership stays stable) under client load.
+// The internal state is never directly accessed by goroutines other than the message worker.
 // Internal errors will always cause a crash on the server since otherwise the state machine may be left in an
 // inconsistent state.
 type StateMachine struct {
-	msgCh        chan interface{} // Main message queue.
+	raftCh       chan interface{} // Queue of Raft protocol messages: RPCs from peers, their responses and timer events.
+	msgCh        chan interface{} // Queue of client requests and everything else.
 	stopCh       chan struct{}    // Signals to stop the state machine.
 	timerGateCh  chan struct{}    // Signals to run next round of election timeout countdown.
 	resetTimerCh chan struct{}    // Signals to reset election timer when AppendEntries or RequestVote requests/response are received.
+	corruptedCh  chan struct{}    // Closed when log corruption is detected, after which the state machine stops serving.
+
+	// Log corruption detected on this server, it is set before corruptedCh is closed.
+	corruption error
+
+	logger     *log.Entry  // Logger tagged with the local server name, see log() for the one tagged with term and role.
+	logSampler *logSampler // Limits logging on hot paths.
 
 	// Persistent state storage on all servers.
-	storage store.Storage
+	logs     store.LogStore    // Raft logs.
+	logCache *store.LogCache   // In-memory cache in front of the log storage, nil if disabled.
+	stable   store.StableStore // Current term and voted for.
+	kv       store.KVStore     // Key-value store that committed logs are applied to.
 
 	// Volatile state on all servers.
+	term          uint64      // Current term, a copy of the one in stable store for logging.
 	commitIndex   uint64      // Index of highest log entry known to be committed (initialized to 0).
 	lastApplied   uint64      // Index of highest log entry applied to state machine (initialized to 0).
 	role          konsen.Role // Current role.
@@ -47,8 +66,44 @@ type StateMachine struct {
 	numVotes int
 
 	// Volatile state on leaders (must be reinitialized after election).
-	nextIndex  map[string]uint64 // For each server, index of the next log entry to send to that server (initialized to leader last log index + 1).
-	matchIndex map[string]uint64 // For each server, index of highest log entry known to be replicated on that server (initialized to 0, increases monotonically).
+	nextIndex  map[string]uint64   // For each server, index of the next log entry to send to that server (initialized to leader last log index + 1).
+	matchIndex map[string]uint64   // For each server, index of highest log entry known to be replicated on that server (initialized to 0, increases monotonically).
+	transfer   *leadershipTransfer // Ongoing leadership transfer, nil if none.
+
+	// Leadership confirmation of reads (see read.go).
+	termStartIndex uint64                   // Index of the no-op log written when this server became the leader.
+	round          uint64                   // Number of rounds of AppendEntries sent as leader.
+	roundTimes     [numRoundTimes]roundTime // Send times of recent rounds, by round modulo numRoundTimes.
+	ackedRounds    map[string]uint64        // For each follower, latest round it has acknowledged in current term.
+	confirmedRound uint64                   // Latest round acknowledged by a quorum.
+	confirmedAt    time.Time                // Send time of confirmedRound, the leadership is confirmed as of then.
+	pendingReads   []*pendingRead           // Linearizable reads waiting for confirmation.
+
+	// Freshness of the local state on followers, for bounded staleness reads.
+	leaderContact time.Time // Time of the latest AppendEntries from current leader.
+	leaderCommit  uint64    // Commit index of the leader in that AppendEntries.
+
+	indexWaits []*indexWait // Reads waiting for logs up to their min index to be applied.
+
+	// Raft timings.
+	heartbeatInterval  time.Duration
+	electionTimeoutMin time.Duration
+	electionTimeoutMax time.Duration
+
+	// Cross-replica consistency verification.
+	consistencyCheckInterval time.Duration                  // Interval between consistency checks proposed by leader.
+	stateHashes              map[uint64][]byte              // Recent local state hashes, by index of consistency check logs.
+	stateHashIndex           uint64                         // Index of the latest consistency check log hashed locally.
+	hashingState             bool                           // Whether the state at a consistency check log is being hashed.
+	replicaConsistency       map[string]*ReplicaConsistency // Latest consistency check result of each follower (on leaders).
+	consistencyMismatches    uint64                         // Number of state mismatches detected.
+
+	auth      authStore        // Applied user/role database.
+	proposals *proposalLimiter // Admission control of proposals.
+	metrics   *metrics.Metrics
+
+	onLeadershipChange func(leader bool) // Called when this server becomes or stops being the leader, may be nil.
+	stopHeartbeat      func()            // Stops the heartbeat loop of the current leadership, nil if not the leader.
 
 	// ClusterConfig info.
 	cluster *ClusterConfig
@@ -57,33 +112,35 @@ type StateMachine struct {
 	wg   sync.WaitGroup
 	once sync.Once
 
-	condMap sync.Map
+	condMap sync.Map // Waiters of logs being applied, a map of "log index": *applyWaiter, changed by the message loop only.
 }
 
 // StateMachineConfig
 type StateMachineConfig struct {
-	Storage store.Storage          // Local storage instance.
-	Cluster *ClusterConfig         // Cluster configuration.
-	Clients map[string]RaftService // A map of "server name": "Raft service".
-}
-
-// Snapshot is a snapshot of the internal state of a state machine.
-// TODO: Reduce the fields or remove this, as this is for debug purpose.
-type Snapshot struct {
-	CurrentTerm   uint64            // Current term.
-	CommitIndex   uint64            // Index of highest log entry known to be committed.
-	LastApplied   uint64            // Index of highest log entry applied to state machine.
-	Role          konsen.Role       // Current role.
-	CurrentLeader string            // Current leader.
-	NextIndex     map[string]uint64 // For each server, index of the next log entry to send to that server (initialized to leader last log index + 1).
-	MatchIndex    map[string]uint64 // For each server, index of highest log entry known to be replicated on that server (initialized to 0, increases monotonically).
-	LogIndices    []uint64          // Logs indices.
-	LogTerms      []uint64          // Log terms.
-	LogBytes      []int             // Log binary sizes.
+	Storage     store.Storage          // Local storage instance, used for any of the following stores that is unset.
+	LogStore    store.LogStore         // Local Raft log storage.
+	StableStore store.StableStore      // Local storage of current term and voted for.
+	KVStore     store.KVStore          // Local key-value storage that committed logs are applied to.
+	Cluster     *ClusterConfig         // Cluster configuration.
+	Clients     map[string]RaftService // A map of "server name": "Raft service".
+
+	LogCacheSize             int              // Number of most recent log entries cached in memory, 0 disables the cache.
+	ConsistencyCheckInterval time.Duration    // Interval between cross-replica consistency checks, 0 disables the checks.
+	Logger                   *log.Entry       // Logger of the state machine, the standard logger if unset.
+	MaxPendingProposals      int              // Maximum number of pending proposals, more are rejected, 0 means unlimited.
+	MaxPendingProposalBytes  int64            // Maximum total data size of pending proposals, 0 means unlimited.
+	HeartbeatInterval        time.Duration    // Interval between heartbeats sent by leader, defaults to 100ms.
+	ElectionTimeoutMin       time.Duration    // Minimum election timeout, defaults to 1s.
+	ElectionTimeoutMax       time.Duration    // Maximum election timeout (exclusive), defaults to 2s.
+	Metrics                  *metrics.Metrics // Metrics of the server, unregistered ones are used if unset.
+	// Called from the message loop when this server becomes (true) or stops being (false) the leader, it must not
+	// block.
+	OnLeadershipChange func(leader bool)
 }
 
 // appendEntriesWrap
 type appendEntriesWrap struct {
+	ctx context.Context
 	req *konsen.AppendEntriesReq
 	ch  chan<- *konsen.AppendEntriesResp
 }
@@ -93,6 +150,7 @@ type appendEntriesRespWrap struct {
 	resp   *konsen.AppendEntriesResp // AppendEntries response from remote server.
 	req    *konsen.AppendEntriesReq  // Original AppendEntries request sent to remote server.
 	server string                    // Remote server name that sends the AppendEntries response.
+	round  uint64                    // Round of AppendEntries the request is sent in.
 }
 
 // requestVoteWrap
@@ -109,8 +167,9 @@ type appendEntriesMsg struct{}
 
 // appendDataMsg represents a message to append given data into state machine.
 type appendDataMsg struct {
-	req *konsen.AppendDataReq
-	ch  chan<- *konsen.AppendDataResp
+	ctx   context.Context
+	req   *konsen.AppendDataReq
+	reply func(resp *konsen.AppendDataResp) // Answers the proposal, it must be called exactly once.
 }
 
 // getSnapshotMsg represents a message to generate a state snapshot.
@@ -118,10 +177,38 @@ type getSnapshotMsg struct {
 	ch chan<- *Snapshot
 }
 
-// getValueMsg represents a message to retrieve a value by given key.
-type getValueMsg struct {
-	key []byte
-	ch  chan<- []byte
+// applyWaiter is a waiter of a log being applied. The log a leader appended may be overwritten by the log of a later
+// leader at the same index, which is told by the term of the log applied at the index.
+type applyWaiter struct {
+	ctx          context.Context // Context of the request that proposed the log, for tracing its replication and apply.
+	term         uint64          // Term of the proposed log.
+	done         chan struct{}   // Closed after a log is applied at the index of the proposed log, or it is overwritten.
+	appliedTerm  uint64          // Term of the log applied at the index, 0 if overwritten before; set before done is closed.
+	txnSucceeded bool            // For TXN logs, true if all comparisons held, set before done is closed.
+}
+
+// loadApplyWaiter returns the waiter of given log, nil if none.
+func (sm *StateMachine) loadApplyWaiter(entry *konsen.Log) *applyWaiter {
+	if w, ok := sm.condMap.Load(entry.GetIndex()); ok && w.(*applyWaiter).term == entry.GetTerm() {
+		return w.(*applyWaiter)
+	}
+	return nil
+}
+
+// getMsg represents a message to retrieve a value by given key.
+type getMsg struct {
+	ctx   context.Context
+	req   *konsen.GetReq
+	ch    chan<- *konsen.GetResp
+	errCh chan<- error
+}
+
+// rangeMsg represents a message to retrieve key-value pairs in a key range.
+type rangeMsg struct {
+	ctx   context.Context
+	req   *konsen.RangeReq
+	ch    chan<- *konsen.RangeResp
+	errCh chan<- error
 }
 
 // NewStateMachine creates a new instance of the state machine.
@@ -130,22 +217,119 @@ func NewStateMachine(config StateMachineConfig) (*StateMachine, error) {
 		return nil, fmt.Errorf("number of nodes in the cluster must be an odd number, got: %d", len(config.Cluster.Servers))
 	}
 
+	logs, stable, kv := config.LogStore, config.StableStore, config.KVStore
+	if config.Storage != nil {
+		if logs == nil {
+			logs = config.Storage
+		}
+		if stable == nil {
+			stable = config.Storage
+		}
+		if kv == nil {
+			kv = config.Storage
+		}
+	}
+	if logs == nil || stable == nil || kv == nil {
+		return nil, fmt.Errorf("log store, stable store and key-value store must all be specified")
+	}
+
+	m := config.Metrics
+	if m == nil {
+		m = metrics.NewMetrics(metrics.MetricsConfig{})
+	}
+
+	// Verify checksums of all log entries read from storage, the cache only holds entries that are already verified.
+	logs = store.NewChecksumLogStore(store.NewMetricsLogStore(logs, m))
+
+	var logCache *store.LogCache
+	if config.LogCacheSize > 0 {
+		var err error
+		logCache, err = store.NewLogCache(logs, config.LogCacheSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create log cache: %w", err)
+		}
+		logs = logCache
+	}
+
+	term, err := stable.GetCurrentTerm()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current term: %w", err)
+	}
+
+	// Logs up to the applied index are in the key-value store already, and they are known to be committed.
+	lastApplied, err := kv.AppliedIndex()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get applied index: %w", err)
+	}
+
+	authState, err := loadAuthState(kv)
+	if err != nil {
+		return nil, err
+	}
+	// Anyone that can reach the Raft service could write without credentials.
+	if authState.GetEnabled() && !config.Cluster.PeersAuthenticated() {
+		return nil, errors.New("authentication is enabled, but servers do not authenticate each other: set peerSecretFile " +
+			"or mutual TLS in the cluster config")
+	}
+
+	logger := config.Logger
+	if logger == nil {
+		logger = log.NewEntry(log.StandardLogger())
+	}
+
+	if config.HeartbeatInterval == 0 {
+		config.HeartbeatInterval = defaultHeartbeat
+	}
+	if config.ElectionTimeoutMin == 0 {
+		config.ElectionTimeoutMin = defaultMinTimeout
+	}
+	if config.ElectionTimeoutMax == 0 {
+		config.ElectionTimeoutMax = config.ElectionTimeoutMin + defaultTimeoutSpan
+	}
+	if config.ElectionTimeoutMax <= config.ElectionTimeoutMin {
+		return nil, fmt.Errorf("maximum election timeout %v must be greater than minimum %v", config.ElectionTimeoutMax, config.ElectionTimeoutMin)
+	}
+
 	sm := &StateMachine{
+		raftCh:       make(chan interface{}),
 		msgCh:        make(chan interface{}),
 		stopCh:       make(chan struct{}),
 		timerGateCh:  make(chan struct{}, 1),
 		resetTimerCh: make(chan struct{}),
+		corruptedCh:  make(chan struct{}),
+
+		logger:     logger.WithField("server", config.Cluster.LocalServerName),
+		logSampler: newLogSampler(defaultLogSampleInterval),
 
-		storage: config.Storage,
-		cluster: config.Cluster,
-		clients: config.Clients,
+		logs:     logs,
+		logCache: logCache,
+		stable:   stable,
+		kv:       kv,
+		cluster:  config.Cluster,
+		clients:  config.Clients,
 
-		commitIndex: 0,
-		lastApplied: 0,
+		term:        term,
+		commitIndex: lastApplied,
+		lastApplied: lastApplied,
 		role:        konsen.Role_FOLLOWER,
 
-		nextIndex:  make(map[string]uint64),
-		matchIndex: make(map[string]uint64),
+		nextIndex:   make(map[string]uint64),
+		matchIndex:  make(map[string]uint64),
+		ackedRounds: make(map[string]uint64),
+
+		heartbeatInterval:  config.HeartbeatInterval,
+		electionTimeoutMin: config.ElectionTimeoutMin,
+		electionTimeoutMax: config.ElectionTimeoutMax,
+
+		consistencyCheckInterval: config.ConsistencyCheckInterval,
+		stateHashes:              make(map[uint64][]byte),
+		replicaConsistency:       make(map[string]*ReplicaConsistency),
+
+		auth:      authStore{state: authState},
+		proposals: &proposalLimiter{maxProposals: config.MaxPendingProposals, maxBytes: config.MaxPendingProposalBytes, metrics: m},
+		metrics:   m,
+
+		onLeadershipChange: config.OnLeadershipChange,
 	}
 
 	return sm, nil
@@ -156,17 +340,29 @@ func (sm *StateMachine) Run(ctx context.Context) {
 	sm.once.Do(func() {
 		sm.startMessageLoop(ctx)
 		sm.startElectionLoop(ctx)
+		sm.startConsistencyCheckLoop(ctx)
 		sm.wg.Wait()
 	})
 }
 
 // AppendEntries puts the incoming AppendEntries request in main message channel and waits for result.
+// Entries that do not match their checksums are rejected before reaching the message queue.
 func (sm *StateMachine) AppendEntries(ctx context.Context, req *konsen.AppendEntriesReq) (*konsen.AppendEntriesResp, error) {
-	ch := make(chan *konsen.AppendEntriesResp)
-	sm.msgCh <- appendEntriesWrap{req: req, ch: ch}
+	for _, entry := range req.GetEntries() {
+		if err := store.VerifyLogChecksum(entry); err != nil {
+			sm.logger.Errorf("Rejected AppendEntries from %q: %v", req.GetLeaderId(), err)
+			return nil, err
+		}
+	}
+	ch := make(chan *konsen.AppendEntriesResp, 1)
+	if err := sm.enqueueRaft(ctx, appendEntriesWrap{ctx: ctx, req: req, ch: ch}); err != nil {
+		return nil, err
+	}
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
+	case <-sm.corruptedCh:
+		return nil, sm.corruption
 	case resp := <-ch:
 		return resp, nil
 	}
@@ -174,46 +370,138 @@ func (sm *StateMachine) AppendEntries(ctx context.Context, req *konsen.AppendEnt
 
 // RequestVote puts the incoming RequestVote request in main message channel and waits for result.
 func (sm *StateMachine) RequestVote(ctx context.Context, req *konsen.RequestVoteReq) (*konsen.RequestVoteResp, error) {
-	ch := make(chan *konsen.RequestVoteResp)
-	sm.msgCh <- requestVoteWrap{req: req, ch: ch}
+	ch := make(chan *konsen.RequestVoteResp, 1)
+	if err := sm.enqueueRaft(ctx, requestVoteWrap{req: req, ch: ch}); err != nil {
+		return nil, err
+	}
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
+	case <-sm.corruptedCh:
+		return nil, sm.corruption
 	case resp := <-ch:
 		return resp, nil
 	}
 }
 
+// enqueue puts a client message onto the message queue, it gives up if the context is done or the state machine is
+// stopped. Reply channels in messages must be buffered, so that the message loop never blocks on replying to a caller
+// that has given up waiting.
+func (sm *StateMachine) enqueue(ctx context.Context, msg interface{}) error {
+	return sm.enqueueTo(ctx, sm.msgCh, "client", msg)
+}
+
+// enqueueRaft puts a Raft protocol message onto the Raft message queue, like enqueue.
+func (sm *StateMachine) enqueueRaft(ctx context.Context, msg interface{}) error {
+	return sm.enqueueTo(ctx, sm.raftCh, "raft", msg)
+}
+
+func (sm *StateMachine) enqueueTo(ctx context.Context, ch chan<- interface{}, queue string, msg interface{}) error {
+	_, span := tracing.StartSpan(ctx, "raft.enqueue")
+	defer span.End()
+	start := time.Now()
+	select {
+	case ch <- msg:
+		// The message channels are unbuffered, so the send completes when the message loop takes the message.
+		sm.metrics.QueueWaitSeconds.WithLabelValues(queue).Observe(time.Since(start).Seconds())
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-sm.stopCh:
+		return &requestError{kind: ErrNotLeader, msg: "server has been shut down"}
+	}
+}
+
+// log returns the logger tagged with current term and role, it must only be called by the message loop.
+func (sm *StateMachine) log() *log.Entry {
+	return sm.logger.WithFields(log.Fields{"term": sm.term, "role": sm.role.String()})
+}
+
+// isCorrupted returns true if log corruption has been detected on this server.
+func (sm *StateMachine) isCorrupted() bool {
+	select {
+	case <-sm.corruptedCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// handleError handles an internal error of the message loop. Log corruption puts the state machine in corrupted
+// state: it steps down and stops serving anything but snapshots, rather than risking to apply or replicate bad
+// entries. Any other error crashes the server.
+func (sm *StateMachine) handleError(err error) {
+	if !errors.Is(err, store.ErrCorruptedLog) {
+		sm.log().Fatalf("%v", err)
+	}
+	if sm.isCorrupted() {
+		return
+	}
+	sm.log().Errorf("Log corruption detected, stop serving requests: %v", err)
+	sm.setRole(konsen.Role_FOLLOWER)
+	sm.currentLeader = ""
+	sm.corruption = err
+	close(sm.corruptedCh)
+}
+
 // grantVote creates a positive vote response for the candidate for given term.
 func (sm *StateMachine) grantVote(term uint64, candidateID string) (*konsen.RequestVoteResp, error) {
-	if err := sm.storage.SetVotedFor(candidateID); err != nil {
+	if err := sm.stable.SetVotedFor(candidateID); err != nil {
 		return nil, err
 	}
-	log.Debug("Granted vote for candidate %q for term %d", candidateID, term)
+	sm.log().Debugf("Granted vote for candidate %q for term %d", candidateID, term)
 	return &konsen.RequestVoteResp{Term: term, VoteGranted: true}, nil
 }
 
 // maybeBecomeFollower checks if the given term is greater than current term, if true then update current term to
 // the given term and become a follower, otherwise it simply returns the current term.
 func (sm *StateMachine) maybeBecomeFollower(term uint64) (uint64, error) {
-	currentTerm, err := sm.storage.GetCurrentTerm()
+	currentTerm, err := sm.stable.GetCurrentTerm()
 	if err != nil {
-		return 0, fmt.Errorf("failed to get current term: %v", err)
+		return 0, fmt.Errorf("failed to get current term: %w", err)
 	}
 	// If given term is greater than current term, update current term and become a follower.
 	if term > currentTerm {
-		if err := sm.storage.SetCurrentTerm(term); err != nil {
-			return 0, fmt.Errorf("failed to set current term to %d: %v", term, err)
+		if err := sm.stable.SetCurrentTerm(term); err != nil {
+			return 0, fmt.Errorf("failed to set current term to %d: %w", term, err)
 		}
 		currentTerm = term
-		sm.role = konsen.Role_FOLLOWER
-		if err := sm.storage.SetVotedFor(""); err != nil {
-			return currentTerm, fmt.Errorf("failed to reset voted for: %v", err)
+		sm.term = term
+		sm.setRole(konsen.Role_FOLLOWER)
+		// Leader of the new term is unknown until its first AppendEntries.
+		sm.currentLeader = ""
+		if err := sm.stable.SetVotedFor(""); err != nil {
+			return currentTerm, fmt.Errorf("failed to reset voted for: %w", err)
 		}
 	}
 	return currentTerm, nil
 }
 
+// setRole sets the role of this server, and reports leadership changes.
+func (sm *StateMachine) setRole(role konsen.Role) {
+	wasLeader := sm.role == konsen.Role_LEADER
+	sm.role = role
+	isLeader := role == konsen.Role_LEADER
+	if wasLeader && !isLeader {
+		sm.failPendingReads()
+		if sm.stopHeartbeat != nil {
+			sm.stopHeartbeat()
+			sm.stopHeartbeat = nil
+		}
+	}
+	if isLeader != wasLeader && sm.onLeadershipChange != nil {
+		sm.onLeadershipChange(isLeader)
+	}
+}
+
+// setLeader sets the current leader, a leader change is counted when it is set to a different server.
+func (sm *StateMachine) setLeader(leader string) {
+	if leader != sm.currentLeader {
+		sm.metrics.LeaderChanges.Inc()
+	}
+	sm.currentLeader = leader
+}
+
 // resetElectionTimer signals the election timer to start a new round of election timeout countdown.
 func (sm *StateMachine) resetElectionTimer() {
 	sm.resetTimerCh <- struct{}{}
@@ -225,7 +513,11 @@ func (sm *StateMachine) openElectionTimerGate() {
 }
 
 // handleAppendEntries handles a AppendEntries request.
-func (sm *StateMachine) handleAppendEntries(req *konsen.AppendEntriesReq) (*konsen.AppendEntriesResp, error) {
+func (sm *StateMachine) handleAppendEntries(ctx context.Context, req *konsen.AppendEntriesReq) (*konsen.AppendEntriesResp, error) {
+	ctx, span := tracing.StartSpan(ctx, "raft.handleAppendEntries",
+		trace.WithAttributes(attribute.Int("raft.entries", len(req.GetEntries()))))
+	defer span.End()
+
 	sm.resetElectionTimer()
 	defer sm.openElectionTimerGate()
 
@@ -240,16 +532,22 @@ func (sm *StateMachine) handleAppendEntries(req *konsen.AppendEntriesReq) (*kons
 		return &konsen.AppendEntriesResp{Term: currentTerm, Success: false}, nil
 	}
 
-	// At this point, the request is coming from a legit leader, and request term == currentTerm.
-	sm.currentLeader = req.GetLeaderId()
+	// At this point, the request is coming from a legit leader, and request term == currentTerm. A candidate that
+	// lost the election to it converts to follower.
+	sm.setRole(konsen.Role_FOLLOWER)
+	sm.setLeader(req.GetLeaderId())
+	sm.leaderContact = time.Now()
+	sm.leaderCommit = req.GetLeaderCommit()
 
 	// 2. Reply false if log doesn’t contain an entry at prevLogIndex whose term matches prevLogTerm.
-	prevLogTerm, err := sm.storage.GetLogTerm(req.GetPrevLogIndex())
+	prevLogTerm, err := sm.logs.GetLogTerm(req.GetPrevLogIndex())
 	if err != nil {
 		return nil, fmt.Errorf("failed to get log at index %d: %v", req.GetPrevLogIndex(), err)
 	}
 	if prevLogTerm != req.GetPrevLogTerm() {
-		log.Debug("Local prevLogTerm(%d) mismatches request prevLogTerm(%d).", prevLogTerm, req.GetPrevLogTerm())
+		if ok, suppressed := sm.logSampler.sample("prev_log_term_mismatch"); ok {
+			sm.log().WithField("suppressed", suppressed).Debugf("Local prevLogTerm(%d) mismatches request prevLogTerm(%d).", prevLogTerm, req.GetPrevLogTerm())
+		}
 		return &konsen.AppendEntriesResp{Term: currentTerm, Success: false}, nil
 	}
 
@@ -257,9 +555,9 @@ func (sm *StateMachine) handleAppendEntries(req *konsen.AppendEntriesReq) (*kons
 	// If there are new logs to append.
 	if len(entries) > 0 {
 		// 3. If an existing entry conflicts with a new one (same index but different terms), delete the existing entry and all that follow it.
-		startIdx := 0
+		startIdx := len(entries)
 		for i, newLog := range entries {
-			localLog, err := sm.storage.GetLog(newLog.GetIndex())
+			localLog, err := sm.logs.GetLog(newLog.GetIndex())
 			if err != nil {
 				return nil, fmt.Errorf("failed to get log at index %d: %v", newLog.GetIndex(), err)
 			}
@@ -268,8 +566,8 @@ func (sm *StateMachine) handleAppendEntries(req *konsen.AppendEntriesReq) (*kons
 				break
 			}
 			if localLog.GetTerm() != newLog.GetTerm() {
-				log.Debug("Local logs conflict from index %d, now delete onwards.", newLog.GetIndex())
-				if err := sm.storage.DeleteLogsFrom(newLog.GetIndex()); err != nil {
+				sm.log().Debugf("Local logs conflict from index %d, now delete onwards.", newLog.GetIndex())
+				if err := sm.logs.DeleteLogsFrom(newLog.GetIndex()); err != nil {
 					return nil, fmt.Errorf("failed to delete logs from min index %d: %v", newLog.GetIndex(), err)
 				}
 				startIdx = i
@@ -278,17 +576,22 @@ func (sm *StateMachine) handleAppendEntries(req *konsen.AppendEntriesReq) (*kons
 		}
 
 		// 4. Append any new entries not already in the log.
-		log.Debug("Append logs from index %d.", entries[startIdx].GetIndex())
-		if err := sm.storage.WriteLogs(entries[startIdx:]); err != nil {
-			return nil, fmt.Errorf("failed to write logs: %v", err)
+		if startIdx < len(entries) {
+			sm.log().Debugf("Append logs from index %d.", entries[startIdx].GetIndex())
+			_, writeSpan := tracing.StartSpan(ctx, "storage.WriteLogs")
+			err := sm.logs.WriteLogs(entries[startIdx:])
+			writeSpan.End()
+			if err != nil {
+				return nil, fmt.Errorf("failed to write logs: %w", err)
+			}
 		}
 	}
 
 	// 5. If leaderCommit > commitIndex, set commitIndex = min(leaderCommit, index of last new entry).
 	if req.GetLeaderCommit() > sm.commitIndex {
-		lastLogIndex, err := sm.storage.LastLogIndex()
+		lastLogIndex, err := sm.logs.LastLogIndex()
 		if err != nil {
-			return nil, fmt.Errorf("failed to get index of the last log: %v", err)
+			return nil, fmt.Errorf("failed to get index of the last log: %w", err)
 		}
 		sm.commitIndex = req.GetLeaderCommit()
 		if lastLogIndex < sm.commitIndex {
@@ -296,35 +599,50 @@ func (sm *StateMachine) handleAppendEntries(req *konsen.AppendEntriesReq) (*kons
 		}
 	}
 
-	// Reply with success.
-	return &konsen.AppendEntriesResp{Term: currentTerm, Success: true}, nil
+	// Reply with success, along with the latest local state hash for the leader to verify.
+	return &konsen.AppendEntriesResp{
+		Term:           currentTerm,
+		Success:        true,
+		StateHashIndex: sm.stateHashIndex,
+		StateHash:      sm.stateHashes[sm.stateHashIndex],
+	}, nil
 }
 
 // handleAppendEntriesResp handles a AppendEntries response.
 func (sm *StateMachine) handleAppendEntriesResp(
 	resp *konsen.AppendEntriesResp,
 	req *konsen.AppendEntriesReq,
-	server string) error {
+	server string,
+	round uint64) error {
 	sm.resetElectionTimer()
 	defer sm.openElectionTimerGate()
 
 	// If RPC request or response contains term T > currentTerm: set currentTerm = T, convert to follower.
 	currentTerm, err := sm.maybeBecomeFollower(resp.GetTerm())
 	if err != nil {
-		return fmt.Errorf("%v", err)
+		return fmt.Errorf("%w", err)
 	}
 
 	// Terminate if no longer a leader.
 	if sm.role != konsen.Role_LEADER {
 		return nil
 	}
+	// Any response in current term acknowledges that this server is still the leader.
+	if resp.GetTerm() == currentTerm {
+		sm.ackRound(server, round)
+	}
 
 	if resp.GetSuccess() {
+		sm.checkReplicaStateHash(server, resp)
+
 		numEntries := len(req.GetEntries())
 
-		// Response is from a pure heartbeat, can return now.
+		// Response is from a pure heartbeat: follower's log matches up to prevLogIndex.
 		if numEntries == 0 {
-			return nil
+			if req.GetPrevLogIndex() > sm.matchIndex[server] {
+				sm.matchIndex[server] = req.GetPrevLogIndex()
+			}
+			return sm.maybeSendTimeoutNow()
 		}
 
 		// Successful: update nextIndex and matchIndex for follower.
@@ -335,15 +653,16 @@ func (sm *StateMachine) handleAppendEntriesResp(
 		// Only need to find the highest index, as lower index logs will always match if a higher one matches.
 		for i := numEntries - 1; i >= 0; i-- {
 			logIndex := req.GetEntries()[i].GetIndex()
-			logTerm, err := sm.storage.GetLogTerm(logIndex)
+			logTerm, err := sm.logs.GetLogTerm(logIndex)
 			if err != nil {
-				return fmt.Errorf("failed to get log term at index %d: %v", logIndex, err)
+				return fmt.Errorf("failed to get log term at index %d: %w", logIndex, err)
 			}
 			if logIndex > sm.commitIndex && sm.isLogOnMajority(logIndex) && logTerm == currentTerm {
 				sm.commitIndex = logIndex
 				break
 			}
 		}
+		return sm.maybeSendTimeoutNow()
 	} else {
 		// AppendEntries fails because of log inconsistency: decrement nextIndex and retry.
 		sm.nextIndex[server]--
@@ -371,7 +690,7 @@ func (sm *StateMachine) handleRequestVote(req *konsen.RequestVoteReq) (*konsen.R
 	// If RPC request or response contains term T > currentTerm: set currentTerm = T, convert to follower.
 	currentTerm, err := sm.maybeBecomeFollower(req.GetTerm())
 	if err != nil {
-		return nil, fmt.Errorf("%v", err)
+		return nil, fmt.Errorf("%w", err)
 	}
 
 	// 1. Reply false if term < currentTerm.
@@ -382,9 +701,9 @@ func (sm *StateMachine) handleRequestVote(req *konsen.RequestVoteReq) (*konsen.R
 	// Now candidate's term == currentTerm.
 
 	// 2. If votedFor is null or candidateId, and candidate’s log is at least as up-to-date as receiver’s log, grant vote.
-	votedFor, err := sm.storage.GetVotedFor()
+	votedFor, err := sm.stable.GetVotedFor()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get votedFor: %v", err)
+		return nil, fmt.Errorf("failed to get votedFor: %w", err)
 	}
 
 	// If already voted for another candidate, deny the vote.
@@ -395,9 +714,9 @@ func (sm *StateMachine) handleRequestVote(req *konsen.RequestVoteReq) (*konsen.R
 	// If candidate’s log is at least as up-to-date as receiver’s log, grant vote.
 
 	// If the logs have last entries with different terms, then the log with the later term is more up-to-date.
-	lastLogTerm, err := sm.storage.LastLogTerm()
+	lastLogTerm, err := sm.logs.LastLogTerm()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get last log's term: %v", err)
+		return nil, fmt.Errorf("failed to get last log's term: %w", err)
 	}
 	// Candidate's last log term is older, deny the vote.
 	if req.GetLastLogTerm() < lastLogTerm {
@@ -410,9 +729,9 @@ func (sm *StateMachine) handleRequestVote(req *konsen.RequestVoteReq) (*konsen.R
 	}
 
 	// If last logs have the same term, then whichever log is longer is more up-to-date.
-	lastLogIndex, err := sm.storage.LastLogIndex()
+	lastLogIndex, err := sm.logs.LastLogIndex()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get last log's index: %v", err)
+		return nil, fmt.Errorf("failed to get last log's index: %w", err)
 	}
 	if req.GetLastLogIndex() >= lastLogIndex {
 		return sm.grantVote(currentTerm, req.GetCandidateId())
@@ -430,7 +749,7 @@ func (sm *StateMachine) handleRequestVoteResp(resp *konsen.RequestVoteResp) erro
 	// If RPC request or response contains term T > currentTerm: set currentTerm = T, convert to follower.
 	currentTerm, err := sm.maybeBecomeFollower(resp.GetTerm())
 	if err != nil {
-		return fmt.Errorf("%v", err)
+		return fmt.Errorf("%w", err)
 	}
 
 	if sm.role != konsen.Role_CANDIDATE {
@@ -442,7 +761,7 @@ func (sm *StateMachine) handleRequestVoteResp(resp *konsen.RequestVoteResp) erro
 		// If votes received from majority of servers, become leader.
 		if sm.numVotes > sm.getQuorum() {
 			if err := sm.becomeLeader(currentTerm); err != nil {
-				return fmt.Errorf("failed to become leader: %v", err)
+				return fmt.Errorf("failed to become leader: %w", err)
 			}
 		}
 	}
@@ -452,40 +771,55 @@ func (sm *StateMachine) handleRequestVoteResp(resp *konsen.RequestVoteResp) erro
 
 // becomeLeader modifies internal state to become a leader, and starts the worker that periodically sends heartbeat.
 func (sm *StateMachine) becomeLeader(term uint64) error {
-	log.Infof("Term - %d, leader - %q.", term, sm.cluster.LocalServerName)
-	sm.role = konsen.Role_LEADER
-	sm.currentLeader = sm.cluster.LocalServerName
-	lastLogIndex, err := sm.storage.LastLogIndex()
+	sm.setRole(konsen.Role_LEADER)
+	sm.setLeader(sm.cluster.LocalServerName)
+	sm.log().Infof("Term - %d, leader - %q.", term, sm.cluster.LocalServerName)
+	lastLogIndex, err := sm.logs.LastLogIndex()
 	if err != nil {
 		return err
 	}
 
-	// Resets nextIndex and matchIndex after election.
+	// Resets nextIndex, matchIndex and consistency check results after election.
+	sm.replicaConsistency = make(map[string]*ReplicaConsistency)
 	for server := range sm.cluster.Servers {
 		if server != sm.cluster.LocalServerName {
 			sm.nextIndex[server] = lastLogIndex + 1
 			sm.matchIndex[server] = 0
 		}
 	}
+	sm.resetRounds()
 
-	sm.startHeartbeatLoop(context.Background())
+	// Entries of previous terms can only be committed along with one of this term, write one right away so that they
+	// are (and linearizable reads can be served) without waiting for a client request.
+	noop, err := sm.writeToLogs(nil, konsen.LogType_NOOP)
+	if err != nil {
+		return err
+	}
+	sm.termStartIndex = noop.GetIndex()
+	if err := sm.sendAppendEntries(context.Background()); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sm.stopHeartbeat = cancel
+	sm.startHeartbeatLoop(ctx)
 
 	return nil
 }
 
 // sendVoteRequests constructs a RequestVote request and sends it to all nodes in the cluster.
 func (sm *StateMachine) sendVoteRequests(ctx context.Context) error {
-	currentTerm, err := sm.storage.GetCurrentTerm()
+	currentTerm, err := sm.stable.GetCurrentTerm()
 	if err != nil {
-		return fmt.Errorf("failed to get current term: %v", err)
+		return fmt.Errorf("failed to get current term: %w", err)
 	}
-	lastLogIndex, err := sm.storage.LastLogIndex()
+	lastLogIndex, err := sm.logs.LastLogIndex()
 	if err != nil {
-		return fmt.Errorf("failed to get last log index: %v", err)
+		return fmt.Errorf("failed to get last log index: %w", err)
 	}
-	lastLogTerm, err := sm.storage.LastLogTerm()
+	lastLogTerm, err := sm.logs.LastLogTerm()
 	if err != nil {
-		return fmt.Errorf("failed to get last log term: %v", err)
+		return fmt.Errorf("failed to get last log term: %w", err)
 	}
 	req := &konsen.RequestVoteReq{
 		Term:         currentTerm,
@@ -501,10 +835,10 @@ func (sm *StateMachine) sendVoteRequests(ctx context.Context) error {
 				defer sm.wg.Done()
 				resp, err := sm.clients[server].RequestVote(ctx, req)
 				if err != nil {
-					log.Debug("Failed to send RequestVote to %q(%q): %v", server, sm.cluster.Servers[server], err)
+					sm.logger.Debugf("Failed to send RequestVote to %q(%q): %v", server, sm.cluster.Servers[server], err)
 				}
 				select {
-				case sm.msgCh <- resp:
+				case sm.raftCh <- resp:
 				case <-sm.stopCh:
 				}
 			}()
@@ -519,21 +853,22 @@ func (sm *StateMachine) sendAppendEntries(ctx context.Context) error {
 		return nil
 	}
 
-	currentTerm, err := sm.storage.GetCurrentTerm()
+	currentTerm, err := sm.stable.GetCurrentTerm()
 	if err != nil {
-		return fmt.Errorf("failed to get current term: %v", err)
+		return fmt.Errorf("failed to get current term: %w", err)
 	}
+	round := sm.startRound()
 
 	for server := range sm.cluster.Servers {
 		if server != sm.cluster.LocalServerName {
 			prevLogIndex := sm.nextIndex[server] - 1
-			prevLogTerm, err := sm.storage.GetLogTerm(prevLogIndex)
+			prevLogTerm, err := sm.logs.GetLogTerm(prevLogIndex)
 			if err != nil {
-				return fmt.Errorf("failed to get log term at index %d: %v", prevLogIndex, err)
+				return fmt.Errorf("failed to get log term at index %d: %w", prevLogIndex, err)
 			}
-			entries, err := sm.storage.GetLogsFrom(sm.nextIndex[server])
+			entries, err := sm.logs.GetLogsFrom(sm.nextIndex[server])
 			if err != nil {
-				return fmt.Errorf("failed to get logs from index %d: %v", sm.nextIndex[server], err)
+				return fmt.Errorf("failed to get logs from index %d: %w", sm.nextIndex[server], err)
 			}
 			req := &konsen.AppendEntriesReq{
 				Term:         currentTerm,
@@ -543,21 +878,26 @@ func (sm *StateMachine) sendAppendEntries(ctx context.Context) error {
 				Entries:      entries,
 				LeaderCommit: sm.commitIndex,
 			}
+			ctx, span := sm.startReplicationSpan(ctx, server, entries)
 
 			sm.wg.Add(1)
 			server := server
 			go func() {
 				defer sm.wg.Done()
+				defer span.End()
 				resp, err := sm.clients[server].AppendEntries(ctx, req)
 				if err != nil {
-					log.Debug("Failed to send AppendEntries to %q(%q): %v", server, sm.cluster.Servers[server], err)
+					if ok, suppressed := sm.logSampler.sample("append_entries_failure:" + server); ok {
+						sm.logger.WithField("suppressed", suppressed).Debugf("Failed to send AppendEntries to %q(%q): %v", server, sm.cluster.Servers[server], err)
+					}
 					return
 				}
 				select {
-				case sm.msgCh <- appendEntriesRespWrap{
+				case sm.raftCh <- appendEntriesRespWrap{
 					resp:   resp,
 					req:    req,
 					server: server,
+					round:  round,
 				}:
 				case <-sm.stopCh:
 				}
@@ -568,38 +908,83 @@ func (sm *StateMachine) sendAppendEntries(ctx context.Context) error {
 	return nil
 }
 
+// startReplicationSpan starts a span of sending entries to a follower, in the trace of the request that proposed the
+// first entry, with links to the traces of the others. Nothing is recorded for heartbeats.
+func (sm *StateMachine) startReplicationSpan(ctx context.Context, server string, entries []*konsen.Log) (context.Context, trace.Span) {
+	parent := ctx
+	var links []trace.Link
+	for _, entry := range entries {
+		w := sm.loadApplyWaiter(entry)
+		if w == nil {
+			continue
+		}
+		if wctx := w.ctx; parent == ctx {
+			parent = wctx
+		} else if sc := trace.SpanContextFromContext(wctx); sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+	return tracing.StartSpan(trace.ContextWithSpan(ctx, trace.SpanFromContext(parent)), "raft.replicate",
+		trace.WithLinks(links...),
+		trace.WithAttributes(attribute.String("raft.follower", server), attribute.Int("raft.entries", len(entries))))
+}
+
 // handleElectionTimeout handles when the election timeout event triggers.
 func (sm *StateMachine) handleElectionTimeout() error {
+	// A leader without peers never loses contact with the majority.
+	if sm.role == konsen.Role_LEADER && sm.getQuorum() == 0 {
+		sm.timerGateCh <- struct{}{}
+		return nil
+	}
+
 	// If election timeout elapses without receiving AppendEntries RPC from current leader or granting vote to candidate: convert to candidate.
-	sm.role = konsen.Role_CANDIDATE
+	if err := sm.startElection(); err != nil {
+		return err
+	}
+
+	// Reset election timer.
+	sm.timerGateCh <- struct{}{}
+	return nil
+}
+
+// startElection converts to candidate and starts a new election.
+func (sm *StateMachine) startElection() error {
+	sm.metrics.Elections.Inc()
+	sm.setRole(konsen.Role_CANDIDATE)
 	sm.numVotes = 0
 	sm.currentLeader = ""
 
 	// On conversion to candidate, start election:
 	// 1. Increment currentTerm.
-	currentTerm, err := sm.storage.GetCurrentTerm()
+	currentTerm, err := sm.stable.GetCurrentTerm()
 	if err != nil {
-		return fmt.Errorf("failed to get current term: %v", err)
+		return fmt.Errorf("failed to get current term: %w", err)
 	}
-	log.Debug("Term - %d: election timeout", currentTerm)
+	sm.log().Debugf("Term - %d: election timeout", currentTerm)
 	currentTerm++
-	if err := sm.storage.SetCurrentTerm(currentTerm); err != nil {
-		return fmt.Errorf("failed to set current term to %d: %v", currentTerm, err)
+	if err := sm.stable.SetCurrentTerm(currentTerm); err != nil {
+		return fmt.Errorf("failed to set current term to %d: %w", currentTerm, err)
 	}
+	sm.term = currentTerm
 
 	// 2. Vote for self.
-	if err := sm.storage.SetVotedFor(sm.cluster.LocalServerName); err != nil {
-		return fmt.Errorf("failed to set votedFor: %v", err)
+	if err := sm.stable.SetVotedFor(sm.cluster.LocalServerName); err != nil {
+		return fmt.Errorf("failed to set votedFor: %w", err)
 	}
 	sm.numVotes++
 
+	// A server without peers has the majority of votes already.
+	if sm.numVotes > sm.getQuorum() {
+		return sm.becomeLeader(currentTerm)
+	}
+
 	// 3. Reset election timer.
-	sm.timerGateCh <- struct{}{}
+	// Handled by the caller.
 
 	// 4. Send RequestVote RPCs to all other servers.
-	log.Debug("Send RequestVote for term %d.", currentTerm)
+	sm.log().Debugf("Send RequestVote for term %d.", currentTerm)
 	if err := sm.sendVoteRequests(context.Background()); err != nil {
-		return fmt.Errorf("failed to send vote requests: %v", err)
+		return fmt.Errorf("failed to send vote requests: %w", err)
 	}
 
 	// 5. If votes received from majority of servers: become leader.
@@ -615,28 +1000,82 @@ func (sm *StateMachine) handleElectionTimeout() error {
 }
 
 // maybeApplyLogs applies logs that are not yet.
-func (sm *StateMachine) maybeApplyLogs(applyCommand func(command []byte) error) error {
+func (sm *StateMachine) maybeApplyLogs(applyCommand func(entry *konsen.Log) error) error {
+	// Never apply anything once corruption is detected.
+	if sm.isCorrupted() {
+		return nil
+	}
 	// If commitIndex > lastApplied: increment lastApplied, apply log[lastApplied] to state machine.
 	for sm.commitIndex > sm.lastApplied {
-		sm.lastApplied++
-		logEntry, err := sm.storage.GetLog(sm.lastApplied)
+		logIndex := sm.lastApplied + 1
+		logEntry, err := sm.logs.GetLog(logIndex)
 		if err != nil {
-			return fmt.Errorf("failed to get log at index %d", sm.lastApplied)
+			return fmt.Errorf("failed to get log at index %d: %w", logIndex, err)
+		}
+		// The entry may have been corrupted after it was read from storage (e.g. while cached in memory).
+		if err := store.VerifyLogChecksum(logEntry); err != nil {
+			return fmt.Errorf("failed to apply log at index %d: %w", logIndex, err)
+		}
+		span := trace.SpanFromContext(context.Background())
+		if w := sm.loadApplyWaiter(logEntry); w != nil {
+			_, span = tracing.StartSpan(w.ctx, "raft.apply")
 		}
-		if err := applyCommand(logEntry.GetData()); err != nil {
-			return fmt.Errorf("failed to apply command from log at index %d", sm.lastApplied)
+		start := time.Now()
+		err = applyCommand(logEntry)
+		span.End()
+		if err != nil {
+			return fmt.Errorf("failed to apply command from log at index %d: %w", logIndex, err)
 		}
+		sm.metrics.ApplySeconds.WithLabelValues(logEntry.GetType().String()).Observe(time.Since(start).Seconds())
+		sm.lastApplied = logIndex
 		// Notifies if there is a goroutine waiting on log[lastApplied] being applied.
-		condCh, ok := sm.condMap.Load(sm.lastApplied)
-		if ok {
-			condCh := condCh.(chan struct{})
-			close(condCh)
+		if w, ok := sm.condMap.Load(sm.lastApplied); ok {
+			sm.condMap.Delete(sm.lastApplied)
+			w.(*applyWaiter).appliedTerm = logEntry.GetTerm()
+			close(w.(*applyWaiter).done)
 		}
-		log.Debug("Applied log at index %d", sm.lastApplied)
+		sm.log().Debugf("Applied log at index %d", sm.lastApplied)
 	}
 	return nil
 }
 
+// applyLog applies a committed log entry to the local key-value store, and records its index as the applied index in
+// the same write, so that no entry is applied again after a restart.
+func (sm *StateMachine) applyLog(entry *konsen.Log) error {
+	switch entry.GetType() {
+	case konsen.LogType_DATA:
+		kvs := &konsen.KVList{}
+		if err := proto.Unmarshal(entry.GetData(), kvs); err != nil {
+			return err
+		}
+		return sm.kv.ApplyKVs(entry.GetIndex(), kvs.GetKvList())
+	case konsen.LogType_TXN:
+		txn := &konsen.TxnReq{}
+		if err := proto.Unmarshal(entry.GetData(), txn); err != nil {
+			return err
+		}
+		succeeded, err := sm.applyTxn(entry.GetIndex(), txn)
+		if err != nil {
+			return err
+		}
+		if w := sm.loadApplyWaiter(entry); w != nil {
+			w.txnSucceeded = succeeded
+		}
+		return nil
+	case konsen.LogType_CONSISTENCY_CHECK:
+		if err := sm.recordStateHash(entry.GetIndex()); err != nil {
+			return err
+		}
+		return sm.kv.ApplyKVs(entry.GetIndex(), nil)
+	case konsen.LogType_AUTH:
+		return sm.applyAuth(entry)
+	case konsen.LogType_NOOP:
+		return sm.kv.ApplyKVs(entry.GetIndex(), nil)
+	default:
+		return fmt.Errorf("unrecognized log type: %v", entry.GetType())
+	}
+}
+
 // startMessageLoop starts the main message loop, the goroutine that runs message loop in turns picks an event from the
 // message channel and processes it.
 func (sm *StateMachine) startMessageLoop(ctx context.Context) {
@@ -646,86 +1085,163 @@ func (sm *StateMachine) startMessageLoop(ctx context.Context) {
 
 		for {
 			// If commitIndex > lastApplied: increment lastApplied, apply log[lastApplied] to state machine.
-			if err := sm.maybeApplyLogs(func(data []byte) error {
-				kvs := &konsen.KVList{}
-				if err := proto.Unmarshal(data, kvs); err != nil {
-					return err
-				}
-				for _, kv := range kvs.GetKvList() {
-					if err := sm.storage.SetValue(kv.GetKey(), kv.GetValue()); err != nil {
-						return err
-					}
-				}
-				return nil
-			}); err != nil {
-				log.Fatalf("%v", err)
+			if err := sm.maybeApplyLogs(sm.applyLog); err != nil {
+				sm.handleError(err)
+			}
+			sm.maybeFinishTransfer()
+			if err := sm.maybeServeReads(); err != nil {
+				sm.handleError(err)
 			}
 
+			// Raft protocol messages first, then whichever comes first.
+			var msg interface{}
 			select {
-			case <-ctx.Done():
-				return
-			case <-sm.stopCh:
-				return
-			case msg, open := <-sm.msgCh:
-				if !open {
+			case msg = <-sm.raftCh:
+			default:
+				select {
+				case <-ctx.Done():
 					return
+				case <-sm.stopCh:
+					return
+				case msg = <-sm.raftCh:
+				case msg = <-sm.msgCh:
 				}
+			}
 
-				switch v := msg.(type) {
-				case appendEntriesWrap:
-					// Process incoming AppendEntries request.
-					resp, err := sm.handleAppendEntries(v.req)
-					if err != nil {
-						log.Fatalf("%v", err)
-					}
-					v.ch <- resp
-				case requestVoteWrap:
-					// Process incoming RequestVote request.
-					resp, err := sm.handleRequestVote(v.req)
-					if err != nil {
-						log.Fatalf("%v", err)
-					}
-					v.ch <- resp
-				case appendEntriesRespWrap:
-					if err := sm.handleAppendEntriesResp(v.resp, v.req, v.server); err != nil {
-						log.Fatalf("%v", err)
-					}
-				case *konsen.RequestVoteResp:
-					if err := sm.handleRequestVoteResp(v); err != nil {
-						log.Fatalf("%v", err)
-					}
-				case electionTimeoutMsg:
-					if err := sm.handleElectionTimeout(); err != nil {
-						log.Fatalf("%v", err)
-					}
-				case appendEntriesMsg:
-					if err := sm.sendAppendEntries(context.Background()); err != nil {
-						log.Fatalf("%v", err)
-					}
-				case appendDataMsg:
-					if err := sm.handleAppendData(v.req, v.ch); err != nil {
-						log.Fatalf("%v", err)
-					}
-				case getSnapshotMsg:
-					snapshot, err := sm.handleGetSnapshot()
-					if err != nil {
-						log.Fatalf("%v", err)
-					}
-					v.ch <- snapshot
-				case getValueMsg:
-					val, err := sm.handleGetValue(v.key)
-					if err != nil {
-						log.Fatalf("%v", err)
-					}
-					v.ch <- val
-				default:
-					log.Fatalf("Unrecognized message: %v", v)
-				}
+			if sm.isCorrupted() {
+				sm.handleMessageWhenCorrupted(msg)
+				continue
 			}
+			sm.handleMessage(msg)
 		}
 	}()
 }
 
+// handleMessage processes a message taken from the message queues.
+func (sm *StateMachine) handleMessage(msg interface{}) {
+	switch v := msg.(type) {
+	case appendEntriesWrap:
+		// Process incoming AppendEntries request.
+		resp, err := sm.handleAppendEntries(v.ctx, v.req)
+		if err != nil {
+			sm.handleError(err)
+			return
+		}
+		v.ch <- resp
+	case requestVoteWrap:
+		// Process incoming RequestVote request.
+		resp, err := sm.handleRequestVote(v.req)
+		if err != nil {
+			sm.handleError(err)
+			return
+		}
+		v.ch <- resp
+	case appendEntriesRespWrap:
+		if err := sm.handleAppendEntriesResp(v.resp, v.req, v.server, v.round); err != nil {
+			sm.handleError(err)
+		}
+	case *konsen.RequestVoteResp:
+		if err := sm.handleRequestVoteResp(v); err != nil {
+			sm.handleError(err)
+		}
+	case electionTimeoutMsg:
+		if err := sm.handleElectionTimeout(); err != nil {
+			sm.handleError(err)
+		}
+	case appendEntriesMsg:
+		if err := sm.sendAppendEntries(context.Background()); err != nil {
+			sm.handleError(err)
+		}
+	case appendDataMsg:
+		if err := sm.handleAppendData(v.ctx, v.req, v.reply); err != nil {
+			v.reply(&konsen.AppendDataResp{Success: false, ErrorMessage: err.Error()})
+			sm.handleError(err)
+		}
+	case getSnapshotMsg:
+		snapshot, err := sm.handleGetSnapshot()
+		if err != nil {
+			sm.handleError(err)
+			return
+		}
+		v.ch <- snapshot
+	case getStatusMsg:
+		status, err := sm.handleGetStatus()
+		if err != nil {
+			sm.handleError(err)
+			return
+		}
+		v.ch <- status
+	case getMsg:
+		if err := sm.handleRead(v.ctx, v.req.GetOptions(), v.errCh, func() error {
+			resp, err := sm.handleGet(v.req)
+			if err != nil {
+				return err
+			}
+			v.ch <- resp
+			return nil
+		}); err != nil {
+			sm.handleError(err)
+		}
+	case consistencyCheckMsg:
+		if err := sm.handleConsistencyCheck(); err != nil {
+			sm.handleError(err)
+		}
+	case getConsistencyReportMsg:
+		v.ch <- sm.handleGetConsistencyReport()
+	case stateHashMsg:
+		sm.handleStateHash(v)
+	case rangeMsg:
+		if err := sm.handleRead(v.ctx, v.req.GetOptions(), v.errCh, func() error {
+			resp, err := sm.handleRange(v.req)
+			if err != nil {
+				return err
+			}
+			v.ch <- resp
+			return nil
+		}); err != nil {
+			sm.handleError(err)
+		}
+	case transferLeadershipMsg:
+		if err := sm.handleTransferLeadership(v.target, v.ch); err != nil {
+			sm.handleError(err)
+		}
+	case timeoutNowWrap:
+		resp, err := sm.handleTimeoutNow(v.req)
+		if err != nil {
+			sm.handleError(err)
+			return
+		}
+		v.ch <- resp
+	default:
+		sm.log().Fatalf("Unrecognized message: %v", v)
+	}
+}
+
+// handleMessageWhenCorrupted processes a message after log corruption is detected: only snapshots and status are
+// served so that the corruption can be inspected, callers of anything else are released by corruptedCh.
+func (sm *StateMachine) handleMessageWhenCorrupted(msg interface{}) {
+	switch v := msg.(type) {
+	case getSnapshotMsg:
+		snapshot, err := sm.handleGetSnapshot()
+		if err != nil {
+			sm.log().Fatalf("%v", err)
+		}
+		v.ch <- snapshot
+	case getStatusMsg:
+		status, err := sm.handleGetStatus()
+		if err != nil {
+			sm.log().Fatalf("%v", err)
+		}
+		v.ch <- status
+	case appendDataMsg:
+		// The caller gets the corruption error, the proposal is only answered to release its slot.
+		v.reply(&konsen.AppendDataResp{Success: false, ErrorMessage: sm.corruption.Error()})
+	case electionTimeoutMsg:
+		// Keep the election timer running without starting an election.
+		sm.openElectionTimerGate()
+	}
+}
+
 // startElectionLoop starts the election timeout monitoring loop.
 func (sm *StateMachine) startElectionLoop(ctx context.Context) {
 	sm.timerGateCh <- struct{}{}
@@ -748,21 +1264,29 @@ func (sm *StateMachine) startElectionLoop(ctx context.Context) {
 				timer.Stop()
 				continue
 			case <-timer.C:
-				// Election timeout occurs.
-				sm.msgCh <- electionTimeoutMsg{}
+				// Election timeout occurs. The message loop may be handling a message that resets the timer meanwhile,
+				// which must not wait for the timeout to be taken.
+				select {
+				case sm.raftCh <- electionTimeoutMsg{}:
+				case <-sm.resetTimerCh:
+				case <-ctx.Done():
+					return
+				case <-sm.stopCh:
+					return
+				}
 			}
 		}
 	}()
 }
 
-// startHeartbeatLoop starts the heartbeat loop.
+// startHeartbeatLoop starts the heartbeat loop, which runs until ctx is cancelled when the leadership is lost.
 func (sm *StateMachine) startHeartbeatLoop(ctx context.Context) {
 	sm.wg.Add(1)
 	go func() {
 		defer sm.wg.Done()
 
 		// Heartbeat worker.
-		ticker := time.NewTicker(defaultHeartbeat)
+		ticker := time.NewTicker(sm.heartbeatInterval)
 		defer ticker.Stop()
 		for {
 			select {
@@ -771,11 +1295,13 @@ func (sm *StateMachine) startHeartbeatLoop(ctx context.Context) {
 			case <-sm.stopCh:
 				return
 			case <-ticker.C:
-				// TODO: role is read by another goroutine, there might be data race.
-				if sm.role != konsen.Role_LEADER {
+				select {
+				case sm.raftCh <- appendEntriesMsg{}:
+				case <-ctx.Done():
+					return
+				case <-sm.stopCh:
 					return
 				}
-				sm.msgCh <- appendEntriesMsg{}
 			}
 		}
 	}()
@@ -783,20 +1309,57 @@ func (sm *StateMachine) startHeartbeatLoop(ctx context.Context) {
 
 // nextTimeout calculates the next election timeout duration.
 func (sm *StateMachine) nextTimeout() time.Duration {
-	timeout := rand.Int63n(int64(defaultTimeoutSpan)) + int64(defaultMinTimeout)
+	timeout := rand.Int63n(int64(sm.electionTimeoutMax-sm.electionTimeoutMin)) + int64(sm.electionTimeoutMin)
 	return time.Duration(timeout)
 }
 
 // AppendData stores the given data into state machine, and it returns after the data is replicated onto quorum.
+// Proposals received by followers are counted both on the follower and on the leader they are forwarded to. A proposal
+// holds its slot in the proposal limiter until it is answered: committed and applied, rejected, or timed out, even if
+// the caller gives up earlier.
 func (sm *StateMachine) AppendData(ctx context.Context, req *konsen.AppendDataReq) (*konsen.AppendDataResp, error) {
-	ch := make(chan *konsen.AppendDataResp)
-	sm.msgCh <- appendDataMsg{req: req, ch: ch}
+	sm.metrics.Proposals.WithLabelValues(req.GetType().String()).Inc()
+	size := int64(len(req.GetData()))
+	if !sm.proposals.acquire(size) {
+		sm.metrics.ProposalsFailed.WithLabelValues(konsen.AppendDataError_OVERLOADED.String()).Inc()
+		return sm.proposals.overloadedResp(), nil
+	}
+	resp, err := sm.appendData(ctx, req, size)
+	if err != nil {
+		reason := konsen.AppendDataError_UNKNOWN
+		if errors.Is(err, ErrNotLeader) {
+			reason = konsen.AppendDataError_NOT_LEADER
+		} else if errors.Is(err, ErrTimeout) || errors.Is(err, context.DeadlineExceeded) {
+			reason = konsen.AppendDataError_TIMEOUT
+		}
+		sm.metrics.ProposalsFailed.WithLabelValues(reason.String()).Inc()
+	} else if !resp.GetSuccess() {
+		sm.metrics.ProposalsFailed.WithLabelValues(resp.GetError().String()).Inc()
+	}
+	return resp, err
+}
+
+// appendData proposes an admitted proposal of given size, whose slot is released once it is answered.
+func (sm *StateMachine) appendData(ctx context.Context, req *konsen.AppendDataReq, size int64) (*konsen.AppendDataResp, error) {
+	ch := make(chan *konsen.AppendDataResp, 1)
+	reply := func(resp *konsen.AppendDataResp) {
+		sm.proposals.release(size)
+		ch <- resp
+	}
+	if err := sm.enqueue(ctx, appendDataMsg{ctx: ctx, req: req, reply: reply}); err != nil {
+		// The message loop has not taken the proposal, so it is never answered.
+		sm.proposals.release(size)
+		return nil, err
+	}
 
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	case <-sm.stopCh:
-		return nil, fmt.Errorf("server has been shut down")
+		// The proposal may have been appended already, so its outcome is unknown.
+		return nil, &requestError{kind: ErrTimeout, msg: "server has been shut down before the proposal completed"}
+	case <-sm.corruptedCh:
+		return nil, sm.corruption
 	case resp := <-ch:
 		return resp, nil
 	}
@@ -810,173 +1373,158 @@ func (sm *StateMachine) getQuorum() int {
 // data is applied to local state machine.
 // The message loop goroutine should NEVER block in this method since it depends on subsequent AppendEntriesResp in
 // order to determine which log is committed, otherwise it will deadlock.
-func (sm *StateMachine) handleAppendData(req *konsen.AppendDataReq, ch chan<- *konsen.AppendDataResp) error {
+func (sm *StateMachine) handleAppendData(ctx context.Context, req *konsen.AppendDataReq, reply func(*konsen.AppendDataResp)) error {
+	ctx, span := tracing.StartSpan(ctx, "raft.handleAppendData")
+	defer span.End()
+
 	// If no leader is elected, put the message back to message queue.
 	if sm.currentLeader == "" {
-		ch <- &konsen.AppendDataResp{
+		reply(&konsen.AppendDataResp{
 			Success:      false,
+			Error:        konsen.AppendDataError_NO_QUORUM,
 			ErrorMessage: fmt.Sprintf("no leader is elected yet (are there more than %d nodes down?)", sm.getQuorum()),
-		}
+		})
 		return nil
 	}
 
 	// Only leader writes data to its logs.
 	if sm.role != konsen.Role_LEADER {
+		// Changes of users and roles are not forwarded, the leader does not accept them from other servers.
+		if req.GetType() == konsen.LogType_AUTH {
+			reply(&konsen.AppendDataResp{
+				Success:      false,
+				Error:        konsen.AppendDataError_NOT_LEADER,
+				ErrorMessage: fmt.Sprintf("changes of users and roles must be sent to the leader %q", sm.currentLeader),
+			})
+			return nil
+		}
 		// Forward the request to leader.
-		sm.forwardRequestToLeader(req, ch)
+		sm.forwardRequestToLeader(ctx, req, reply)
+		return nil
+	}
+
+	// Stop accepting new data so that the transfer target can catch up.
+	if sm.transfer != nil {
+		reply(&konsen.AppendDataResp{
+			Success:      false,
+			Error:        konsen.AppendDataError_NOT_LEADER,
+			ErrorMessage: fmt.Sprintf("leadership transfer to %q is in progress", sm.transfer.target),
+		})
+		return nil
+	}
+
+	if t := req.GetType(); t != konsen.LogType_DATA && t != konsen.LogType_TXN && t != konsen.LogType_AUTH {
+		reply(&konsen.AppendDataResp{Success: false, ErrorMessage: fmt.Sprintf("unsupported data type: %v", t)})
 		return nil
 	}
 
 	// Writes data into a new log entry next to the last log.
-	newLog, err := sm.writeToLogs(req.GetData())
+	_, writeSpan := tracing.StartSpan(ctx, "storage.WriteLog")
+	newLog, err := sm.writeToLogs(req.GetData(), req.GetType())
+	writeSpan.End()
 	if err != nil {
 		return err
 	}
 
 	// Starts a new goroutine to wait until the new log is committed (replicated on quorum) and applied to local state machine.
-	sm.replyWhenLogApplied(newLog.GetIndex(), ch)
+	sm.replyWhenLogApplied(ctx, newLog, reply)
 	return nil
 }
 
-func (sm *StateMachine) writeToLogs(data []byte) (*konsen.Log, error) {
-	lastLogIndex, err := sm.storage.LastLogIndex()
+func (sm *StateMachine) writeToLogs(data []byte, logType konsen.LogType) (*konsen.Log, error) {
+	lastLogIndex, err := sm.logs.LastLogIndex()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get last log index: %v", err)
+		return nil, fmt.Errorf("failed to get last log index: %w", err)
 	}
-	currentTerm, err := sm.storage.GetCurrentTerm()
+	currentTerm, err := sm.stable.GetCurrentTerm()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get current term: %v", err)
+		return nil, fmt.Errorf("failed to get current term: %w", err)
 	}
 	newLog := &konsen.Log{
 		Index: lastLogIndex + 1,
 		Term:  currentTerm,
 		Data:  data,
+		Type:  logType,
+	}
+	newLog.Checksum = store.LogChecksum(newLog)
+	if err := sm.logs.WriteLog(newLog); err != nil {
+		return nil, fmt.Errorf("failed to write log: %w", err)
 	}
-	if err := sm.storage.WriteLog(newLog); err != nil {
-		return nil, fmt.Errorf("failed to write log: %v", err)
+	sm.log().Debugf("Log written: index - %d, term - %d, bytes - %d.", newLog.GetIndex(), newLog.GetTerm(), len(newLog.GetData()))
+	// A leader without peers commits the log once it is written.
+	if sm.isLogOnMajority(newLog.GetIndex()) {
+		sm.commitIndex = newLog.GetIndex()
 	}
-	log.Debug("Log written: index - %d, term - %d, bytes - %d.", newLog.GetIndex(), newLog.GetTerm(), len(newLog.GetData()))
 	return newLog, nil
 }
 
 // forwardRequestToLeader starts a new goroutine to send request to current leader, and the goroutine replies after receiving result from leader.
-func (sm *StateMachine) forwardRequestToLeader(req *konsen.AppendDataReq, ch chan<- *konsen.AppendDataResp) {
+// The forwarded request is in the trace of ctx and carries its client credentials, but is not canceled with it.
+func (sm *StateMachine) forwardRequestToLeader(ctx context.Context, req *konsen.AppendDataReq, reply func(*konsen.AppendDataResp)) {
+	leader := sm.currentLeader
+	forwardCtx := trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+	if creds := CredentialsFrom(ctx); creds != nil {
+		forwardCtx = WithCredentials(forwardCtx, creds)
+	}
+	ctx, span := tracing.StartSpan(forwardCtx,
+		"raft.forwardToLeader", trace.WithAttributes(attribute.String("raft.leader", leader)))
 	sm.wg.Add(1)
 	go func() {
 		defer sm.wg.Done()
-		ctx := context.Background()
-		resp, err := sm.clients[sm.currentLeader].AppendData(ctx, req)
+		defer span.End()
+		resp, err := sm.clients[leader].AppendData(ctx, req)
 		if err != nil {
-			log.Debug("Failed to send AppendDataReq to leader %q: %v", sm.currentLeader, err)
-			ch <- &konsen.AppendDataResp{Success: false, ErrorMessage: err.Error()}
+			sm.logger.Debugf("Failed to send AppendDataReq to leader %q: %v", leader, err)
+			reply(&konsen.AppendDataResp{Success: false, Error: konsen.AppendDataError_NO_QUORUM, ErrorMessage: err.Error()})
 			return
 		}
-		ch <- resp
+		reply(resp)
 	}()
 }
 
-// replyWhenLogApplied starts a new goroutine to reply request after new log is applied to local state machine.
-func (sm *StateMachine) replyWhenLogApplied(logIndex uint64, ch chan<- *konsen.AppendDataResp) {
-	condCh := make(chan struct{})
-	sm.condMap.Store(logIndex, condCh)
+// replyWhenLogApplied starts a new goroutine to reply request after new log is applied to local state machine. The
+// waiter is removed by the message loop once a log is applied at its index, a waiter that timed out stays until then.
+func (sm *StateMachine) replyWhenLogApplied(ctx context.Context, newLog *konsen.Log, reply func(*konsen.AppendDataResp)) {
+	logIndex := newLog.GetIndex()
+	ctx, span := tracing.StartSpan(ctx, "raft.commit", trace.WithAttributes(attribute.Int64("raft.index", int64(logIndex))))
+	w := &applyWaiter{ctx: ctx, term: newLog.GetTerm(), done: make(chan struct{})}
+	// The log of an earlier waiter at the same index was overwritten before it was applied.
+	if old, ok := sm.condMap.Load(logIndex); ok {
+		close(old.(*applyWaiter).done)
+	}
+	sm.condMap.Store(logIndex, w)
+	start := time.Now()
 	sm.wg.Add(1)
 	go func() {
 		defer sm.wg.Done()
+		defer span.End()
 		select {
-		case <-condCh:
-			sm.condMap.Delete(logIndex)
-			log.Debug("Log[%d] is committed and applied on local state machine.", logIndex)
-			ch <- &konsen.AppendDataResp{Success: true}
+		case <-w.done:
+			if w.appliedTerm != w.term {
+				sm.logger.Debugf("Log[%d] of term %d is overwritten by a log of term %d.", logIndex, w.term, w.appliedTerm)
+				reply(&konsen.AppendDataResp{
+					Success:      false,
+					Error:        konsen.AppendDataError_NOT_LEADER,
+					ErrorMessage: fmt.Sprintf("log at index %d of term %d is overwritten by another leader", logIndex, w.term),
+				})
+				return
+			}
+			sm.metrics.ProposalCommitSeconds.Observe(time.Since(start).Seconds())
+			sm.logger.Debugf("Log[%d] is committed and applied on local state machine.", logIndex)
+			reply(&konsen.AppendDataResp{Success: true, Index: logIndex, TxnSucceeded: w.txnSucceeded})
+		case <-sm.stopCh:
+			reply(&konsen.AppendDataResp{Success: false, Error: konsen.AppendDataError_NOT_LEADER, ErrorMessage: "server has been shut down"})
 		case <-time.After(defaultRequestTimeout):
-			log.Debug("Timeout while waiting for log[%d] to be committed and applied.", logIndex)
-			ch <- &konsen.AppendDataResp{
+			sm.logger.Debugf("Timeout while waiting for log[%d] to be committed and applied.", logIndex)
+			reply(&konsen.AppendDataResp{
 				Success:      false,
+				Error:        konsen.AppendDataError_TIMEOUT,
 				ErrorMessage: fmt.Sprintf("failed to replicate onto quorum and apply commands (are there more than %d nodes down?)", sm.getQuorum()),
-			}
+			})
 		}
 	}()
 }
 
-func (sm *StateMachine) GetSnapshot(ctx context.Context) (*Snapshot, error) {
-	ch := make(chan *Snapshot)
-	sm.msgCh <- getSnapshotMsg{ch: ch}
-	select {
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	case resp := <-ch:
-		return resp, nil
-	}
-}
-
-func (sm *StateMachine) handleGetSnapshot() (*Snapshot, error) {
-	currentTerm, err := sm.storage.GetCurrentTerm()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get current term: %v", err)
-	}
-	logs, err := sm.storage.GetLogsFrom(1)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get logs: %v", err)
-	}
-	nextIndexMap := make(map[string]uint64)
-	for k, v := range sm.nextIndex {
-		nextIndexMap[k] = v
-	}
-	matchIndexMap := make(map[string]uint64)
-	for k, v := range sm.matchIndex {
-		matchIndexMap[k] = v
-	}
-	logIndices := make([]uint64, len(logs))
-	logTerms := make([]uint64, len(logs))
-	logBytes := make([]int, len(logs))
-	for i, e := range logs {
-		logIndices[i] = e.GetIndex()
-		logTerms[i] = e.GetTerm()
-		logBytes[i] = len(e.GetData())
-	}
-	return &Snapshot{
-		CurrentTerm:   currentTerm,
-		CommitIndex:   sm.commitIndex,
-		LastApplied:   sm.lastApplied,
-		Role:          sm.role,
-		CurrentLeader: sm.currentLeader,
-		NextIndex:     nextIndexMap,
-		MatchIndex:    matchIndexMap,
-		LogIndices:    logIndices,
-		LogTerms:      logTerms,
-		LogBytes:      logBytes,
-	}, nil
-}
-
-func (sm *StateMachine) SetKeyValue(ctx context.Context, kv *konsen.KVList) error {
-	buf, err := proto.Marshal(kv)
-	if err != nil {
-		return fmt.Errorf("failed to marshal: %v", err)
-	}
-	resp, err := sm.AppendData(ctx, &konsen.AppendDataReq{Data: buf})
-	if err != nil {
-		return err
-	}
-	if !resp.Success {
-		return fmt.Errorf(resp.ErrorMessage)
-	}
-	return nil
-}
-
-func (sm *StateMachine) GetValue(ctx context.Context, key []byte) ([]byte, error) {
-	ch := make(chan []byte)
-	sm.msgCh <- getValueMsg{key: key, ch: ch}
-	select {
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	case resp := <-ch:
-		return resp, nil
-	}
-}
-
-func (sm *StateMachine) handleGetValue(key []byte) ([]byte, error) {
-	return sm.storage.GetValue(key)
-}
-
 func (sm *StateMachine) Close() error {
 	close(sm.stopCh)
 	sm.wg.Wait()
@@ -0,0 +1,161 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+	"github.com/lizhaoliu/konsen/v2/store"
+)
+
+// Maximum number of most recent log entries listed in a snapshot.
+const maxSnapshotLogs = 100
+
+// Status is the Raft status of a server, it is cheap to get compared to Snapshot.
+type Status struct {
+	Server        string                     // Local server name.
+	Role          konsen.Role                // Current role.
+	Term          uint64                     // Current term.
+	Leader        string                     // Current leader, empty if unknown.
+	CommitIndex   uint64                     // Index of highest log entry known to be committed.
+	LastApplied   uint64                     // Index of highest log entry applied to state machine.
+	FirstLogIndex uint64                     // Index of the first log entry, 0 if there is none.
+	LastLogIndex  uint64                     // Index of the last log entry, 0 if there is none.
+	LastLogTerm   uint64                     // Term of the last log entry, 0 if there is none.
+	Followers     map[string]*FollowerStatus // Replication status of each follower, only available on leader.
+	Corruption    string                     // Description of the log corruption detected on this server, empty if none.
+}
+
+// FollowerStatus is the replication status of a follower, as seen by the leader.
+type FollowerStatus struct {
+	NextIndex  uint64 // Index of the next log entry to send to the follower.
+	MatchIndex uint64 // Index of highest log entry known to be replicated on the follower.
+	Lag        uint64 // Number of log entries not yet known to be replicated on the follower.
+}
+
+// Snapshot is a snapshot of the internal state of a state machine, for debug purpose.
+type Snapshot struct {
+	Status
+	VotedFor       string   // Candidate that received vote in current term.
+	LogIndices     []uint64 // Indices of the most recent log entries.
+	LogTerms       []uint64 // Terms of the most recent log entries.
+	LogBytes       []int    // Binary sizes of the most recent log entries.
+	LogCacheHits   uint64   // Number of log reads served from the in-memory cache.
+	LogCacheMisses uint64   // Number of log reads that fell through to the log storage.
+}
+
+// getStatusMsg represents a message to retrieve the status.
+type getStatusMsg struct {
+	ch chan<- *Status
+}
+
+// GetStatus returns the Raft status of this server.
+func (sm *StateMachine) GetStatus(ctx context.Context) (*Status, error) {
+	ch := make(chan *Status, 1)
+	if err := sm.enqueue(ctx, getStatusMsg{ch: ch}); err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case status := <-ch:
+		return status, nil
+	}
+}
+
+// GetSnapshot returns a snapshot of the internal state of this server.
+func (sm *StateMachine) GetSnapshot(ctx context.Context) (*Snapshot, error) {
+	ch := make(chan *Snapshot, 1)
+	if err := sm.enqueue(ctx, getSnapshotMsg{ch: ch}); err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case resp := <-ch:
+		return resp, nil
+	}
+}
+
+func (sm *StateMachine) handleGetStatus() (*Status, error) {
+	currentTerm, err := sm.stable.GetCurrentTerm()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current term: %w", err)
+	}
+	firstLogIndex, err := sm.logs.FirstLogIndex()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get first log index: %w", err)
+	}
+	lastLogIndex, err := sm.logs.LastLogIndex()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last log index: %w", err)
+	}
+	lastLogTerm, err := sm.logs.LastLogTerm()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last log term: %w", err)
+	}
+	status := &Status{
+		Server:        sm.cluster.LocalServerName,
+		Role:          sm.role,
+		Term:          currentTerm,
+		Leader:        sm.currentLeader,
+		CommitIndex:   sm.commitIndex,
+		LastApplied:   sm.lastApplied,
+		FirstLogIndex: firstLogIndex,
+		LastLogIndex:  lastLogIndex,
+		LastLogTerm:   lastLogTerm,
+	}
+	if sm.role == konsen.Role_LEADER {
+		status.Followers = make(map[string]*FollowerStatus)
+		for server := range sm.clients {
+			f := &FollowerStatus{NextIndex: sm.nextIndex[server], MatchIndex: sm.matchIndex[server]}
+			if f.MatchIndex < lastLogIndex {
+				f.Lag = lastLogIndex - f.MatchIndex
+			}
+			status.Followers[server] = f
+		}
+	}
+	if sm.isCorrupted() {
+		status.Corruption = sm.corruption.Error()
+	}
+	return status, nil
+}
+
+func (sm *StateMachine) handleGetSnapshot() (*Snapshot, error) {
+	status, err := sm.handleGetStatus()
+	if err != nil {
+		return nil, err
+	}
+	votedFor, err := sm.stable.GetVotedFor()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get voted for: %w", err)
+	}
+	snapshot := &Snapshot{Status: *status, VotedFor: votedFor}
+
+	from := status.FirstLogIndex
+	if status.LastLogIndex >= maxSnapshotLogs && status.LastLogIndex-maxSnapshotLogs+1 > from {
+		from = status.LastLogIndex - maxSnapshotLogs + 1
+	}
+	if from == 0 {
+		from = 1
+	}
+	logs, err := sm.logs.GetLogsFrom(from)
+	if err != nil {
+		if !errors.Is(err, store.ErrCorruptedLog) {
+			return nil, fmt.Errorf("failed to get logs: %w", err)
+		}
+		// Report the corruption in the snapshot rather than failing it.
+		sm.handleError(err)
+		snapshot.Corruption = sm.corruption.Error()
+	}
+	for _, e := range logs {
+		snapshot.LogIndices = append(snapshot.LogIndices, e.GetIndex())
+		snapshot.LogTerms = append(snapshot.LogTerms, e.GetTerm())
+		snapshot.LogBytes = append(snapshot.LogBytes, len(e.GetData()))
+	}
+	if sm.logCache != nil {
+		snapshot.LogCacheHits, snapshot.LogCacheMisses = sm.logCache.Stats()
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lizhaoliu/konsen/v2/testcluster"
+	"github.com/sirupsen/logrus"
+)
+
+const devUsage = `Usage: konsen dev [flags]
+
+Starts a cluster of nodes in this process on localhost, and reads commands from stdin:
+
+  status                           Print the status of each node.
+  kill <node>                      Stop a node, which keeps its data.
+  restart <node>                   Start a killed node.
+  partition <node,...> <node,...>  Disconnect nodes in different groups, nodes not in any group stay connected.
+  isolate <node>                   Disconnect a node from all others.
+  heal                             Reconnect all nodes.
+  quit                             Stop all nodes and exit.
+
+Flags:
+`
+
+const devCommands = `Commands: status, kill <node>, restart <node>, partition <node,...> <node,...>, isolate <node>, heal, quit.`
+
+// runDev runs the "dev" subcommand with given arguments.
+func runDev(args []string) {
+	fs := flag.NewFlagSet("dev", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), devUsage)
+		fs.PrintDefaults()
+	}
+	nodes := fs.Int("nodes", 3, "Number of nodes.")
+	raftBasePort := fs.Int("raft_base_port", 11001, "gRPC port of the first node, the others use the following ports. 0 picks free ports.")
+	httpBasePort := fs.Int("http_base_port", 21001, "HTTP port of the first node, the others use the following ports. 0 picks free ports.")
+	dir := fs.String("dir", "", "Directory of the nodes' data, which is kept. Defaults to a temporary directory that is removed on exit.")
+	level := fs.String("log_level", "warn", "Log level, one of: debug, info, warn, error.")
+	fs.Parse(args)
+
+	if err := configureLogging(*level, "text"); err != nil {
+		logrus.Fatalf("Invalid logging flags: %v", err)
+	}
+	cluster, err := testcluster.NewCluster(testcluster.ClusterConfig{
+		Nodes:        *nodes,
+		Dir:          *dir,
+		RaftBasePort: *raftBasePort,
+		HTTPBasePort: *httpBasePort,
+	})
+	if err != nil {
+		logrus.Fatalf("Failed to create cluster: %v", err)
+	}
+	defer cluster.Close()
+
+	ctx := context.Background()
+	if err := cluster.Start(ctx); err != nil {
+		cluster.Close()
+		logrus.Fatalf("%v", err)
+	}
+	fmt.Printf("Started %d nodes, data in %s.\n", len(cluster.Names()), cluster.Dir())
+	fmt.Printf("HTTP endpoints: %s\n", strings.Join(cluster.HTTPEndpoints(), ","))
+	fmt.Printf("Try: konsenctl --endpoints %s status\n", strings.Join(cluster.HTTPEndpoints(), ","))
+	fmt.Println(devCommands)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for fmt.Print("> "); scanner.Scan(); fmt.Print("> ") {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "quit" || fields[0] == "exit" {
+			break
+		}
+		if err := runDevCommand(ctx, cluster, fields); err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
+	}
+	fmt.Println("Stopping nodes.")
+}
+
+// runDevCommand runs a command read by the dev mode.
+func runDevCommand(ctx context.Context, cluster *testcluster.Cluster, fields []string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cmd, args := fields[0], fields[1:]
+	switch {
+	case cmd == "status" && len(args) == 0:
+		return printDevStatus(ctx, os.Stdout, cluster)
+	case cmd == "kill" && len(args) == 1:
+		return cluster.Kill(ctx, args[0])
+	case cmd == "restart" && len(args) == 1:
+		return cluster.Restart(ctx, args[0])
+	case cmd == "partition" && len(args) > 0:
+		groups := make([][]string, len(args))
+		for i, arg := range args {
+			groups[i] = strings.Split(arg, ",")
+		}
+		return cluster.Partition(groups...)
+	case cmd == "isolate" && len(args) == 1:
+		return cluster.Isolate(args[0])
+	case cmd == "heal" && len(args) == 0:
+		cluster.Heal()
+		return nil
+	default:
+		return fmt.Errorf("invalid command %q. %s", strings.Join(fields, " "), devCommands)
+	}
+}
+
+func printDevStatus(ctx context.Context, out io.Writer, cluster *testcluster.Cluster) error {
+	statuses := cluster.Status(ctx)
+	endpoints := cluster.HTTPEndpoints()
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tHTTP\tROLE\tTERM\tLEADER\tCOMMIT\tAPPLIED\tUNREACHABLE")
+	for i, name := range cluster.Names() {
+		var unreachable []string
+		for _, other := range cluster.Names() {
+			if other != name && !cluster.Connected(name, other) {
+				unreachable = append(unreachable, other)
+			}
+		}
+		status, ok := statuses[name]
+		if !ok {
+			fmt.Fprintf(w, "%s\t%s\tDOWN\t\t\t\t\t%s\n", name, endpoints[i], strings.Join(unreachable, ","))
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%d\t%d\t%s\n", name, endpoints[i], status.Role, status.Term, status.Leader,
+			status.CommitIndex, status.LastApplied, strings.Join(unreachable, ","))
+	}
+	return w.Flush()
+}
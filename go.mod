@@ -6,12 +6,16 @@ require (
 	github.com/boltdb/bolt v1.3.1
 	github.com/dgraph-io/badger/v2 v2.0.3
 	github.com/gin-gonic/gin v1.6.2
-	github.com/golang/protobuf v1.4.0
-	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
-	github.com/modern-go/reflect2 v1.0.1 // indirect
+	github.com/golang/protobuf v1.5.0
+	github.com/prometheus/client_golang v1.7.1
 	github.com/sirupsen/logrus v1.5.0
-	golang.org/x/sys v0.0.0-20200430202703-d923437fa56d // indirect
-	google.golang.org/grpc v1.29.0
-	google.golang.org/protobuf v1.21.0
+	go.opentelemetry.io/otel v0.20.0
+	go.opentelemetry.io/otel/exporters/otlp v0.20.0
+	go.opentelemetry.io/otel/exporters/stdout v0.20.0
+	go.opentelemetry.io/otel/sdk v0.20.0
+	go.opentelemetry.io/otel/trace v0.20.0
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
+	google.golang.org/grpc v1.37.0
+	google.golang.org/protobuf v1.26.0
 	gopkg.in/yaml.v2 v2.2.8
 )
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/lizhaoliu/konsen/v2/core"
+	"github.com/lizhaoliu/konsen/v2/security"
+	"gopkg.in/yaml.v2"
+)
+
+const initUsage = `Usage: konsen init [flags]
+
+Generates a deployment directory for each node of the cluster described by the spec, a node configuration file
+without localServerName (a cluster configuration file also works):
+
+  <output>/
+    cluster.yml               Cluster configuration for clients such as konsenctl.
+    tls/                      CA and client certificate, with --tls.
+    <node>/
+      node.yml                Node configuration.
+      konsen                  This binary.
+      bootstrap.sh            Starts the node.
+      konsen-<node>.service   systemd unit, with --systemd.
+      tls/                    CA, node certificate and key, with --tls.
+      <storage.dir>/          Data directory.
+
+Flags:
+`
+
+// initFlags are flags of the init command.
+type initFlags struct {
+	spec          string
+	output        string
+	force         bool
+	tls           bool
+	certValidity  time.Duration
+	listenHost    string
+	pprofBasePort int
+	systemd       bool
+	installDir    string
+	binary        string
+}
+
+// initNode is a node to generate the directory of.
+type initNode struct {
+	name   string
+	config *core.NodeConfig
+}
+
+// systemdUnit is the template of systemd units, with the node name and its installation directory.
+var systemdUnit = template.Must(template.New("unit").Parse(`[Unit]
+Description=konsen node {{.Name}}
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+WorkingDirectory={{.Dir}}
+ExecStart={{.Dir}}/konsen --config node.yml
+Restart=on-failure
+LimitNOFILE=65536
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+const bootstrapScript = `#!/bin/bash
+
+set -e
+
+cd "$(dirname "$0")"
+exec ./konsen --config node.yml "$@"
+`
+
+// runInit runs the "init" subcommand with given arguments.
+func runInit(args []string) {
+	var f initFlags
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), initUsage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&f.spec, "spec", "conf/cluster.yml", "Cluster spec file path.")
+	fs.StringVar(&f.output, "output", "output", "Output directory, which must not exist unless --force is set.")
+	fs.BoolVar(&f.force, "force", false, "Replace the output directory if it exists.")
+	fs.BoolVar(&f.tls, "tls", false, "Generate a self-signed CA and certificates, and enable mutual TLS. Meant for development, the CA key is kept in the output directory.")
+	fs.DurationVar(&f.certValidity, "cert_validity", 365*24*time.Hour, "Validity of generated certificates.")
+	fs.StringVar(&f.listenHost, "listen_host", "", "Host that nodes listen on (e.g. 0.0.0.0) with the ports of their advertised addresses, defaults to the advertised addresses.")
+	fs.IntVar(&f.pprofBasePort, "pprof_base_port", 0, "If set, the i-th node (by name) serves pprof on localhost at this port + i, otherwise pprof is disabled.")
+	fs.BoolVar(&f.systemd, "systemd", false, "Generate a systemd unit for each node.")
+	fs.StringVar(&f.installDir, "install_dir", "", "Directory that node directories are deployed to, used in systemd units. Defaults to the output directory.")
+	fs.StringVar(&f.binary, "binary", "", "konsen binary to copy to each node, defaults to this binary.")
+	fs.Parse(args)
+
+	if err := initCluster(&f); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func initCluster(f *initFlags) error {
+	nodes, err := initNodeConfigs(f)
+	if err != nil {
+		return err
+	}
+	if err := checkPortConflicts(nodes); err != nil {
+		return err
+	}
+
+	if f.binary == "" {
+		if f.binary, err = os.Executable(); err != nil {
+			return fmt.Errorf("failed to locate konsen binary: %v", err)
+		}
+	}
+	if f.installDir == "" {
+		f.installDir = f.output
+	}
+	if f.installDir, err = filepath.Abs(f.installDir); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(f.output); err == nil {
+		if !f.force {
+			return fmt.Errorf("output directory %q exists, use --force to replace it", f.output)
+		}
+		if err := os.RemoveAll(f.output); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(f.output, 0755); err != nil {
+		return err
+	}
+
+	var ca *security.CA
+	if f.tls {
+		if ca, err = initCA(f); err != nil {
+			return err
+		}
+	}
+	for _, node := range nodes {
+		if err := initNodeDir(f, node, ca); err != nil {
+			return fmt.Errorf("%s: %v", node.name, err)
+		}
+		fmt.Printf("Generated %s\n", filepath.Join(f.output, node.name))
+	}
+	if err := initClientConfig(f, nodes[0].config, ca != nil); err != nil {
+		return err
+	}
+	fmt.Printf("Generated %s\n", filepath.Join(f.output, "cluster.yml"))
+	return nil
+}
+
+// initNodeConfigs returns the validated configuration of each node, sorted by name.
+func initNodeConfigs(f *initFlags) ([]initNode, error) {
+	spec, err := core.LoadNodeConfig(f.spec)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Version == 0 {
+		// Cluster configuration files have no version.
+		spec.Version = core.NodeConfigVersion
+	}
+	// These are per node, and filled in below.
+	for _, field := range []struct{ name, value string }{
+		{"localServerName", spec.LocalServerName},
+		{"advertise.raft", spec.Advertise.Raft},
+		{"advertise.http", spec.Advertise.HTTP},
+		{"listen.raft", spec.Listen.Raft},
+		{"listen.http", spec.Listen.HTTP},
+	} {
+		if field.value != "" {
+			return nil, fmt.Errorf("%s: %s must not be set in a cluster spec", f.spec, field.name)
+		}
+	}
+	if spec.TLS != nil && f.tls {
+		return nil, fmt.Errorf("%s: tls must not be set in a cluster spec with --tls", f.spec)
+	}
+	if len(spec.Servers) == 0 {
+		return nil, fmt.Errorf("%s: servers: no server is defined", f.spec)
+	}
+
+	names := make([]string, 0, len(spec.Servers))
+	for name := range spec.Servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	nodes := make([]initNode, len(names))
+	for i, name := range names {
+		config := *spec
+		config.LocalServerName = name
+		config.Listen.Pprof = ""
+		if f.pprofBasePort > 0 {
+			config.Listen.Pprof = net.JoinHostPort("localhost", strconv.Itoa(f.pprofBasePort+i))
+		}
+		if f.listenHost != "" {
+			config.Listen.Raft = withHost(config.Servers[name], f.listenHost)
+			config.Listen.HTTP = withHost(config.HttpServers[name], f.listenHost)
+		}
+		if err := config.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: node %s: %v", f.spec, name, err)
+		}
+		nodes[i] = initNode{name: name, config: &config}
+	}
+	return nodes, nil
+}
+
+// withHost replaces the host of an address, addresses without a port are returned as is to fail validation.
+func withHost(address string, host string) string {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// checkPortConflicts checks that no two servers listen on the same port of a machine, where nodes with the same
+// advertised Raft host are on the same machine.
+func checkPortConflicts(nodes []initNode) error {
+	var conflicts []string
+	used := make(map[string]string) // "machine port" -> "node field".
+	for _, node := range nodes {
+		machine, _, _ := net.SplitHostPort(node.config.Servers[node.name])
+		listen := node.config.Listen
+		for _, bind := range []struct{ field, address string }{
+			{"listen.raft", listen.Raft},
+			{"listen.http", listen.HTTP},
+			{"listen.pprof", listen.Pprof},
+		} {
+			if bind.address == "" {
+				continue
+			}
+			_, port, _ := net.SplitHostPort(bind.address)
+			key := machine + " " + port
+			owner := node.name + " " + bind.field
+			if other, ok := used[key]; ok {
+				conflicts = append(conflicts, fmt.Sprintf("port %s on %s is used by both %s and %s", port, machine, other, owner))
+				continue
+			}
+			used[key] = owner
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("port conflicts:\n  %s", strings.Join(conflicts, "\n  "))
+	}
+	return nil
+}
+
+// initCA generates the CA and a client certificate in the tls directory of the output.
+func initCA(f *initFlags) (*security.CA, error) {
+	ca, err := security.GenerateCA("konsen CA", f.certValidity)
+	if err != nil {
+		return nil, err
+	}
+	certPEM, keyPEM, err := ca.Issue("konsenctl", nil, f.certValidity)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(f.output, "tls")
+	return ca, writeFiles(dir, []outputFile{
+		{"ca.pem", ca.CertPEM(), 0644},
+		{"ca-key.pem", ca.KeyPEM(), 0600},
+		{"client.pem", certPEM, 0644},
+		{"client-key.pem", keyPEM, 0600},
+	})
+}
+
+// initNodeDir generates the directory of a node.
+func initNodeDir(f *initFlags, node initNode, ca *security.CA) error {
+	dir := filepath.Join(f.output, node.name)
+	config := node.config
+	var files []outputFile
+
+	if ca != nil {
+		certPEM, keyPEM, err := ca.Issue(node.name, certHosts(config), f.certValidity)
+		if err != nil {
+			return err
+		}
+		files = append(files,
+			outputFile{"tls/ca.pem", ca.CertPEM(), 0644},
+			outputFile{"tls/node.pem", certPEM, 0644},
+			outputFile{"tls/node-key.pem", keyPEM, 0600})
+		config.TLS = &core.TLSConfig{
+			CAFile:     "tls/ca.pem",
+			CertFile:   "tls/node.pem",
+			KeyFile:    "tls/node-key.pem",
+			ClientAuth: true,
+		}
+	}
+
+	buf, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	header := fmt.Sprintf("# Node configuration of %s, generated by \"konsen init\". Paths are relative to this directory.\n", node.name)
+	files = append(files,
+		outputFile{"node.yml", append([]byte(header), buf...), 0644},
+		outputFile{"bootstrap.sh", []byte(bootstrapScript), 0755})
+
+	if f.systemd {
+		var unit strings.Builder
+		data := struct{ Name, Dir string }{node.name, filepath.Join(f.installDir, node.name)}
+		if err := systemdUnit.Execute(&unit, data); err != nil {
+			return err
+		}
+		files = append(files, outputFile{"konsen-" + node.name + ".service", []byte(unit.String()), 0644})
+	}
+
+	if err := writeFiles(dir, files); err != nil {
+		return err
+	}
+	if !filepath.IsAbs(config.Storage.Dir) {
+		if err := os.MkdirAll(filepath.Join(dir, config.Storage.Dir), 0755); err != nil {
+			return err
+		}
+	}
+	return copyFile(f.binary, filepath.Join(dir, "konsen"), 0755)
+}
+
+// certHosts returns the hosts that the certificate of a node must be valid for: the hosts of its advertised and
+// listen addresses.
+func certHosts(config *core.NodeConfig) []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, address := range []string{
+		config.Servers[config.LocalServerName],
+		config.HttpServers[config.LocalServerName],
+		config.Listen.Raft,
+		config.Listen.HTTP,
+	} {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil || host == "" || seen[host] {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+			continue
+		}
+		seen[host] = true
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// initClientConfig writes the cluster configuration for clients, with absolute paths of the client certificate.
+func initClientConfig(f *initFlags, config *core.NodeConfig, withTLS bool) error {
+	cluster := core.ClusterConfig{Servers: config.Servers, HttpServers: config.HttpServers}
+	if withTLS {
+		dir, err := filepath.Abs(filepath.Join(f.output, "tls"))
+		if err != nil {
+			return err
+		}
+		cluster.TLS = &core.TLSConfig{
+			CAFile:   filepath.Join(dir, "ca.pem"),
+			CertFile: filepath.Join(dir, "client.pem"),
+			KeyFile:  filepath.Join(dir, "client-key.pem"),
+		}
+	}
+	buf, err := yaml.Marshal(cluster)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(f.output, "cluster.yml"), buf, 0644)
+}
+
+// outputFile is a file to write, with a path relative to the directory it is written to.
+type outputFile struct {
+	path    string
+	content []byte
+	mode    os.FileMode
+}
+
+func writeFiles(dir string, files []outputFile) error {
+	for _, file := range files {
+		path := filepath.Join(dir, file.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, file.content, file.mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src string, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/lizhaoliu/konsen/v2/core"
+	"github.com/lizhaoliu/konsen/v2/node"
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+	"github.com/lizhaoliu/konsen/v2/store"
+	"github.com/sirupsen/logrus"
+)
+
+const inspectUsage = `Usage: konsen inspect <command> [flags]
+
+Opens the storage of a stopped node read-only and prints its content.
+
+Commands:
+  state  Print current term, voted for and log index range.
+  logs   Print log entries in an index range, with decoded key-value payloads.
+  kv     Print key-value pairs by key or key prefix.
+
+Run "konsen inspect <command> -h" for flags of a command.
+`
+
+// inspectState is the Raft persistent state of a node.
+type inspectState struct {
+	CurrentTerm   uint64 `json:"currentTerm"`
+	VotedFor      string `json:"votedFor"`
+	FirstLogIndex uint64 `json:"firstLogIndex"`
+	LastLogIndex  uint64 `json:"lastLogIndex"`
+	LastLogTerm   uint64 `json:"lastLogTerm"`
+	AppliedIndex  uint64 `json:"appliedIndex"` // Index of the latest log entry applied to the key-value store.
+}
+
+// inspectLog is a decoded log entry.
+type inspectLog struct {
+	Index       uint64      `json:"index"`
+	Term        uint64      `json:"term"`
+	Type        string      `json:"type"`
+	Bytes       int         `json:"bytes"`
+	Checksum    uint32      `json:"checksum"`
+	ChecksumOK  bool        `json:"checksumOk"`
+	KVs         []inspectKV `json:"kvs,omitempty"`
+	DecodeError string      `json:"decodeError,omitempty"`
+}
+
+// inspectKV is a key-value pair, keys and values are base64 encoded in JSON output.
+type inspectKV struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// inspectFlags are flags shared by all inspect commands.
+type inspectFlags struct {
+	dbDir         string
+	storageEngine string
+	output        string
+}
+
+func (f *inspectFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.dbDir, "db_dir", "db", "Local database directory path of the node.")
+	fs.StringVar(&f.storageEngine, "storage_engine", "badger", "Local storage engine of the node, one of: badger, boltdb, wal.")
+	fs.StringVar(&f.output, "output", "text", "Output format, one of: text, json.")
+}
+
+func (f *inspectFlags) validate() error {
+	if f.output != "text" && f.output != "json" {
+		return fmt.Errorf("unknown output format %q", f.output)
+	}
+	return nil
+}
+
+// runInspect runs the "inspect" subcommand with given arguments.
+func runInspect(args []string) {
+	// Keep stdout clean for the output.
+	logrus.SetOutput(os.Stderr)
+	logrus.SetLevel(logrus.WarnLevel)
+
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		fmt.Fprint(os.Stderr, inspectUsage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch args[0] {
+	case "state":
+		err = inspectStateCmd(args[1:])
+	case "logs":
+		err = inspectLogsCmd(args[1:])
+	case "kv":
+		err = inspectKVCmd(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown inspect command %q.\n\n%s", args[0], inspectUsage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// openInspectStorage opens the storage read-only.
+func openInspectStorage(f *inspectFlags) (store.Storage, error) {
+	if _, err := os.Stat(f.dbDir); err != nil {
+		return nil, err
+	}
+	storage, err := node.OpenStorage(core.StorageConfig{Engine: f.storageEngine, Dir: f.dbDir}, true, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage (is the node stopped?): %v", err)
+	}
+	return storage, nil
+}
+
+func inspectStateCmd(args []string) error {
+	var f inspectFlags
+	fs := flag.NewFlagSet("inspect state", flag.ExitOnError)
+	f.register(fs)
+	fs.Parse(args)
+	if err := f.validate(); err != nil {
+		return err
+	}
+
+	storage, err := openInspectStorage(&f)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	var state inspectState
+	if state.CurrentTerm, err = storage.GetCurrentTerm(); err != nil {
+		return fmt.Errorf("failed to get current term: %v", err)
+	}
+	if state.VotedFor, err = storage.GetVotedFor(); err != nil {
+		return fmt.Errorf("failed to get voted for: %v", err)
+	}
+	if state.FirstLogIndex, err = storage.FirstLogIndex(); err != nil {
+		return fmt.Errorf("failed to get first log index: %v", err)
+	}
+	if state.LastLogIndex, err = storage.LastLogIndex(); err != nil {
+		return fmt.Errorf("failed to get last log index: %v", err)
+	}
+	if state.LastLogTerm, err = storage.LastLogTerm(); err != nil {
+		return fmt.Errorf("failed to get last log term: %v", err)
+	}
+	if state.AppliedIndex, err = storage.AppliedIndex(); err != nil {
+		return fmt.Errorf("failed to get applied index: %v", err)
+	}
+
+	if f.output == "json" {
+		return writeJSON(os.Stdout, state)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Current term:\t%d\n", state.CurrentTerm)
+	fmt.Fprintf(w, "Voted for:\t%q\n", state.VotedFor)
+	fmt.Fprintf(w, "First log index:\t%d\n", state.FirstLogIndex)
+	fmt.Fprintf(w, "Last log index:\t%d\n", state.LastLogIndex)
+	fmt.Fprintf(w, "Last log term:\t%d\n", state.LastLogTerm)
+	fmt.Fprintf(w, "Applied index:\t%d\n", state.AppliedIndex)
+	return w.Flush()
+}
+
+func inspectLogsCmd(args []string) error {
+	var f inspectFlags
+	var from, to uint64
+	fs := flag.NewFlagSet("inspect logs", flag.ExitOnError)
+	f.register(fs)
+	fs.Uint64Var(&from, "from", 0, "Index of the first log entry to print, defaults to the first log entry.")
+	fs.Uint64Var(&to, "to", 0, "Index of the last log entry to print, defaults to the last log entry.")
+	fs.Parse(args)
+	if err := f.validate(); err != nil {
+		return err
+	}
+
+	storage, err := openInspectStorage(&f)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	firstIndex, err := storage.FirstLogIndex()
+	if err != nil {
+		return fmt.Errorf("failed to get first log index: %v", err)
+	}
+	lastIndex, err := storage.LastLogIndex()
+	if err != nil {
+		return fmt.Errorf("failed to get last log index: %v", err)
+	}
+	if from < firstIndex {
+		from = firstIndex
+	}
+	if to == 0 || to > lastIndex {
+		to = lastIndex
+	}
+
+	var logs []inspectLog
+	for i := from; i <= to && i > 0; i++ {
+		entry, err := storage.GetLog(i)
+		if err != nil {
+			return fmt.Errorf("failed to get log at index %d: %v", i, err)
+		}
+		if entry == nil {
+			continue
+		}
+		logs = append(logs, decodeInspectLog(entry))
+	}
+
+	if f.output == "json" {
+		return writeJSON(os.Stdout, logs)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "INDEX\tTERM\tTYPE\tBYTES\tCHECKSUM\tDATA")
+	for _, l := range logs {
+		checksum := "ok"
+		if !l.ChecksumOK {
+			checksum = "MISMATCH"
+		} else if l.Checksum == 0 {
+			checksum = "none"
+		}
+		data := formatInspectKVs(l.KVs)
+		if l.DecodeError != "" {
+			data = "<" + l.DecodeError + ">"
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%d\t%s\t%s\n", l.Index, l.Term, l.Type, l.Bytes, checksum, data)
+	}
+	return w.Flush()
+}
+
+func decodeInspectLog(entry *konsen.Log) inspectLog {
+	l := inspectLog{
+		Index:      entry.GetIndex(),
+		Term:       entry.GetTerm(),
+		Type:       entry.GetType().String(),
+		Bytes:      len(entry.GetData()),
+		Checksum:   entry.GetChecksum(),
+		ChecksumOK: store.VerifyLogChecksum(entry) == nil,
+	}
+	if entry.GetType() == konsen.LogType_DATA {
+		kvList := &konsen.KVList{}
+		if err := proto.Unmarshal(entry.GetData(), kvList); err != nil {
+			l.DecodeError = err.Error()
+		}
+		for _, kv := range kvList.GetKvList() {
+			l.KVs = append(l.KVs, inspectKV{Key: kv.GetKey(), Value: kv.GetValue()})
+		}
+	}
+	return l
+}
+
+func formatInspectKVs(kvs []inspectKV) string {
+	parts := make([]string, len(kvs))
+	for i, kv := range kvs {
+		parts[i] = fmt.Sprintf("%q=%q", kv.Key, kv.Value)
+	}
+	return strings.Join(parts, " ")
+}
+
+func inspectKVCmd(args []string) error {
+	var f inspectFlags
+	var key, prefix string
+	var limit int
+	fs := flag.NewFlagSet("inspect kv", flag.ExitOnError)
+	f.register(fs)
+	fs.StringVar(&key, "key", "", "Key to print.")
+	fs.StringVar(&prefix, "prefix", "", "Print all keys with this prefix, an empty prefix matches all keys.")
+	fs.IntVar(&limit, "limit", 0, "Maximum number of key-value pairs to print, 0 means no limit.")
+	fs.Parse(args)
+	if err := f.validate(); err != nil {
+		return err
+	}
+
+	storage, err := openInspectStorage(&f)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	var kvs []inspectKV
+	if key != "" {
+		value, err := storage.GetValue([]byte(key))
+		if err != nil {
+			return fmt.Errorf("failed to get value of %q: %v", key, err)
+		}
+		if value != nil {
+			kvs = append(kvs, inspectKV{Key: []byte(key), Value: value})
+		}
+	} else {
+		if err := storage.ScanValues([]byte(prefix), store.PrefixEnd([]byte(prefix)), func(k []byte, v []byte) bool {
+			kvs = append(kvs, inspectKV{Key: append([]byte(nil), k...), Value: append([]byte(nil), v...)})
+			return limit <= 0 || len(kvs) < limit
+		}); err != nil {
+			return fmt.Errorf("failed to scan values: %v", err)
+		}
+	}
+
+	if f.output == "json" {
+		return writeJSON(os.Stdout, kvs)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE")
+	for _, kv := range kvs {
+		fmt.Fprintf(w, "%q\t%q\n", kv.Key, kv.Value)
+	}
+	return w.Flush()
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
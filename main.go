@@ -8,128 +8,210 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
-	"path"
 	"syscall"
+	"time"
 
-	"github.com/gin-gonic/gin"
 	"github.com/lizhaoliu/konsen/v2/core"
-	"github.com/lizhaoliu/konsen/v2/rpc"
-	"github.com/lizhaoliu/konsen/v2/store"
-	"github.com/lizhaoliu/konsen/v2/web/httpserver"
+	"github.com/lizhaoliu/konsen/v2/node"
+	"github.com/lizhaoliu/konsen/v2/tracing"
 	"github.com/sirupsen/logrus"
 )
 
 var (
+	configPath        string
 	clusterConfigPath string
 	dbDir             string
+	storageEngine     string
+	logCacheSize      int
+
+	consistencyCheckInterval time.Duration
+
+	maxPendingProposals     int
+	maxPendingProposalBytes int64
+
+	traceExporter    string
+	otlpEndpoint     string
+	traceSampleRatio float64
+
+	logLevel  string
+	logFormat string
 )
 
 func init() {
-	flag.StringVar(&clusterConfigPath, "cluster_config_path", "", "Cluster configuration file path.")
-	flag.StringVar(&dbDir, "db_dir", "db", "Local database directory path.")
-	flag.Parse()
-
-	if clusterConfigPath == "" {
-		logrus.Fatalf("cluster_config_path is unspecified.")
-	}
-	if dbDir == "" {
-		logrus.Fatalf("db_dir is unspecified.")
-	}
+	defaults := core.DefaultNodeConfig()
+	flag.StringVar(&configPath, "config", "", "Node configuration file path.")
+	flag.StringVar(&clusterConfigPath, "cluster_config_path", "", "Cluster configuration file path, for nodes without a node configuration file.")
+	flag.StringVar(&dbDir, "db_dir", defaults.Storage.Dir, "Local database directory path.")
+	flag.StringVar(&storageEngine, "storage_engine", defaults.Storage.Engine, "Local storage engine, one of: badger, boltdb, wal.")
+	flag.IntVar(&logCacheSize, "log_cache_size", defaults.Storage.LogCacheSize, "Number of most recent log entries cached in memory, 0 disables the cache.")
+	flag.DurationVar(&consistencyCheckInterval, "consistency_check_interval", time.Duration(defaults.Raft.ConsistencyCheckInterval), "Interval between cross-replica state consistency checks, 0 disables the checks.")
+	flag.IntVar(&maxPendingProposals, "max_pending_proposals", defaults.Limits.MaxPendingProposals, "Maximum number of pending proposals on this server, more are rejected as overloaded, 0 means unlimited.")
+	flag.Int64Var(&maxPendingProposalBytes, "max_pending_proposal_bytes", defaults.Limits.MaxPendingProposalBytes, "Maximum total data size of pending proposals on this server, 0 means unlimited.")
+	flag.StringVar(&traceExporter, "trace_exporter", defaults.Tracing.Exporter, "Exporter of OpenTelemetry spans, one of: none, stdout, otlp.")
+	flag.StringVar(&otlpEndpoint, "otlp_endpoint", defaults.Tracing.OTLPEndpoint, "Endpoint of the OTLP collector (gRPC), used with --trace_exporter=otlp.")
+	flag.Float64Var(&traceSampleRatio, "trace_sample_ratio", defaults.Tracing.SampleRatio, "Fraction of client requests to trace.")
+	flag.StringVar(&logLevel, "log_level", defaults.Logging.Level, "Log level, one of: debug, info, warn, error.")
+	flag.StringVar(&logFormat, "log_format", defaults.Logging.Format, "Log format, one of: text, json.")
 
 	logrus.SetOutput(os.Stdout)
 	logrus.SetFormatter(&logrus.TextFormatter{
 		FullTimestamp: true,
 	})
 	logrus.SetLevel(logrus.InfoLevel)
-
-	gin.SetMode(gin.ReleaseMode)
 }
 
-func createClients(cluster *core.ClusterConfig) (map[string]core.RaftService, error) {
-	clients := make(map[string]core.RaftService)
-	for server, endpoint := range cluster.Servers {
-		if server != cluster.LocalServerName {
-			c, err := rpc.NewRaftGRPCClient(rpc.RaftGRPCClientConfig{
-				Endpoint: endpoint,
-			})
-			if err != nil {
-				return nil, fmt.Errorf("failed to create GRPC client: %v", err)
-			}
-			clients[server] = c
-		}
+// configureLogging sets the level and format of the standard logger.
+func configureLogging(level string, format string) error {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	logrus.SetLevel(lvl)
+	switch format {
+	case "text":
+		logrus.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q", format)
 	}
-	return clients, nil
+	return nil
 }
 
-func main() {
-	ctx := context.Background()
-
-	cluster, err := core.ParseClusterConfig(clusterConfigPath)
-	if err != nil {
-		logrus.Fatalf("%v", err)
+// loadNodeConfig returns the validated node configuration, from the node configuration file (or the cluster
+// configuration file and defaults), overridden by environment variables and then by flags that are explicitly set.
+func loadNodeConfig() (*core.NodeConfig, error) {
+	var config *core.NodeConfig
+	switch {
+	case configPath != "" && clusterConfigPath != "":
+		return nil, fmt.Errorf("only one of --config and --cluster_config_path can be specified")
+	case configPath != "":
+		var err error
+		if config, err = core.LoadNodeConfig(configPath); err != nil {
+			return nil, err
+		}
+	case clusterConfigPath != "":
+		cluster, err := core.LoadClusterConfig(clusterConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		config = core.DefaultNodeConfig()
+		config.ClusterConfig = *cluster
+	default:
+		return nil, fmt.Errorf("either --config or --cluster_config_path must be specified")
 	}
 
-	if err := os.MkdirAll(dbDir, 0755); err != nil {
-		logrus.Fatalf("Failed to create dir: %v", err)
+	if err := config.ApplyEnv(os.LookupEnv); err != nil {
+		return nil, err
 	}
-	storage, err := store.NewBadger(store.BadgerConfig{
-		LogDir:   path.Join(dbDir, "logs"),
-		StateDir: path.Join(dbDir, "state"),
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "db_dir":
+			config.Storage.Dir = dbDir
+		case "storage_engine":
+			config.Storage.Engine = storageEngine
+		case "log_cache_size":
+			config.Storage.LogCacheSize = logCacheSize
+		case "consistency_check_interval":
+			config.Raft.ConsistencyCheckInterval = core.Duration(consistencyCheckInterval)
+		case "max_pending_proposals":
+			config.Limits.MaxPendingProposals = maxPendingProposals
+		case "max_pending_proposal_bytes":
+			config.Limits.MaxPendingProposalBytes = maxPendingProposalBytes
+		case "trace_exporter":
+			config.Tracing.Exporter = traceExporter
+		case "otlp_endpoint":
+			config.Tracing.OTLPEndpoint = otlpEndpoint
+		case "trace_sample_ratio":
+			config.Tracing.SampleRatio = traceSampleRatio
+		case "log_level":
+			config.Logging.Level = logLevel
+		case "log_format":
+			config.Logging.Format = logFormat
+		}
 	})
-	if err != nil {
-		logrus.Fatalf("%v", err)
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "inspect":
+			runInspect(os.Args[2:])
+			return
+		case "init":
+			runInit(os.Args[2:])
+			return
+		case "dev":
+			runDev(os.Args[2:])
+			return
+		case "bench":
+			runBench(os.Args[2:])
+			return
+		}
 	}
 
-	clients, err := createClients(cluster)
+	flag.Parse()
+	config, err := loadNodeConfig()
 	if err != nil {
-		logrus.Fatalf("%v", err)
+		// Printed as is, so that each validation error is on its own line.
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if err := configureLogging(config.Logging.Level, config.Logging.Format); err != nil {
+		logrus.Fatalf("Invalid logging config: %v", err)
 	}
 
-	sm, err := core.NewStateMachine(core.StateMachineConfig{
-		Storage: storage,
-		Cluster: cluster,
-		Clients: clients,
+	ctx := context.Background()
+
+	tr, err := tracing.NewTracing(tracing.TracingConfig{
+		Exporter:     config.Tracing.Exporter,
+		OTLPEndpoint: config.Tracing.OTLPEndpoint,
+		ServerName:   config.LocalServerName,
+		SampleRatio:  config.Tracing.SampleRatio,
 	})
 	if err != nil {
-		logrus.Fatalf("Failed to create state machine: %v", err)
+		logrus.Fatalf("Failed to set up tracing: %v", err)
 	}
 
-	raftServer := rpc.NewRaftGRPCServer(rpc.RaftGRPCServerConfig{
-		Endpoint:     cluster.Servers[cluster.LocalServerName],
-		StateMachine: sm,
-	})
+	n, err := node.NewNode(node.NodeConfig{
+		Config: config,
+		Logger: logrus.NewEntry(logrus.StandardLogger()),
 
-	httpSrv := httpserver.NewServer(httpserver.ServerConfig{
-		StateMachine: sm,
-		Address:      cluster.HttpServers[cluster.LocalServerName],
+		TracerProvider: tr.TracerProvider(),
 	})
-
-	go func() {
-		if err := raftServer.Serve(); err != nil {
-			logrus.Fatalf("%v", err)
-		}
-	}()
-	//
-	go func() {
-		sm.Run(ctx)
-	}()
-	go func() {
-		if err := httpSrv.Run(); err != nil {
-			logrus.Fatalf("Failed to start HTTP server: %v", err)
-		}
-	}()
+	if err != nil {
+		logrus.Fatalf("%v", err)
+	}
+	if err := n.Start(ctx); err != nil {
+		logrus.Fatalf("Failed to start node: %v", err)
+	}
 
 	// Starts pprof server.
-	go func() {
-		if err := http.ListenAndServe(":6060", nil); err != nil {
-			logrus.Errorf("Failed to start pprof server: %v", err)
-		}
-	}()
+	if config.Listen.Pprof != "" {
+		go func() {
+			if err := http.ListenAndServe(config.Listen.Pprof, nil); err != nil {
+				logrus.Errorf("Failed to start pprof server: %v", err)
+			}
+		}()
+	}
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	<-sigCh
-	sm.Close()
-	raftServer.Stop()
-	storage.Close()
+	stopCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := n.Stop(stopCtx); err != nil {
+		logrus.Errorf("Failed to stop node: %v", err)
+	}
+	if tr != nil {
+		if err := tr.Close(stopCtx); err != nil {
+			logrus.Errorf("Failed to flush spans: %v", err)
+		}
+	}
 }
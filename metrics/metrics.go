@@ -0,0 +1,136 @@
+// Package metrics defines the Prometheus metrics exported by konsen. Each server has its own Metrics, registered with
+// a "server" label in a registry of the server, so that servers running in one process (e.g. a test cluster) are
+// reported separately. Gauges of the Raft state are collected on scrape by core.StatusCollector.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "konsen"
+
+// Metrics is the set of metrics of a server.
+type Metrics struct {
+	// Elections counts elections started by this server.
+	Elections prometheus.Counter
+
+	// LeaderChanges counts leader changes observed by this server.
+	LeaderChanges prometheus.Counter
+
+	// Proposals counts proposals received by this server, including the ones forwarded from followers, by log type.
+	Proposals *prometheus.CounterVec
+
+	// PendingProposals is the number of proposals admitted by this server and not yet answered.
+	PendingProposals prometheus.Gauge
+
+	// PendingProposalBytes is the total data size of pending proposals.
+	PendingProposalBytes prometheus.Gauge
+
+	// ProposalsFailed counts proposals received by this server that failed, by error.
+	ProposalsFailed *prometheus.CounterVec
+
+	// ProposalCommitSeconds observes the time from a proposal being written to the leader's log until it is committed
+	// and applied on the leader.
+	ProposalCommitSeconds prometheus.Histogram
+
+	// ApplySeconds observes the time to apply a committed log entry to the key-value store, by log type.
+	ApplySeconds *prometheus.HistogramVec
+
+	// StorageWriteSeconds observes the time to write log entries to the log storage, including fsync.
+	StorageWriteSeconds prometheus.Histogram
+
+	// StorageFsyncSeconds observes the time of explicit fsyncs of the log storage, storage engines that fsync
+	// internally (badger, boltdb) are only covered by StorageWriteSeconds.
+	StorageFsyncSeconds prometheus.Histogram
+
+	// ConsistencyMismatches counts followers whose state hash differs from the leader's, detected by this server as
+	// leader.
+	ConsistencyMismatches prometheus.Counter
+
+	// QueueWaitSeconds observes the time a request waits to be taken by the message loop of the state machine, by
+	// queue (raft or client).
+	QueueWaitSeconds *prometheus.HistogramVec
+}
+
+// MetricsConfig
+type MetricsConfig struct {
+	Registerer prometheus.Registerer // Registry to register the metrics in, they are not registered if nil.
+	ServerName string                // Local server name, added to all metrics as label "server" if set.
+}
+
+// NewMetrics creates the metrics of a server, and registers them if a registry is given.
+func NewMetrics(config MetricsConfig) *Metrics {
+	registerer := config.Registerer
+	if registerer != nil && config.ServerName != "" {
+		registerer = prometheus.WrapRegistererWith(prometheus.Labels{"server": config.ServerName}, registerer)
+	}
+	f := promauto.With(registerer)
+	return &Metrics{
+		Elections: f.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "elections_total",
+			Help:      "Number of elections started by this server.",
+		}),
+		LeaderChanges: f.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "leader_changes_total",
+			Help:      "Number of leader changes observed by this server.",
+		}),
+		Proposals: f.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "proposals_total",
+			Help:      "Number of proposals received by this server, by log type.",
+		}, []string{"type"}),
+		PendingProposals: f.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "pending_proposals",
+			Help:      "Number of proposals admitted by this server and not yet answered.",
+		}),
+		PendingProposalBytes: f.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "pending_proposal_bytes",
+			Help:      "Total data size of proposals admitted by this server and not yet answered.",
+		}),
+		ProposalsFailed: f.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "proposals_failed_total",
+			Help:      "Number of proposals received by this server that failed, by error.",
+		}, []string{"error"}),
+		ProposalCommitSeconds: f.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "proposal_commit_seconds",
+			Help:      "Time from a proposal being written to the leader's log until it is committed and applied.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 15),
+		}),
+		ApplySeconds: f.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "apply_seconds",
+			Help:      "Time to apply a committed log entry to the key-value store, by log type.",
+			Buckets:   prometheus.ExponentialBuckets(0.00005, 2, 15),
+		}, []string{"type"}),
+		StorageWriteSeconds: f.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "storage_write_seconds",
+			Help:      "Time to write log entries to the log storage, including fsync.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 15),
+		}),
+		StorageFsyncSeconds: f.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "storage_fsync_seconds",
+			Help:      "Time of explicit fsyncs of the log storage.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 15),
+		}),
+		ConsistencyMismatches: f.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "consistency_mismatches_total",
+			Help:      "Number of follower state hashes that differ from the leader's, detected by this server as leader.",
+		}),
+		QueueWaitSeconds: f.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "queue_wait_seconds",
+			Help:      "Time a request waits to be taken by the message loop, by queue.",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 2, 20),
+		}, []string{"queue"}),
+	}
+}
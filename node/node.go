@@ -0,0 +1,426 @@
+// Package node assembles a konsen node: local storage, the state machine, clients of its peers, and the gRPC and HTTP
+// servers, and manages their lifecycle. It is how the konsen binary runs a node, and how a service embeds a
+// replicated key-value store:
+//
+//	n, err := node.NewNode(node.NodeConfig{Config: config})
+//	if err != nil {
+//		return err
+//	}
+//	if err := n.Start(ctx); err != nil {
+//		return err
+//	}
+//	defer n.Stop(ctx)
+//	revision, err := n.Propose(ctx, node.Write{Key: []byte("k"), Value: []byte("v")})
+//
+// Each node has its own Prometheus registry (see Registry) and tracer provider, so nodes running in one process are
+// reported separately.
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lizhaoliu/konsen/v2/core"
+	"github.com/lizhaoliu/konsen/v2/metrics"
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+	"github.com/lizhaoliu/konsen/v2/rpc"
+	"github.com/lizhaoliu/konsen/v2/security"
+	"github.com/lizhaoliu/konsen/v2/store"
+	"github.com/lizhaoliu/konsen/v2/web/httpserver"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+// ErrNotRunning is returned when a node that is not running is asked to do something that requires it to be.
+var ErrNotRunning = errors.New("node is not running")
+
+// Node is a server of a cluster. It can be started again after it is stopped, with the data it had.
+type Node struct {
+	config         *core.NodeConfig
+	logger         *log.Entry
+	wrapClient     func(server string, client core.RaftService) core.RaftService
+	registry       *prometheus.Registry
+	metrics        *metrics.Metrics
+	tracerProvider trace.TracerProvider
+
+	mu      sync.Mutex
+	running *running // Components of the running node, nil if it is not running.
+
+	leaderMu sync.Mutex
+	leader   bool      // Whether the node is the leader.
+	leaderCh chan bool // Latest leadership change not yet received.
+}
+
+// NodeConfig
+type NodeConfig struct {
+	Config *core.NodeConfig // Configuration of the node, which is validated by NewNode. Listen.Pprof is not served.
+	Logger *log.Entry       // Logger of the node, the standard logger if unset.
+	// Wraps the client of each peer if set, e.g. to inject network faults in tests.
+	WrapClient func(server string, client core.RaftService) core.RaftService
+	// Tracer provider of the spans of requests to the node (see tracing.NewTracing), spans are not recorded if unset.
+	TracerProvider trace.TracerProvider
+}
+
+// running is the components of a running node.
+type running struct {
+	storage    store.Storage
+	clients    []*rpc.RaftGRPCClient
+	sm         *core.StateMachine
+	raftServer *rpc.RaftGRPCServer
+	httpServer *httpserver.Server
+	wg         sync.WaitGroup
+}
+
+// NewNode creates a node, which does nothing until it is started.
+func NewNode(config NodeConfig) (*Node, error) {
+	if config.Config == nil {
+		return nil, errors.New("node configuration is unspecified")
+	}
+	if err := config.Config.Validate(); err != nil {
+		return nil, err
+	}
+	if config.Logger == nil {
+		config.Logger = log.NewEntry(log.StandardLogger())
+	}
+	if config.TracerProvider == nil {
+		config.TracerProvider = trace.NewNoopTracerProvider()
+	}
+	registry := prometheus.NewRegistry()
+	return &Node{
+		config:     config.Config,
+		logger:     config.Logger,
+		wrapClient: config.WrapClient,
+		registry:   registry,
+		metrics: metrics.NewMetrics(metrics.MetricsConfig{
+			Registerer: registry,
+			ServerName: config.Config.LocalServerName,
+		}),
+		tracerProvider: config.TracerProvider,
+		leaderCh:       make(chan bool, 1),
+	}, nil
+}
+
+// Name returns the server name of the node.
+func (n *Node) Name() string {
+	return n.config.LocalServerName
+}
+
+// Registry returns the Prometheus registry of the node's metrics, which are labeled with the node name as "server".
+// They are kept across restarts of the node, and served on /metrics of its HTTP server.
+func (n *Node) Registry() *prometheus.Registry {
+	return n.registry
+}
+
+// Config returns the configuration of the node.
+func (n *Node) Config() *core.NodeConfig {
+	return n.config
+}
+
+// Running returns whether the node is running.
+func (n *Node) Running() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.running != nil
+}
+
+// StateMachine returns the state machine of the running node, or nil if it is not running.
+func (n *Node) StateMachine() *core.StateMachine {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.running == nil {
+		return nil
+	}
+	return n.running.sm
+}
+
+// Start opens the storage and starts the state machine and servers, it returns once the servers are listening. Peers
+// are connected to lazily, so nodes of a cluster can be started in any order. Starting is abandoned with ctx's error
+// if ctx is done before the servers are listening, once started the node runs until it is stopped.
+func (n *Node) Start(ctx context.Context) (err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.running != nil {
+		return errors.New("node is already running")
+	}
+
+	r := &running{}
+	// Releases whatever has been set up if starting fails.
+	var listeners []net.Listener
+	defer func() {
+		if err != nil {
+			for _, lis := range listeners {
+				lis.Close()
+			}
+			if r.sm != nil {
+				r.sm.Close()
+			}
+			r.closeClients()
+			if r.storage != nil {
+				r.storage.Close()
+			}
+		}
+	}()
+
+	config := n.config
+	cluster := &config.ClusterConfig
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(config.Storage.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create dir: %v", err)
+	}
+	if r.storage, err = OpenStorage(config.Storage, false, n.metrics); err != nil {
+		return err
+	}
+
+	var certs *security.CertReloader
+	if cluster.TLS != nil {
+		if certs, err = security.NewCertReloader(security.CertReloaderConfig{
+			CAFile:   cluster.TLS.CAFile,
+			CertFile: cluster.TLS.CertFile,
+			KeyFile:  cluster.TLS.KeyFile,
+		}); err != nil {
+			return fmt.Errorf("failed to load certificates: %v", err)
+		}
+	}
+
+	peerSecret, err := cluster.ReadPeerSecret()
+	if err != nil {
+		return err
+	}
+
+	clients := make(map[string]core.RaftService)
+	for server, endpoint := range cluster.Servers {
+		if server == cluster.LocalServerName {
+			continue
+		}
+		clientConfig := rpc.RaftGRPCClientConfig{
+			Endpoint:          endpoint,
+			ConnectionTimeout: time.Duration(config.RPC.ConnectTimeout),
+			PeerSecret:        peerSecret,
+		}
+		if certs != nil {
+			clientConfig.TLS = certs.ClientConfig(server)
+		}
+		c, err := rpc.NewRaftGRPCClient(clientConfig)
+		if err != nil {
+			return fmt.Errorf("failed to create GRPC client: %v", err)
+		}
+		r.clients = append(r.clients, c)
+		clients[server] = c
+		if n.wrapClient != nil {
+			clients[server] = n.wrapClient(server, c)
+		}
+	}
+
+	if r.sm, err = core.NewStateMachine(core.StateMachineConfig{
+		Storage:      r.storage,
+		Cluster:      cluster,
+		Clients:      clients,
+		LogCacheSize: config.Storage.LogCacheSize,
+		Logger:       n.logger,
+
+		HeartbeatInterval:        time.Duration(config.Raft.HeartbeatInterval),
+		ElectionTimeoutMin:       time.Duration(config.Raft.ElectionTimeoutMin),
+		ElectionTimeoutMax:       time.Duration(config.Raft.ElectionTimeoutMax),
+		ConsistencyCheckInterval: time.Duration(config.Raft.ConsistencyCheckInterval),
+		MaxPendingProposals:      config.Limits.MaxPendingProposals,
+		MaxPendingProposalBytes:  config.Limits.MaxPendingProposalBytes,
+		OnLeadershipChange:       n.setLeader,
+		Metrics:                  n.metrics,
+	}); err != nil {
+		return fmt.Errorf("failed to create state machine: %v", err)
+	}
+
+	raftConfig := rpc.RaftGRPCServerConfig{
+		Endpoint:     config.Listen.Raft,
+		StateMachine: r.sm,
+		Timeout:      time.Duration(config.RPC.RequestTimeout),
+		PeerSecret:   peerSecret,
+
+		TracerProvider: n.tracerProvider,
+	}
+	httpConfig := httpserver.ServerConfig{
+		StateMachine: r.sm,
+		Cluster:      cluster,
+		Address:      config.Listen.HTTP,
+		ReadTimeout:  time.Duration(config.HTTP.ReadTimeout),
+		WriteTimeout: time.Duration(config.HTTP.WriteTimeout),
+
+		Metrics:        n.registry,
+		TracerProvider: n.tracerProvider,
+	}
+	if certs != nil {
+		raftConfig.TLS = certs.ServerConfig(cluster.TLS.ClientAuth)
+		httpConfig.TLS = certs.ServerConfig(cluster.TLS.ClientAuth)
+		httpConfig.PeerTLS = certs.ClientConfig
+		if cluster.TLS.ClientAuth {
+			raftConfig.Peers = cluster.Servers
+		}
+	}
+	r.raftServer = rpc.NewRaftGRPCServer(raftConfig)
+	r.httpServer = httpserver.NewServer(httpConfig)
+
+	var lc net.ListenConfig
+	raftLis, err := lc.Listen(ctx, "tcp", config.Listen.Raft)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %q: %v", config.Listen.Raft, err)
+	}
+	listeners = append(listeners, raftLis)
+	httpLis, err := lc.Listen(ctx, "tcp", config.Listen.HTTP)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %q: %v", config.Listen.HTTP, err)
+	}
+	listeners = append(listeners, httpLis)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.wg.Add(3)
+	go func() {
+		defer r.wg.Done()
+		if err := r.raftServer.Serve(raftLis); err != nil && err != grpc.ErrServerStopped {
+			n.logger.Errorf("gRPC server stopped: %v", err)
+		}
+	}()
+	go func() {
+		defer r.wg.Done()
+		// The state machine outlives ctx, it runs until the node is stopped.
+		r.sm.Run(context.Background())
+	}()
+	go func() {
+		defer r.wg.Done()
+		if err := r.httpServer.Serve(httpLis); err != nil && err != http.ErrServerClosed {
+			n.logger.Errorf("HTTP server stopped: %v", err)
+		}
+	}()
+
+	n.running = r
+	return nil
+}
+
+// Stop stops the servers and the state machine, and closes the storage. Pending requests are cancelled when ctx is
+// done.
+func (n *Node) Stop(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	r := n.running
+	if r == nil {
+		return ErrNotRunning
+	}
+	n.running = nil
+
+	if err := r.httpServer.Shutdown(ctx); err != nil {
+		n.logger.Warnf("Failed to shut down HTTP server gracefully: %v", err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		r.raftServer.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		r.raftServer.ForceStop()
+		<-stopped
+	}
+	r.sm.Close()
+	r.wg.Wait()
+	n.setLeader(false)
+	r.closeClients()
+	if err := r.storage.Close(); err != nil {
+		return fmt.Errorf("failed to close storage: %v", err)
+	}
+	return nil
+}
+
+// Write is a change of a key.
+type Write struct {
+	Key    []byte
+	Value  []byte
+	Delete bool // Deletes the key rather than setting its value.
+}
+
+// Propose writes changes of keys atomically through the leader, and returns the revision (log index) they are
+// written at once they are applied on the leader. Once authentication is enabled, ctx must carry the credentials of
+// a user allowed to write the keys (see core.WithCredentials).
+func (n *Node) Propose(ctx context.Context, writes ...Write) (uint64, error) {
+	sm := n.StateMachine()
+	if sm == nil {
+		return 0, ErrNotRunning
+	}
+	kvs := &konsen.KVList{KvList: make([]*konsen.KV, len(writes))}
+	for i, w := range writes {
+		kvs.KvList[i] = &konsen.KV{Key: w.Key, Value: w.Value, Delete: w.Delete}
+	}
+	return sm.Write(ctx, kvs)
+}
+
+// Read returns the value of a key (empty if it does not exist) from the local state machine, and the revision it is
+// read at. Linearizable and leader-local reads fail with core.ErrNotLeader on followers, bounded staleness reads fail
+// with core.ErrTooStale if this node is too far behind the leader. With consistency.MinIndex set to the revision of a
+// write, the read waits for this node to apply the write first.
+func (n *Node) Read(ctx context.Context, key []byte, consistency core.ReadConsistency) ([]byte, uint64, error) {
+	sm := n.StateMachine()
+	if sm == nil {
+		return nil, 0, ErrNotRunning
+	}
+	resp, err := sm.Get(ctx, &konsen.GetReq{Key: key, Options: consistency.Options()})
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.GetValue(), resp.GetRevision(), nil
+}
+
+// Status returns the Raft status of the node.
+func (n *Node) Status(ctx context.Context) (*core.Status, error) {
+	sm := n.StateMachine()
+	if sm == nil {
+		return nil, ErrNotRunning
+	}
+	return sm.GetStatus(ctx)
+}
+
+// LeaderCh returns a channel that receives true when the node becomes the leader, and false when it stops being the
+// leader (including when it is stopped). Only the latest change is kept if the channel is not drained in time, so
+// a received value is always followed by the current state.
+func (n *Node) LeaderCh() <-chan bool {
+	return n.leaderCh
+}
+
+// IsLeader returns whether the node is the leader.
+func (n *Node) IsLeader() bool {
+	n.leaderMu.Lock()
+	defer n.leaderMu.Unlock()
+	return n.leader
+}
+
+// setLeader records whether the node is the leader, and notifies LeaderCh if it changes.
+func (n *Node) setLeader(leader bool) {
+	n.leaderMu.Lock()
+	defer n.leaderMu.Unlock()
+	if leader == n.leader {
+		return
+	}
+	n.leader = leader
+	// Replaces the change that has not been received.
+	select {
+	case <-n.leaderCh:
+	default:
+	}
+	n.leaderCh <- leader
+}
+
+func (r *running) closeClients() {
+	for _, c := range r.clients {
+		c.Close()
+	}
+}
@@ -0,0 +1,106 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/lizhaoliu/konsen/v2/core"
+)
+
+// newTestNode creates a node of a single server cluster, which listens on the given Raft endpoint.
+func newTestNode(t *testing.T, raftEndpoint string) *Node {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "konsen-node-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	config := core.DefaultNodeConfig()
+	config.ClusterConfig = core.ClusterConfig{
+		Servers:         map[string]string{"node1": raftEndpoint},
+		HttpServers:     map[string]string{"node1": "127.0.0.1:0"},
+		LocalServerName: "node1",
+	}
+	config.Listen.Raft = raftEndpoint
+	config.Listen.HTTP = "127.0.0.1:0"
+	config.Listen.Pprof = ""
+	config.Storage.Dir = dir
+	n, err := NewNode(NodeConfig{Config: config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestStartCanceled(t *testing.T) {
+	n := newTestNode(t, "127.0.0.1:0")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := n.Start(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	if n.Running() {
+		t.Fatal("node is running after a canceled start")
+	}
+}
+
+// A failed start releases everything it has set up, so that the node can be started again.
+func TestStartAfterFailure(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := newTestNode(t, lis.Addr().String())
+	if err := n.Start(context.Background()); err == nil {
+		t.Fatal("starting a node on a port in use succeeded")
+	}
+	if n.Running() {
+		t.Fatal("node is running after a failed start")
+	}
+
+	lis.Close()
+	if err := n.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Each node has its own metrics, labeled with its name.
+func TestNodeMetrics(t *testing.T) {
+	a, b := newTestNode(t, "127.0.0.1:0"), newTestNode(t, "127.0.0.1:0")
+	if a.Registry() == b.Registry() {
+		t.Fatal("nodes share a metrics registry")
+	}
+	a.metrics.Elections.Inc()
+
+	families, err := a.Registry().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var elections float64
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			var server string
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "server" {
+					server = l.GetValue()
+				}
+			}
+			if server != "node1" {
+				t.Fatalf("got metric %s with server %q, want node1", f.GetName(), server)
+			}
+			if f.GetName() == "konsen_elections_total" {
+				elections = m.GetCounter().GetValue()
+			}
+		}
+	}
+	if elections != 1 {
+		t.Fatalf("got %v elections, want 1", elections)
+	}
+}
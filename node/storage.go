@@ -0,0 +1,60 @@
+package node
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/lizhaoliu/konsen/v2/core"
+	"github.com/lizhaoliu/konsen/v2/metrics"
+	"github.com/lizhaoliu/konsen/v2/store"
+)
+
+// OpenStorage opens the local storage of a node, read-only (e.g. to inspect a stopped node) if readOnly is true. Storage
+// engines that fsync explicitly observe it with m, if it is not nil.
+func OpenStorage(config core.StorageConfig, readOnly bool, m *metrics.Metrics) (store.Storage, error) {
+	stateDir := config.StateDir
+	if stateDir == "" {
+		stateDir = path.Join(config.Dir, "state")
+	}
+	switch config.Engine {
+	case "badger":
+		logDir := config.LogDir
+		if logDir == "" {
+			logDir = path.Join(config.Dir, "logs")
+		}
+		return store.NewBadger(store.BadgerConfig{
+			LogDir:   logDir,
+			StateDir: stateDir,
+			ReadOnly: readOnly,
+		})
+	case "boltdb":
+		return store.NewBoltDB(store.BoltDBConfig{
+			FilePath: path.Join(config.Dir, "konsen.db"),
+			ReadOnly: readOnly,
+		})
+	case "wal":
+		logDir := config.LogDir
+		if logDir == "" {
+			logDir = path.Join(config.Dir, "wal")
+		}
+		logs, err := store.NewWAL(store.WALConfig{
+			LogDir:   logDir,
+			ReadOnly: readOnly,
+			Metrics:  m,
+		})
+		if err != nil {
+			return nil, err
+		}
+		state, err := store.NewBadgerState(store.BadgerStateConfig{
+			Dir:      stateDir,
+			ReadOnly: readOnly,
+		})
+		if err != nil {
+			logs.Close()
+			return nil, err
+		}
+		return store.NewComposite(logs, state, state), nil
+	default:
+		return nil, fmt.Errorf("unknown storage engine %q", config.Engine)
+	}
+}
@@ -30,8 +30,9 @@ type KV struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Key   []byte `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
-	Value []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Key    []byte `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value  []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Delete bool   `protobuf:"varint,3,opt,name=delete,proto3" json:"delete,omitempty"` // Deletes the key instead of setting its value.
 }
 
 func (x *KV) Reset() {
@@ -80,6 +81,13 @@ func (x *KV) GetValue() []byte {
 	return nil
 }
 
+func (x *KV) GetDelete() bool {
+	if x != nil {
+		return x.Delete
+	}
+	return false
+}
+
 type KVList struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -131,14 +139,15 @@ var File_konsen_proto protoreflect.FileDescriptor
 
 var file_konsen_proto_rawDesc = []byte{
 	0x0a, 0x0c, 0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x06,
-	0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x22, 0x2c, 0x0a, 0x02, 0x4b, 0x56, 0x12, 0x10, 0x0a, 0x03,
+	0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x22, 0x44, 0x0a, 0x02, 0x4b, 0x56, 0x12, 0x10, 0x0a, 0x03,
 	0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14,
 	0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x76,
-	0x61, 0x6c, 0x75, 0x65, 0x22, 0x2d, 0x0a, 0x06, 0x4b, 0x56, 0x4c, 0x69, 0x73, 0x74, 0x12, 0x23,
-	0x0a, 0x07, 0x6b, 0x76, 0x5f, 0x6c, 0x69, 0x73, 0x74, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32,
-	0x0a, 0x2e, 0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x2e, 0x4b, 0x56, 0x52, 0x06, 0x6b, 0x76, 0x4c,
-	0x69, 0x73, 0x74, 0x42, 0x0a, 0x5a, 0x08, 0x2e, 0x3b, 0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x62,
-	0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x61, 0x6c, 0x75, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x64, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x06, 0x64, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x22, 0x2d, 0x0a, 0x06,
+	0x4b, 0x56, 0x4c, 0x69, 0x73, 0x74, 0x12, 0x23, 0x0a, 0x07, 0x6b, 0x76, 0x5f, 0x6c, 0x69, 0x73,
+	0x74, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0a, 0x2e, 0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e,
+	0x2e, 0x4b, 0x56, 0x52, 0x06, 0x6b, 0x76, 0x4c, 0x69, 0x73, 0x74, 0x42, 0x0a, 0x5a, 0x08, 0x2e,
+	0x3b, 0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
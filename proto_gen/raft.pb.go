@@ -78,14 +78,127 @@ func (Role) EnumDescriptor() ([]byte, []int) {
 	return file_raft_proto_rawDescGZIP(), []int{0}
 }
 
+type LogType int32
+
+const (
+	LogType_DATA              LogType = 0 // Data/command to apply to the key-value store.
+	LogType_CONSISTENCY_CHECK LogType = 1 // Marker at which every server hashes its applied key-value state.
+	LogType_TXN               LogType = 2 // Transaction (konsen.kv.TxnReq) to apply to the key-value store.
+	LogType_AUTH              LogType = 3 // Change (konsen.AuthOp) of the user/role database.
+	LogType_NOOP              LogType = 4 // Empty entry written by a new leader, so that it commits an entry of its own term.
+)
+
+// Enum value maps for LogType.
+var (
+	LogType_name = map[int32]string{
+		0: "DATA",
+		1: "CONSISTENCY_CHECK",
+		2: "TXN",
+		3: "AUTH",
+		4: "NOOP",
+	}
+	LogType_value = map[string]int32{
+		"DATA":              0,
+		"CONSISTENCY_CHECK": 1,
+		"TXN":               2,
+		"AUTH":              3,
+		"NOOP":              4,
+	}
+)
+
+func (x LogType) Enum() *LogType {
+	p := new(LogType)
+	*p = x
+	return p
+}
+
+func (x LogType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (LogType) Descriptor() protoreflect.EnumDescriptor {
+	return file_raft_proto_enumTypes[1].Descriptor()
+}
+
+func (LogType) Type() protoreflect.EnumType {
+	return &file_raft_proto_enumTypes[1]
+}
+
+func (x LogType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use LogType.Descriptor instead.
+func (LogType) EnumDescriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{1}
+}
+
+// Reason of an unsuccessful AppendData request.
+type AppendDataError int32
+
+const (
+	AppendDataError_UNKNOWN    AppendDataError = 0
+	AppendDataError_NO_QUORUM  AppendDataError = 1 // No leader is elected, or the leader is not reachable.
+	AppendDataError_NOT_LEADER AppendDataError = 2 // The leader can not accept data right now, e.g. it is transferring leadership.
+	AppendDataError_TIMEOUT    AppendDataError = 3 // The data was not committed and applied in time.
+	AppendDataError_OVERLOADED AppendDataError = 4 // Too many proposals are pending on the server, retry later.
+)
+
+// Enum value maps for AppendDataError.
+var (
+	AppendDataError_name = map[int32]string{
+		0: "UNKNOWN",
+		1: "NO_QUORUM",
+		2: "NOT_LEADER",
+		3: "TIMEOUT",
+		4: "OVERLOADED",
+	}
+	AppendDataError_value = map[string]int32{
+		"UNKNOWN":    0,
+		"NO_QUORUM":  1,
+		"NOT_LEADER": 2,
+		"TIMEOUT":    3,
+		"OVERLOADED": 4,
+	}
+)
+
+func (x AppendDataError) Enum() *AppendDataError {
+	p := new(AppendDataError)
+	*p = x
+	return p
+}
+
+func (x AppendDataError) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (AppendDataError) Descriptor() protoreflect.EnumDescriptor {
+	return file_raft_proto_enumTypes[2].Descriptor()
+}
+
+func (AppendDataError) Type() protoreflect.EnumType {
+	return &file_raft_proto_enumTypes[2]
+}
+
+func (x AppendDataError) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use AppendDataError.Descriptor instead.
+func (AppendDataError) EnumDescriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{2}
+}
+
 type Log struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Index uint64 `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"` // Index of the log entry (first index is 1).
-	Term  uint64 `protobuf:"varint,2,opt,name=term,proto3" json:"term,omitempty"`   // Term of the log entry.
-	Data  []byte `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`    // Raw data/command that the log entry encapsulates.
+	Index    uint64  `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`                   // Index of the log entry (first index is 1).
+	Term     uint64  `protobuf:"varint,2,opt,name=term,proto3" json:"term,omitempty"`                     // Term of the log entry.
+	Data     []byte  `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`                      // Raw data/command that the log entry encapsulates.
+	Checksum uint32  `protobuf:"varint,4,opt,name=checksum,proto3" json:"checksum,omitempty"`             // CRC-32C checksum of index, term, type and data, computed when the entry is proposed (0 if absent).
+	Type     LogType `protobuf:"varint,5,opt,name=type,proto3,enum=konsen.LogType" json:"type,omitempty"` // Type of the log entry.
 }
 
 func (x *Log) Reset() {
@@ -141,6 +254,20 @@ func (x *Log) GetData() []byte {
 	return nil
 }
 
+func (x *Log) GetChecksum() uint32 {
+	if x != nil {
+		return x.Checksum
+	}
+	return 0
+}
+
+func (x *Log) GetType() LogType {
+	if x != nil {
+		return x.Type
+	}
+	return LogType_DATA
+}
+
 type AppendEntriesReq struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -233,8 +360,10 @@ type AppendEntriesResp struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Term    uint64 `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`       // Current term, for leader to update itself.
-	Success bool   `protobuf:"varint,2,opt,name=success,proto3" json:"success,omitempty"` // True if follower contained entry matching prevLogIndex and prevLogTerm.
+	Term           uint64 `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`                                             // Current term, for leader to update itself.
+	Success        bool   `protobuf:"varint,2,opt,name=success,proto3" json:"success,omitempty"`                                       // True if follower contained entry matching prevLogIndex and prevLogTerm.
+	StateHashIndex uint64 `protobuf:"varint,3,opt,name=state_hash_index,json=stateHashIndex,proto3" json:"state_hash_index,omitempty"` // Index of the latest consistency check log applied by the follower (0 if none).
+	StateHash      []byte `protobuf:"bytes,4,opt,name=state_hash,json=stateHash,proto3" json:"state_hash,omitempty"`                   // Hash of the follower's key-value state at state_hash_index.
 }
 
 func (x *AppendEntriesResp) Reset() {
@@ -283,6 +412,20 @@ func (x *AppendEntriesResp) GetSuccess() bool {
 	return false
 }
 
+func (x *AppendEntriesResp) GetStateHashIndex() uint64 {
+	if x != nil {
+		return x.StateHashIndex
+	}
+	return 0
+}
+
+func (x *AppendEntriesResp) GetStateHash() []byte {
+	if x != nil {
+		return x.StateHash
+	}
+	return nil
+}
+
 type RequestVoteReq struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -414,7 +557,8 @@ type AppendDataReq struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Data []byte `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"` // Raw data/command that is to be stored and applied to state machine.
+	Data []byte  `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`                      // Raw data/command that is to be stored and applied to state machine.
+	Type LogType `protobuf:"varint,2,opt,name=type,proto3,enum=konsen.LogType" json:"type,omitempty"` // Type of the data: DATA, TXN, or AUTH which is only accepted from the leader itself.
 }
 
 func (x *AppendDataReq) Reset() {
@@ -456,13 +600,23 @@ func (x *AppendDataReq) GetData() []byte {
 	return nil
 }
 
+func (x *AppendDataReq) GetType() LogType {
+	if x != nil {
+		return x.Type
+	}
+	return LogType_DATA
+}
+
 type AppendDataResp struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Success      bool   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
-	ErrorMessage string `protobuf:"bytes,2,opt,name=error_message,json=errorMessage,proto3" json:"error_message,omitempty"`
+	Success      bool            `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	ErrorMessage string          `protobuf:"bytes,2,opt,name=error_message,json=errorMessage,proto3" json:"error_message,omitempty"`
+	Error        AppendDataError `protobuf:"varint,3,opt,name=error,proto3,enum=konsen.AppendDataError" json:"error,omitempty"`       // Reason of failure if not successful.
+	Index        uint64          `protobuf:"varint,4,opt,name=index,proto3" json:"index,omitempty"`                                   // Index of the log entry the data is written to, if successful.
+	TxnSucceeded bool            `protobuf:"varint,5,opt,name=txn_succeeded,json=txnSucceeded,proto3" json:"txn_succeeded,omitempty"` // For TXN data, true if all comparisons held.
 }
 
 func (x *AppendDataResp) Reset() {
@@ -511,72 +665,243 @@ func (x *AppendDataResp) GetErrorMessage() string {
 	return ""
 }
 
+func (x *AppendDataResp) GetError() AppendDataError {
+	if x != nil {
+		return x.Error
+	}
+	return AppendDataError_UNKNOWN
+}
+
+func (x *AppendDataResp) GetIndex() uint64 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *AppendDataResp) GetTxnSucceeded() bool {
+	if x != nil {
+		return x.TxnSucceeded
+	}
+	return false
+}
+
+type TimeoutNowReq struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Term     uint64 `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`                        // Leader's term.
+	LeaderId string `protobuf:"bytes,2,opt,name=leader_id,json=leaderId,proto3" json:"leader_id,omitempty"` // Leader that transfers its leadership.
+}
+
+func (x *TimeoutNowReq) Reset() {
+	*x = TimeoutNowReq{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_raft_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TimeoutNowReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TimeoutNowReq) ProtoMessage() {}
+
+func (x *TimeoutNowReq) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TimeoutNowReq.ProtoReflect.Descriptor instead.
+func (*TimeoutNowReq) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *TimeoutNowReq) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+func (x *TimeoutNowReq) GetLeaderId() string {
+	if x != nil {
+		return x.LeaderId
+	}
+	return ""
+}
+
+type TimeoutNowResp struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Term    uint64 `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`       // Current term, for leader to update itself.
+	Success bool   `protobuf:"varint,2,opt,name=success,proto3" json:"success,omitempty"` // True if the server started an election.
+}
+
+func (x *TimeoutNowResp) Reset() {
+	*x = TimeoutNowResp{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_raft_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TimeoutNowResp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TimeoutNowResp) ProtoMessage() {}
+
+func (x *TimeoutNowResp) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TimeoutNowResp.ProtoReflect.Descriptor instead.
+func (*TimeoutNowResp) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *TimeoutNowResp) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+func (x *TimeoutNowResp) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
 var File_raft_proto protoreflect.FileDescriptor
 
 var file_raft_proto_rawDesc = []byte{
 	0x0a, 0x0a, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x06, 0x6b, 0x6f,
-	0x6e, 0x73, 0x65, 0x6e, 0x22, 0x43, 0x0a, 0x03, 0x4c, 0x6f, 0x67, 0x12, 0x14, 0x0a, 0x05, 0x69,
-	0x6e, 0x64, 0x65, 0x78, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x05, 0x69, 0x6e, 0x64, 0x65,
-	0x78, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52,
-	0x04, 0x74, 0x65, 0x72, 0x6d, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x03, 0x20,
-	0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x22, 0xd9, 0x01, 0x0a, 0x10, 0x41, 0x70,
-	0x70, 0x65, 0x6e, 0x64, 0x45, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x52, 0x65, 0x71, 0x12, 0x12,
-	0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x04, 0x74, 0x65,
-	0x72, 0x6d, 0x12, 0x1b, 0x0a, 0x09, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x18,
-	0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x49, 0x64, 0x12,
-	0x24, 0x0a, 0x0e, 0x70, 0x72, 0x65, 0x76, 0x5f, 0x6c, 0x6f, 0x67, 0x5f, 0x69, 0x6e, 0x64, 0x65,
-	0x78, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0c, 0x70, 0x72, 0x65, 0x76, 0x4c, 0x6f, 0x67,
-	0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x22, 0x0a, 0x0d, 0x70, 0x72, 0x65, 0x76, 0x5f, 0x6c, 0x6f,
-	0x67, 0x5f, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x04, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0b, 0x70, 0x72,
-	0x65, 0x76, 0x4c, 0x6f, 0x67, 0x54, 0x65, 0x72, 0x6d, 0x12, 0x25, 0x0a, 0x07, 0x65, 0x6e, 0x74,
-	0x72, 0x69, 0x65, 0x73, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x6b, 0x6f, 0x6e,
-	0x73, 0x65, 0x6e, 0x2e, 0x4c, 0x6f, 0x67, 0x52, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73,
-	0x12, 0x23, 0x0a, 0x0d, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x5f, 0x63, 0x6f, 0x6d, 0x6d, 0x69,
-	0x74, 0x18, 0x06, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0c, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x43,
-	0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x22, 0x41, 0x0a, 0x11, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x45,
-	0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x52, 0x65, 0x73, 0x70, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65,
-	0x72, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12, 0x18,
-	0x0a, 0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52,
-	0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x22, 0x91, 0x01, 0x0a, 0x0e, 0x52, 0x65, 0x71,
-	0x75, 0x65, 0x73, 0x74, 0x56, 0x6f, 0x74, 0x65, 0x52, 0x65, 0x71, 0x12, 0x12, 0x0a, 0x04, 0x74,
+	0x6e, 0x73, 0x65, 0x6e, 0x22, 0x84, 0x01, 0x0a, 0x03, 0x4c, 0x6f, 0x67, 0x12, 0x14, 0x0a, 0x05,
+	0x69, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x05, 0x69, 0x6e, 0x64,
+	0x65, 0x78, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04,
+	0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x12, 0x1a, 0x0a, 0x08, 0x63, 0x68,
+	0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08, 0x63, 0x68,
+	0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x12, 0x23, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x05,
+	0x20, 0x01, 0x28, 0x0e, 0x32, 0x0f, 0x2e, 0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x2e, 0x4c, 0x6f,
+	0x67, 0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x22, 0xd9, 0x01, 0x0a, 0x10,
+	0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x45, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x52, 0x65, 0x71,
+	0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x04,
+	0x74, 0x65, 0x72, 0x6d, 0x12, 0x1b, 0x0a, 0x09, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x5f, 0x69,
+	0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x49,
+	0x64, 0x12, 0x24, 0x0a, 0x0e, 0x70, 0x72, 0x65, 0x76, 0x5f, 0x6c, 0x6f, 0x67, 0x5f, 0x69, 0x6e,
+	0x64, 0x65, 0x78, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0c, 0x70, 0x72, 0x65, 0x76, 0x4c,
+	0x6f, 0x67, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x22, 0x0a, 0x0d, 0x70, 0x72, 0x65, 0x76, 0x5f,
+	0x6c, 0x6f, 0x67, 0x5f, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x04, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0b,
+	0x70, 0x72, 0x65, 0x76, 0x4c, 0x6f, 0x67, 0x54, 0x65, 0x72, 0x6d, 0x12, 0x25, 0x0a, 0x07, 0x65,
+	0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x6b,
+	0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x2e, 0x4c, 0x6f, 0x67, 0x52, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69,
+	0x65, 0x73, 0x12, 0x23, 0x0a, 0x0d, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x5f, 0x63, 0x6f, 0x6d,
+	0x6d, 0x69, 0x74, 0x18, 0x06, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0c, 0x6c, 0x65, 0x61, 0x64, 0x65,
+	0x72, 0x43, 0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x22, 0x8a, 0x01, 0x0a, 0x11, 0x41, 0x70, 0x70, 0x65,
+	0x6e, 0x64, 0x45, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x52, 0x65, 0x73, 0x70, 0x12, 0x12, 0x0a,
+	0x04, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x04, 0x74, 0x65, 0x72,
+	0x6d, 0x12, 0x18, 0x0a, 0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x08, 0x52, 0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x12, 0x28, 0x0a, 0x10, 0x73,
+	0x74, 0x61, 0x74, 0x65, 0x5f, 0x68, 0x61, 0x73, 0x68, 0x5f, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0e, 0x73, 0x74, 0x61, 0x74, 0x65, 0x48, 0x61, 0x73, 0x68,
+	0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x1d, 0x0a, 0x0a, 0x73, 0x74, 0x61, 0x74, 0x65, 0x5f, 0x68,
+	0x61, 0x73, 0x68, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x73, 0x74, 0x61, 0x74, 0x65,
+	0x48, 0x61, 0x73, 0x68, 0x22, 0x91, 0x01, 0x0a, 0x0e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x56, 0x6f, 0x74, 0x65, 0x52, 0x65, 0x71, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12, 0x21, 0x0a, 0x0c, 0x63,
+	0x61, 0x6e, 0x64, 0x69, 0x64, 0x61, 0x74, 0x65, 0x5f, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x0b, 0x63, 0x61, 0x6e, 0x64, 0x69, 0x64, 0x61, 0x74, 0x65, 0x49, 0x64, 0x12, 0x24,
+	0x0a, 0x0e, 0x6c, 0x61, 0x73, 0x74, 0x5f, 0x6c, 0x6f, 0x67, 0x5f, 0x69, 0x6e, 0x64, 0x65, 0x78,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0c, 0x6c, 0x61, 0x73, 0x74, 0x4c, 0x6f, 0x67, 0x49,
+	0x6e, 0x64, 0x65, 0x78, 0x12, 0x22, 0x0a, 0x0d, 0x6c, 0x61, 0x73, 0x74, 0x5f, 0x6c, 0x6f, 0x67,
+	0x5f, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x04, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0b, 0x6c, 0x61, 0x73,
+	0x74, 0x4c, 0x6f, 0x67, 0x54, 0x65, 0x72, 0x6d, 0x22, 0x48, 0x0a, 0x0f, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x56, 0x6f, 0x74, 0x65, 0x52, 0x65, 0x73, 0x70, 0x12, 0x12, 0x0a, 0x04, 0x74,
 	0x65, 0x72, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12,
-	0x21, 0x0a, 0x0c, 0x63, 0x61, 0x6e, 0x64, 0x69, 0x64, 0x61, 0x74, 0x65, 0x5f, 0x69, 0x64, 0x18,
-	0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x63, 0x61, 0x6e, 0x64, 0x69, 0x64, 0x61, 0x74, 0x65,
-	0x49, 0x64, 0x12, 0x24, 0x0a, 0x0e, 0x6c, 0x61, 0x73, 0x74, 0x5f, 0x6c, 0x6f, 0x67, 0x5f, 0x69,
-	0x6e, 0x64, 0x65, 0x78, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0c, 0x6c, 0x61, 0x73, 0x74,
-	0x4c, 0x6f, 0x67, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x22, 0x0a, 0x0d, 0x6c, 0x61, 0x73, 0x74,
-	0x5f, 0x6c, 0x6f, 0x67, 0x5f, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x04, 0x20, 0x01, 0x28, 0x04, 0x52,
-	0x0b, 0x6c, 0x61, 0x73, 0x74, 0x4c, 0x6f, 0x67, 0x54, 0x65, 0x72, 0x6d, 0x22, 0x48, 0x0a, 0x0f,
-	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x56, 0x6f, 0x74, 0x65, 0x52, 0x65, 0x73, 0x70, 0x12,
-	0x12, 0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x04, 0x74,
-	0x65, 0x72, 0x6d, 0x12, 0x21, 0x0a, 0x0c, 0x76, 0x6f, 0x74, 0x65, 0x5f, 0x67, 0x72, 0x61, 0x6e,
-	0x74, 0x65, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0b, 0x76, 0x6f, 0x74, 0x65, 0x47,
-	0x72, 0x61, 0x6e, 0x74, 0x65, 0x64, 0x22, 0x23, 0x0a, 0x0d, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64,
-	0x44, 0x61, 0x74, 0x61, 0x52, 0x65, 0x71, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18,
-	0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x22, 0x4f, 0x0a, 0x0e, 0x41,
-	0x70, 0x70, 0x65, 0x6e, 0x64, 0x44, 0x61, 0x74, 0x61, 0x52, 0x65, 0x73, 0x70, 0x12, 0x18, 0x0a,
-	0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07,
-	0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x12, 0x23, 0x0a, 0x0d, 0x65, 0x72, 0x72, 0x6f, 0x72,
-	0x5f, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c,
-	0x65, 0x72, 0x72, 0x6f, 0x72, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2a, 0x2f, 0x0a, 0x04,
-	0x52, 0x6f, 0x6c, 0x65, 0x12, 0x0c, 0x0a, 0x08, 0x46, 0x4f, 0x4c, 0x4c, 0x4f, 0x57, 0x45, 0x52,
-	0x10, 0x00, 0x12, 0x0d, 0x0a, 0x09, 0x43, 0x41, 0x4e, 0x44, 0x49, 0x44, 0x41, 0x54, 0x45, 0x10,
-	0x01, 0x12, 0x0a, 0x0a, 0x06, 0x4c, 0x45, 0x41, 0x44, 0x45, 0x52, 0x10, 0x02, 0x32, 0xcf, 0x01,
-	0x0a, 0x04, 0x52, 0x61, 0x66, 0x74, 0x12, 0x46, 0x0a, 0x0d, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64,
-	0x45, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x12, 0x18, 0x2e, 0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e,
-	0x2e, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x45, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x52, 0x65,
-	0x71, 0x1a, 0x19, 0x2e, 0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x2e, 0x41, 0x70, 0x70, 0x65, 0x6e,
-	0x64, 0x45, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x52, 0x65, 0x73, 0x70, 0x22, 0x00, 0x12, 0x40,
-	0x0a, 0x0b, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x56, 0x6f, 0x74, 0x65, 0x12, 0x16, 0x2e,
-	0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x56, 0x6f,
-	0x74, 0x65, 0x52, 0x65, 0x71, 0x1a, 0x17, 0x2e, 0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x2e, 0x52,
-	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x56, 0x6f, 0x74, 0x65, 0x52, 0x65, 0x73, 0x70, 0x22, 0x00,
-	0x12, 0x3d, 0x0a, 0x0a, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x44, 0x61, 0x74, 0x61, 0x12, 0x15,
-	0x2e, 0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x2e, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x44, 0x61,
-	0x74, 0x61, 0x52, 0x65, 0x71, 0x1a, 0x16, 0x2e, 0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x2e, 0x41,
-	0x70, 0x70, 0x65, 0x6e, 0x64, 0x44, 0x61, 0x74, 0x61, 0x52, 0x65, 0x73, 0x70, 0x22, 0x00, 0x42,
-	0x0a, 0x5a, 0x08, 0x2e, 0x3b, 0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x62, 0x06, 0x70, 0x72, 0x6f,
-	0x74, 0x6f, 0x33,
+	0x21, 0x0a, 0x0c, 0x76, 0x6f, 0x74, 0x65, 0x5f, 0x67, 0x72, 0x61, 0x6e, 0x74, 0x65, 0x64, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0b, 0x76, 0x6f, 0x74, 0x65, 0x47, 0x72, 0x61, 0x6e, 0x74,
+	0x65, 0x64, 0x22, 0x48, 0x0a, 0x0d, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x44, 0x61, 0x74, 0x61,
+	0x52, 0x65, 0x71, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x12, 0x23, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x0f, 0x2e, 0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x2e, 0x4c,
+	0x6f, 0x67, 0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x22, 0xb9, 0x01, 0x0a,
+	0x0e, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x44, 0x61, 0x74, 0x61, 0x52, 0x65, 0x73, 0x70, 0x12,
+	0x18, 0x0a, 0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08,
+	0x52, 0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x12, 0x23, 0x0a, 0x0d, 0x65, 0x72, 0x72,
+	0x6f, 0x72, 0x5f, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x0c, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x2d,
+	0x0a, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x17, 0x2e,
+	0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x2e, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x44, 0x61, 0x74,
+	0x61, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x52, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x12, 0x14, 0x0a,
+	0x05, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x04, 0x20, 0x01, 0x28, 0x04, 0x52, 0x05, 0x69, 0x6e,
+	0x64, 0x65, 0x78, 0x12, 0x23, 0x0a, 0x0d, 0x74, 0x78, 0x6e, 0x5f, 0x73, 0x75, 0x63, 0x63, 0x65,
+	0x65, 0x64, 0x65, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0c, 0x74, 0x78, 0x6e, 0x53,
+	0x75, 0x63, 0x63, 0x65, 0x65, 0x64, 0x65, 0x64, 0x22, 0x40, 0x0a, 0x0d, 0x54, 0x69, 0x6d, 0x65,
+	0x6f, 0x75, 0x74, 0x4e, 0x6f, 0x77, 0x52, 0x65, 0x71, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x72,
+	0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12, 0x1b, 0x0a,
+	0x09, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x08, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x49, 0x64, 0x22, 0x3e, 0x0a, 0x0e, 0x54, 0x69,
+	0x6d, 0x65, 0x6f, 0x75, 0x74, 0x4e, 0x6f, 0x77, 0x52, 0x65, 0x73, 0x70, 0x12, 0x12, 0x0a, 0x04,
+	0x74, 0x65, 0x72, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d,
+	0x12, 0x18, 0x0a, 0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x08, 0x52, 0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x2a, 0x2f, 0x0a, 0x04, 0x52, 0x6f,
+	0x6c, 0x65, 0x12, 0x0c, 0x0a, 0x08, 0x46, 0x4f, 0x4c, 0x4c, 0x4f, 0x57, 0x45, 0x52, 0x10, 0x00,
+	0x12, 0x0d, 0x0a, 0x09, 0x43, 0x41, 0x4e, 0x44, 0x49, 0x44, 0x41, 0x54, 0x45, 0x10, 0x01, 0x12,
+	0x0a, 0x0a, 0x06, 0x4c, 0x45, 0x41, 0x44, 0x45, 0x52, 0x10, 0x02, 0x2a, 0x47, 0x0a, 0x07, 0x4c,
+	0x6f, 0x67, 0x54, 0x79, 0x70, 0x65, 0x12, 0x08, 0x0a, 0x04, 0x44, 0x41, 0x54, 0x41, 0x10, 0x00,
+	0x12, 0x15, 0x0a, 0x11, 0x43, 0x4f, 0x4e, 0x53, 0x49, 0x53, 0x54, 0x45, 0x4e, 0x43, 0x59, 0x5f,
+	0x43, 0x48, 0x45, 0x43, 0x4b, 0x10, 0x01, 0x12, 0x07, 0x0a, 0x03, 0x54, 0x58, 0x4e, 0x10, 0x02,
+	0x12, 0x08, 0x0a, 0x04, 0x41, 0x55, 0x54, 0x48, 0x10, 0x03, 0x12, 0x08, 0x0a, 0x04, 0x4e, 0x4f,
+	0x4f, 0x50, 0x10, 0x04, 0x2a, 0x5a, 0x0a, 0x0f, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x44, 0x61,
+	0x74, 0x61, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x12, 0x0b, 0x0a, 0x07, 0x55, 0x4e, 0x4b, 0x4e, 0x4f,
+	0x57, 0x4e, 0x10, 0x00, 0x12, 0x0d, 0x0a, 0x09, 0x4e, 0x4f, 0x5f, 0x51, 0x55, 0x4f, 0x52, 0x55,
+	0x4d, 0x10, 0x01, 0x12, 0x0e, 0x0a, 0x0a, 0x4e, 0x4f, 0x54, 0x5f, 0x4c, 0x45, 0x41, 0x44, 0x45,
+	0x52, 0x10, 0x02, 0x12, 0x0b, 0x0a, 0x07, 0x54, 0x49, 0x4d, 0x45, 0x4f, 0x55, 0x54, 0x10, 0x03,
+	0x12, 0x0e, 0x0a, 0x0a, 0x4f, 0x56, 0x45, 0x52, 0x4c, 0x4f, 0x41, 0x44, 0x45, 0x44, 0x10, 0x04,
+	0x32, 0x8e, 0x02, 0x0a, 0x04, 0x52, 0x61, 0x66, 0x74, 0x12, 0x46, 0x0a, 0x0d, 0x41, 0x70, 0x70,
+	0x65, 0x6e, 0x64, 0x45, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x12, 0x18, 0x2e, 0x6b, 0x6f, 0x6e,
+	0x73, 0x65, 0x6e, 0x2e, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x45, 0x6e, 0x74, 0x72, 0x69, 0x65,
+	0x73, 0x52, 0x65, 0x71, 0x1a, 0x19, 0x2e, 0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x2e, 0x41, 0x70,
+	0x70, 0x65, 0x6e, 0x64, 0x45, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x52, 0x65, 0x73, 0x70, 0x22,
+	0x00, 0x12, 0x40, 0x0a, 0x0b, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x56, 0x6f, 0x74, 0x65,
+	0x12, 0x16, 0x2e, 0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x56, 0x6f, 0x74, 0x65, 0x52, 0x65, 0x71, 0x1a, 0x17, 0x2e, 0x6b, 0x6f, 0x6e, 0x73, 0x65,
+	0x6e, 0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x56, 0x6f, 0x74, 0x65, 0x52, 0x65, 0x73,
+	0x70, 0x22, 0x00, 0x12, 0x3d, 0x0a, 0x0a, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x44, 0x61, 0x74,
+	0x61, 0x12, 0x15, 0x2e, 0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x2e, 0x41, 0x70, 0x70, 0x65, 0x6e,
+	0x64, 0x44, 0x61, 0x74, 0x61, 0x52, 0x65, 0x71, 0x1a, 0x16, 0x2e, 0x6b, 0x6f, 0x6e, 0x73, 0x65,
+	0x6e, 0x2e, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x44, 0x61, 0x74, 0x61, 0x52, 0x65, 0x73, 0x70,
+	0x22, 0x00, 0x12, 0x3d, 0x0a, 0x0a, 0x54, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x4e, 0x6f, 0x77,
+	0x12, 0x15, 0x2e, 0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x6f, 0x75,
+	0x74, 0x4e, 0x6f, 0x77, 0x52, 0x65, 0x71, 0x1a, 0x16, 0x2e, 0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e,
+	0x2e, 0x54, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x4e, 0x6f, 0x77, 0x52, 0x65, 0x73, 0x70, 0x22,
+	0x00, 0x42, 0x0a, 0x5a, 0x08, 0x2e, 0x3b, 0x6b, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x62, 0x06, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -591,31 +916,40 @@ func file_raft_proto_rawDescGZIP() []byte {
 	return file_raft_proto_rawDescData
 }
 
-var file_raft_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_raft_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_raft_proto_enumTypes = make([]protoimpl.EnumInfo, 3)
+var file_raft_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
 var file_raft_proto_goTypes = []interface{}{
 	(Role)(0),                 // 0: konsen.Role
-	(*Log)(nil),               // 1: konsen.Log
-	(*AppendEntriesReq)(nil),  // 2: konsen.AppendEntriesReq
-	(*AppendEntriesResp)(nil), // 3: konsen.AppendEntriesResp
-	(*RequestVoteReq)(nil),    // 4: konsen.RequestVoteReq
-	(*RequestVoteResp)(nil),   // 5: konsen.RequestVoteResp
-	(*AppendDataReq)(nil),     // 6: konsen.AppendDataReq
-	(*AppendDataResp)(nil),    // 7: konsen.AppendDataResp
+	(LogType)(0),              // 1: konsen.LogType
+	(AppendDataError)(0),      // 2: konsen.AppendDataError
+	(*Log)(nil),               // 3: konsen.Log
+	(*AppendEntriesReq)(nil),  // 4: konsen.AppendEntriesReq
+	(*AppendEntriesResp)(nil), // 5: konsen.AppendEntriesResp
+	(*RequestVoteReq)(nil),    // 6: konsen.RequestVoteReq
+	(*RequestVoteResp)(nil),   // 7: konsen.RequestVoteResp
+	(*AppendDataReq)(nil),     // 8: konsen.AppendDataReq
+	(*AppendDataResp)(nil),    // 9: konsen.AppendDataResp
+	(*TimeoutNowReq)(nil),     // 10: konsen.TimeoutNowReq
+	(*TimeoutNowResp)(nil),    // 11: konsen.TimeoutNowResp
 }
 var file_raft_proto_depIdxs = []int32{
-	1, // 0: konsen.AppendEntriesReq.entries:type_name -> konsen.Log
-	2, // 1: konsen.Raft.AppendEntries:input_type -> konsen.AppendEntriesReq
-	4, // 2: konsen.Raft.RequestVote:input_type -> konsen.RequestVoteReq
-	6, // 3: konsen.Raft.AppendData:input_type -> konsen.AppendDataReq
-	3, // 4: konsen.Raft.AppendEntries:output_type -> konsen.AppendEntriesResp
-	5, // 5: konsen.Raft.RequestVote:output_type -> konsen.RequestVoteResp
-	7, // 6: konsen.Raft.AppendData:output_type -> konsen.AppendDataResp
-	4, // [4:7] is the sub-list for method output_type
-	1, // [1:4] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	1,  // 0: konsen.Log.type:type_name -> konsen.LogType
+	3,  // 1: konsen.AppendEntriesReq.entries:type_name -> konsen.Log
+	1,  // 2: konsen.AppendDataReq.type:type_name -> konsen.LogType
+	2,  // 3: konsen.AppendDataResp.error:type_name -> konsen.AppendDataError
+	4,  // 4: konsen.Raft.AppendEntries:input_type -> konsen.AppendEntriesReq
+	6,  // 5: konsen.Raft.RequestVote:input_type -> konsen.RequestVoteReq
+	8,  // 6: konsen.Raft.AppendData:input_type -> konsen.AppendDataReq
+	10, // 7: konsen.Raft.TimeoutNow:input_type -> konsen.TimeoutNowReq
+	5,  // 8: konsen.Raft.AppendEntries:output_type -> konsen.AppendEntriesResp
+	7,  // 9: konsen.Raft.RequestVote:output_type -> konsen.RequestVoteResp
+	9,  // 10: konsen.Raft.AppendData:output_type -> konsen.AppendDataResp
+	11, // 11: konsen.Raft.TimeoutNow:output_type -> konsen.TimeoutNowResp
+	8,  // [8:12] is the sub-list for method output_type
+	4,  // [4:8] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
 }
 
 func init() { file_raft_proto_init() }
@@ -708,14 +1042,38 @@ func file_raft_proto_init() {
 				return nil
 			}
 		}
+		file_raft_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TimeoutNowReq); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_raft_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TimeoutNowResp); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_raft_proto_rawDesc,
-			NumEnums:      1,
-			NumMessages:   7,
+			NumEnums:      3,
+			NumMessages:   9,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -748,6 +1106,8 @@ type RaftClient interface {
 	RequestVote(ctx context.Context, in *RequestVoteReq, opts ...grpc.CallOption) (*RequestVoteResp, error)
 	// Sends "AppendData" request.
 	AppendData(ctx context.Context, in *AppendDataReq, opts ...grpc.CallOption) (*AppendDataResp, error)
+	// Sends "TimeoutNow" request, which makes the receiver start an election immediately (used by leadership transfer).
+	TimeoutNow(ctx context.Context, in *TimeoutNowReq, opts ...grpc.CallOption) (*TimeoutNowResp, error)
 }
 
 type raftClient struct {
@@ -785,6 +1145,15 @@ func (c *raftClient) AppendData(ctx context.Context, in *AppendDataReq, opts ...
 	return out, nil
 }
 
+func (c *raftClient) TimeoutNow(ctx context.Context, in *TimeoutNowReq, opts ...grpc.CallOption) (*TimeoutNowResp, error) {
+	out := new(TimeoutNowResp)
+	err := c.cc.Invoke(ctx, "/konsen.Raft/TimeoutNow", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // RaftServer is the server API for Raft service.
 type RaftServer interface {
 	// Sends "AppendEntries" request.
@@ -793,6 +1162,8 @@ type RaftServer interface {
 	RequestVote(context.Context, *RequestVoteReq) (*RequestVoteResp, error)
 	// Sends "AppendData" request.
 	AppendData(context.Context, *AppendDataReq) (*AppendDataResp, error)
+	// Sends "TimeoutNow" request, which makes the receiver start an election immediately (used by leadership transfer).
+	TimeoutNow(context.Context, *TimeoutNowReq) (*TimeoutNowResp, error)
 }
 
 // UnimplementedRaftServer can be embedded to have forward compatible implementations.
@@ -808,6 +1179,9 @@ func (*UnimplementedRaftServer) RequestVote(context.Context, *RequestVoteReq) (*
 func (*UnimplementedRaftServer) AppendData(context.Context, *AppendDataReq) (*AppendDataResp, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method AppendData not implemented")
 }
+func (*UnimplementedRaftServer) TimeoutNow(context.Context, *TimeoutNowReq) (*TimeoutNowResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TimeoutNow not implemented")
+}
 
 func RegisterRaftServer(s *grpc.Server, srv RaftServer) {
 	s.RegisterService(&_Raft_serviceDesc, srv)
@@ -867,6 +1241,24 @@ func _Raft_AppendData_Handler(srv interface{}, ctx context.Context, dec func(int
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Raft_TimeoutNow_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TimeoutNowReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftServer).TimeoutNow(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/konsen.Raft/TimeoutNow",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftServer).TimeoutNow(ctx, req.(*TimeoutNowReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Raft_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "konsen.Raft",
 	HandlerType: (*RaftServer)(nil),
@@ -883,6 +1275,10 @@ var _Raft_serviceDesc = grpc.ServiceDesc{
 			MethodName: "AppendData",
 			Handler:    _Raft_AppendData_Handler,
 		},
+		{
+			MethodName: "TimeoutNow",
+			Handler:    _Raft_TimeoutNow_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "raft.proto",
@@ -0,0 +1,65 @@
+package rpc
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+
+	"github.com/lizhaoliu/konsen/v2/core"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// credentialsInterceptor returns a gRPC server interceptor that attaches the credentials in "authorization" metadata
+// of KV service calls, and of writes forwarded by followers, to the context, in the same format as HTTP Authorization
+// header: "Basic <base64 of user:password>" or "Bearer <token>".
+func credentialsInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !strings.HasPrefix(info.FullMethod, "/konsen.kv.KV/") && info.FullMethod != "/konsen.Raft/AppendData" {
+			return handler(ctx, req)
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md.Get("authorization")
+		if len(values) == 0 {
+			return handler(ctx, req)
+		}
+		creds, ok := parseAuthorization(values[0])
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "invalid authorization metadata")
+		}
+		return handler(core.WithCredentials(ctx, creds), req)
+	}
+}
+
+// formatAuthorization returns the "authorization" metadata of the credentials.
+func formatAuthorization(creds *core.Credentials) string {
+	if creds.Token != "" {
+		return "Bearer " + creds.Token
+	}
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(creds.Username+":"+creds.Password))
+}
+
+func parseAuthorization(value string) (*core.Credentials, bool) {
+	i := strings.IndexByte(value, ' ')
+	if i < 0 {
+		return nil, false
+	}
+	scheme, param := value[:i], strings.TrimSpace(value[i+1:])
+	switch {
+	case strings.EqualFold(scheme, "Bearer"):
+		return &core.Credentials{Token: param}, param != ""
+	case strings.EqualFold(scheme, "Basic"):
+		buf, err := base64.StdEncoding.DecodeString(param)
+		if err != nil {
+			return nil, false
+		}
+		j := strings.IndexByte(string(buf), ':')
+		if j < 0 {
+			return nil, false
+		}
+		return &core.Credentials{Username: string(buf[:j]), Password: string(buf[j+1:])}, true
+	}
+	return nil, false
+}
@@ -2,11 +2,16 @@ package rpc
 
 import (
 	"context"
+	"crypto/tls"
 	"time"
 
+	"github.com/lizhaoliu/konsen/v2/core"
 	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+	"github.com/lizhaoliu/konsen/v2/tracing"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
 )
 
 const defaultConnectionTimeout = 30 * time.Second
@@ -19,6 +24,8 @@ type RaftGRPCClient struct {
 type RaftGRPCClientConfig struct {
 	Endpoint          string
 	ConnectionTimeout time.Duration
+	TLS               *tls.Config // TLS of the connection, plaintext if nil.
+	PeerSecret        []byte      // Secret shared by the servers, which calls are authenticated with if set.
 }
 
 func NewRaftGRPCClient(config RaftGRPCClientConfig) (*RaftGRPCClient, error) {
@@ -27,11 +34,20 @@ func NewRaftGRPCClient(config RaftGRPCClientConfig) (*RaftGRPCClient, error) {
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), config.ConnectionTimeout)
 	defer cancel()
+	transport := grpc.WithInsecure()
+	if config.TLS != nil {
+		transport = grpc.WithTransportCredentials(credentials.NewTLS(config.TLS))
+	}
+	interceptors := []grpc.UnaryClientInterceptor{tracing.UnaryClientInterceptor()}
+	if len(config.PeerSecret) > 0 {
+		interceptors = append(interceptors, peerSecretClientInterceptor(config.PeerSecret))
+	}
 	conn, err := grpc.DialContext(
 		ctx,
 		config.Endpoint,
-		grpc.WithInsecure(), // TODO: enable secured connection.
+		transport,
 		grpc.WithKeepaliveParams(keepalive.ClientParameters{}),
+		grpc.WithChainUnaryInterceptor(interceptors...),
 	)
 	if err != nil {
 		return nil, err
@@ -52,10 +68,19 @@ func (c *RaftGRPCClient) RequestVote(ctx context.Context, in *konsen.RequestVote
 	return c.client.RequestVote(ctx, in, grpc.WaitForReady(false))
 }
 
+// AppendData forwards a write to the leader, with the client credentials in ctx if any, so that a leader that can not
+// authenticate this server as a peer authorizes the write itself.
 func (c *RaftGRPCClient) AppendData(ctx context.Context, in *konsen.AppendDataReq) (*konsen.AppendDataResp, error) {
+	if creds := core.CredentialsFrom(ctx); creds != nil {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", formatAuthorization(creds))
+	}
 	return c.client.AppendData(ctx, in, grpc.WaitForReady(false))
 }
 
+func (c *RaftGRPCClient) TimeoutNow(ctx context.Context, in *konsen.TimeoutNowReq) (*konsen.TimeoutNowResp, error) {
+	return c.client.TimeoutNow(ctx, in, grpc.WaitForReady(false))
+}
+
 func (c *RaftGRPCClient) Close() error {
 	return c.conn.Close()
 }
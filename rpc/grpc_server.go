@@ -2,71 +2,129 @@ package rpc
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
 	"net"
 	"time"
 
 	"github.com/lizhaoliu/konsen/v2/core"
 	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+	"github.com/lizhaoliu/konsen/v2/tracing"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
-const rpcTimeout = 10 * time.Second
+const defaultRequestTimeout = 10 * time.Second
 
 type RaftGRPCServer struct {
 	endpoint string
 	sm       *core.StateMachine
+	tls      *tls.Config
+	peers    map[string]string
+	timeout  time.Duration
 	server   *grpc.Server
 }
 
 type RaftGRPCServerConfig struct {
 	Endpoint     string
 	StateMachine *core.StateMachine
+	TLS          *tls.Config       // TLS of the server, plaintext if nil.
+	Peers        map[string]string // If set, only these servers can call the Raft service, as verified by client certificates (requires mutual TLS).
+	PeerSecret   []byte            // If set, only callers that present this secret shared by the servers can call the Raft service.
+	Timeout      time.Duration     // Timeout of serving each request, defaults to 10 seconds.
+	// Tracer provider of the spans of requests, spans are not recorded if unset.
+	TracerProvider trace.TracerProvider
 }
 
 func NewRaftGRPCServer(config RaftGRPCServerConfig) *RaftGRPCServer {
+	if config.Timeout == 0 {
+		config.Timeout = defaultRequestTimeout
+	}
+	if config.TracerProvider == nil {
+		config.TracerProvider = trace.NewNoopTracerProvider()
+	}
 	s := &RaftGRPCServer{
 		endpoint: config.Endpoint,
 		sm:       config.StateMachine,
+		tls:      config.TLS,
+		peers:    config.Peers,
+		timeout:  config.Timeout,
+	}
+	interceptors := []grpc.UnaryServerInterceptor{tracing.UnaryServerInterceptor(config.TracerProvider, "konsen.Raft"), credentialsInterceptor()}
+	var opts []grpc.ServerOption
+	if s.tls != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(s.tls)))
+	}
+	switch {
+	case s.peers != nil:
+		interceptors = append(interceptors, peerAuthInterceptor(s.peers))
+	case len(config.PeerSecret) > 0:
+		interceptors = append(interceptors, peerSecretInterceptor(config.PeerSecret))
+	default:
+		logrus.Warnf("Raft service on %q does not authenticate peers, set a peer secret or mutual TLS to keep others from "+
+			"calling it.", config.Endpoint)
 	}
+	opts = append(opts, grpc.ChainUnaryInterceptor(interceptors...))
+	s.server = grpc.NewServer(opts...)
+	konsen.RegisterRaftServer(s.server, s)
+	konsen.RegisterKVServer(s.server, s)
 	return s
 }
 
 func (r *RaftGRPCServer) AppendEntries(ctx context.Context, req *konsen.AppendEntriesReq) (*konsen.AppendEntriesResp, error) {
-	ctx, cancel := context.WithTimeout(ctx, rpcTimeout)
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
 	defer cancel()
 
 	return r.sm.AppendEntries(ctx, req)
 }
 
 func (r *RaftGRPCServer) RequestVote(ctx context.Context, req *konsen.RequestVoteReq) (*konsen.RequestVoteResp, error) {
-	ctx, cancel := context.WithTimeout(ctx, rpcTimeout)
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
 	defer cancel()
 
 	return r.sm.RequestVote(ctx, req)
 }
 
 func (r *RaftGRPCServer) AppendData(ctx context.Context, req *konsen.AppendDataReq) (*konsen.AppendDataResp, error) {
-	ctx, cancel := context.WithTimeout(ctx, rpcTimeout)
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
 	defer cancel()
 
+	if err := r.sm.AuthorizeProposal(ctx, req, fromPeer(ctx)); err != nil {
+		return nil, toStatusError(err)
+	}
 	return r.sm.AppendData(ctx, req)
 }
 
-func (r *RaftGRPCServer) Serve() error {
-	logrus.Infof("Start konsen server on: %q", r.endpoint)
+func (r *RaftGRPCServer) TimeoutNow(ctx context.Context, req *konsen.TimeoutNowReq) (*konsen.TimeoutNowResp, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	return r.sm.TimeoutNow(ctx, req)
+}
+
+// ListenAndServe listens on the endpoint and serves until the server is stopped.
+func (r *RaftGRPCServer) ListenAndServe() error {
 	lis, err := net.Listen("tcp", r.endpoint)
 	if err != nil {
-		logrus.Fatalf("Failed to start server: %v", err)
+		return fmt.Errorf("failed to listen on %q: %w", r.endpoint, err)
 	}
-	server := grpc.NewServer()
-	konsen.RegisterRaftServer(server, r)
-	r.server = server
-	return server.Serve(lis)
+	return r.Serve(lis)
+}
+
+// Serve serves on given listener until the server is stopped.
+func (r *RaftGRPCServer) Serve(lis net.Listener) error {
+	logrus.Infof("Start konsen server on: %q", lis.Addr())
+	return r.server.Serve(lis)
 }
 
+// Stop stops the server after pending requests are done.
 func (r *RaftGRPCServer) Stop() {
-	if r.server != nil {
-		r.server.GracefulStop()
-	}
+	r.server.GracefulStop()
+}
+
+// ForceStop stops the server right away, cancelling pending requests.
+func (r *RaftGRPCServer) ForceStop() {
+	r.server.Stop()
 }
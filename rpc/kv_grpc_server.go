@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lizhaoliu/konsen/v2/core"
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// KV service is served by RaftGRPCServer on the same endpoint as Raft service.
+
+func (r *RaftGRPCServer) Get(ctx context.Context, req *konsen.GetReq) (*konsen.GetResp, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	resp, err := r.sm.Get(ctx, req)
+	return resp, toStatusError(err)
+}
+
+func (r *RaftGRPCServer) Put(ctx context.Context, req *konsen.PutReq) (*konsen.PutResp, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	resp, err := r.sm.Put(ctx, req)
+	return resp, toStatusError(err)
+}
+
+func (r *RaftGRPCServer) Delete(ctx context.Context, req *konsen.DeleteReq) (*konsen.DeleteResp, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	resp, err := r.sm.Delete(ctx, req)
+	return resp, toStatusError(err)
+}
+
+func (r *RaftGRPCServer) Range(ctx context.Context, req *konsen.RangeReq) (*konsen.RangeResp, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	resp, err := r.sm.Range(ctx, req)
+	return resp, toStatusError(err)
+}
+
+func (r *RaftGRPCServer) Txn(ctx context.Context, req *konsen.TxnReq) (*konsen.TxnResp, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	resp, err := r.sm.Txn(ctx, req)
+	return resp, toStatusError(err)
+}
+
+// toStatusError converts an error from state machine to a gRPC status error.
+func toStatusError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, core.ErrNoQuorum), errors.Is(err, core.ErrNotLeader), errors.Is(err, core.ErrTooStale):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, core.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, core.ErrOverloaded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, core.ErrUnauthenticated):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, core.ErrPermissionDenied):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, core.ErrInvalidAuth), errors.Is(err, core.ErrInvalidArgument):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
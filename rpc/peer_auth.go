@@ -0,0 +1,104 @@
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+	"github.com/lizhaoliu/konsen/v2/security"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// peerSecretMetadata is the metadata key of the secret that peers authenticate with when mutual TLS is not used.
+const peerSecretMetadata = "konsen-peer-secret"
+
+type peerKey struct{}
+
+// withPeer returns a copy of ctx that marks the call as made by an authenticated peer.
+func withPeer(ctx context.Context) context.Context {
+	return context.WithValue(ctx, peerKey{}, true)
+}
+
+// fromPeer returns whether the call of ctx is made by an authenticated peer.
+func fromPeer(ctx context.Context) bool {
+	v, _ := ctx.Value(peerKey{}).(bool)
+	return v
+}
+
+// peerSecretInterceptor returns a gRPC server interceptor that only lets callers that present the secret shared by
+// the servers call the Raft service.
+func peerSecretInterceptor(secret []byte) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !strings.HasPrefix(info.FullMethod, "/konsen.Raft/") {
+			return handler(ctx, req)
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md.Get(peerSecretMetadata)
+		if len(values) == 0 || subtle.ConstantTimeCompare([]byte(values[0]), secret) != 1 {
+			return nil, status.Error(codes.Unauthenticated, "peer secret is missing or invalid")
+		}
+		return handler(withPeer(ctx), req)
+	}
+}
+
+// peerSecretClientInterceptor returns a gRPC client interceptor that presents the secret shared by the servers.
+func peerSecretClientInterceptor(secret []byte) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(metadata.AppendToOutgoingContext(ctx, peerSecretMetadata, string(secret)), method, req, reply, cc, opts...)
+	}
+}
+
+// peerAuthInterceptor returns a gRPC server interceptor that only lets peers in given servers call the Raft service:
+// the client certificate must be valid for the server name claimed in the request (LeaderId/CandidateId), or for any
+// of the servers if the request claims none. It requires mutual TLS.
+func peerAuthInterceptor(servers map[string]string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !strings.HasPrefix(info.FullMethod, "/konsen.Raft/") {
+			return handler(ctx, req)
+		}
+		names, err := peerCertificateNames(ctx)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+
+		var claimed string
+		switch r := req.(type) {
+		case *konsen.AppendEntriesReq:
+			claimed = r.GetLeaderId()
+		case *konsen.RequestVoteReq:
+			claimed = r.GetCandidateId()
+		case *konsen.TimeoutNowReq:
+			claimed = r.GetLeaderId()
+		}
+		for _, name := range names {
+			if _, ok := servers[name]; ok && (claimed == "" || claimed == name) {
+				return handler(withPeer(ctx), req)
+			}
+		}
+		if claimed != "" {
+			return nil, status.Error(codes.PermissionDenied, fmt.Sprintf("peer certificate %v is not valid for %q", names, claimed))
+		}
+		return nil, status.Error(codes.PermissionDenied, fmt.Sprintf("peer certificate %v is not valid for any server", names))
+	}
+}
+
+// peerCertificateNames returns names of the (verified) client certificate of the caller.
+func peerCertificateNames(ctx context.Context) ([]string, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, errors.New("no peer info")
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return nil, errors.New("no client certificate")
+	}
+	return security.CertificateNames(tlsInfo.State.PeerCertificates[0]), nil
+}
@@ -0,0 +1,56 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lizhaoliu/konsen/v2/core"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestPeerSecretInterceptor(t *testing.T) {
+	interceptor := peerSecretInterceptor([]byte("secret"))
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return fromPeer(ctx), nil
+	}
+
+	for _, test := range []struct {
+		name   string
+		method string
+		md     metadata.MD
+		code   codes.Code
+		peer   bool
+	}{
+		{"peer", "/konsen.Raft/AppendData", metadata.Pairs(peerSecretMetadata, "secret"), codes.OK, true},
+		{"wrong secret", "/konsen.Raft/AppendEntries", metadata.Pairs(peerSecretMetadata, "secreT"), codes.Unauthenticated, false},
+		{"no secret", "/konsen.Raft/RequestVote", nil, codes.Unauthenticated, false},
+		{"client", "/konsen.kv.KV/Put", nil, codes.OK, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), test.md)
+			resp, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: test.method}, handler)
+			if code := status.Code(err); code != test.code {
+				t.Fatalf("got code %v, want %v", code, test.code)
+			}
+			if err == nil && resp.(bool) != test.peer {
+				t.Fatalf("got peer %v, want %v", resp, test.peer)
+			}
+		})
+	}
+}
+
+// Credentials forwarded by a follower are attached to the context of the leader as sent by the client.
+func TestAuthorizationRoundTrip(t *testing.T) {
+	for _, creds := range []*core.Credentials{
+		{Username: "alice", Password: "p:w"},
+		{Token: "token"},
+	} {
+		got, ok := parseAuthorization(formatAuthorization(creds))
+		if !ok || *got != *creds {
+			t.Fatalf("got %+v, %v, want %+v", got, ok, creds)
+		}
+	}
+}
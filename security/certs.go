@@ -0,0 +1,220 @@
+// Package security provides TLS configurations for peer and client connections, with certificates that are reloaded
+// from disk when they change.
+package security
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Minimum interval between checking certificate files for changes.
+const defaultCheckInterval = 10 * time.Second
+
+// CertReloader holds a certificate and a CA pool loaded from files, and reloads them when the files change. Files are
+// checked lazily on TLS handshakes, at most once every check interval. A failed reload is logged and the previously
+// loaded certificates keep being used.
+type CertReloader struct {
+	caFile        string
+	certFile      string
+	keyFile       string
+	checkInterval time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	pool      *x509.CertPool
+	modTimes  [3]time.Time // Modification times of CA, certificate and key files when they were loaded.
+	lastCheck time.Time
+}
+
+// CertReloaderConfig is the configuration of a CertReloader: the files to load the certificate and CA pool from, and
+// how often to check them for changes.
+type CertReloaderConfig struct {
+	CAFile        string        // PEM encoded CA certificates to verify peers with.
+	CertFile      string        // PEM encoded certificate of this server.
+	KeyFile       string        // PEM encoded private key of the certificate.
+	CheckInterval time.Duration // Minimum interval between checking files for changes, defaults to 10 seconds.
+}
+
+// NewCertReloader loads the certificate and CA pool from given files.
+func NewCertReloader(config CertReloaderConfig) (*CertReloader, error) {
+	if config.CAFile == "" || config.CertFile == "" || config.KeyFile == "" {
+		return nil, errors.New("CA, certificate and key files must all be specified")
+	}
+	if config.CheckInterval == 0 {
+		config.CheckInterval = defaultCheckInterval
+	}
+	r := &CertReloader{
+		caFile:        config.CAFile,
+		certFile:      config.CertFile,
+		keyFile:       config.KeyFile,
+		checkInterval: config.CheckInterval,
+	}
+	modTimes, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTimes); err != nil {
+		return nil, err
+	}
+	r.lastCheck = time.Now()
+	return r, nil
+}
+
+// stat returns modification times of the CA, certificate and key files.
+func (r *CertReloader) stat() ([3]time.Time, error) {
+	var modTimes [3]time.Time
+	for i, file := range []string{r.caFile, r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return modTimes, fmt.Errorf("failed to stat %q: %w", file, err)
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// load reads the certificate and CA pool from files, it must be called with mu held (or before r is shared).
+func (r *CertReloader) load(modTimes [3]time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+	pool, err := loadCertPool(r.caFile)
+	if err != nil {
+		return err
+	}
+	r.cert, r.pool, r.modTimes = &cert, pool, modTimes
+	return nil
+}
+
+// current returns the certificate and CA pool, after reloading them if the files have changed.
+func (r *CertReloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now := time.Now(); now.Sub(r.lastCheck) >= r.checkInterval {
+		r.lastCheck = now
+		modTimes, err := r.stat()
+		if err != nil {
+			log.Warnf("Failed to check certificates for changes: %v", err)
+		} else if modTimes != r.modTimes {
+			if err := r.load(modTimes); err != nil {
+				log.Warnf("Failed to reload certificates, keep using the previous ones: %v", err)
+			} else {
+				log.Infof("Reloaded certificates from %q, %q and %q.", r.caFile, r.certFile, r.keyFile)
+			}
+		}
+	}
+	return r.cert, r.pool
+}
+
+// ServerConfig returns the TLS configuration of a server. If clientAuth is true, clients must present a certificate
+// signed by the CA (mutual TLS).
+func (r *CertReloader) ServerConfig(clientAuth bool) *tls.Config {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+	}
+	if clientAuth {
+		// Client certificates are verified against the current CA pool, rather than a fixed ClientCAs.
+		config.ClientAuth = tls.RequireAnyClientCert
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return r.verify(rawCerts, "", x509.ExtKeyUsageClientAuth)
+		}
+	}
+	return config
+}
+
+// ClientConfig returns the TLS configuration of a client connecting to the server with given name, whose certificate
+// must be valid for the name. The client presents its certificate if the server asks for one.
+func (r *CertReloader) ClientConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// The server certificate is verified against the current CA pool by VerifyPeerCertificate.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return r.verify(rawCerts, serverName, x509.ExtKeyUsageServerAuth)
+		},
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+	}
+}
+
+// verify verifies a certificate chain sent by the peer against the current CA pool.
+func (r *CertReloader) verify(rawCerts [][]byte, name string, usage x509.ExtKeyUsage) error {
+	if len(rawCerts) == 0 {
+		return errors.New("no certificate presented")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		certs[i] = cert
+	}
+	_, pool := r.current()
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: x509.NewCertPool(),
+		DNSName:       name,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(opts)
+	return err
+}
+
+// CertificateNames returns the names a certificate identifies: its DNS names and common name.
+func CertificateNames(cert *x509.Certificate) []string {
+	names := append([]string(nil), cert.DNSNames...)
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	return names
+}
+
+// LoadClientConfig returns a static TLS configuration for clients, which verifies servers against the CA and
+// presents the certificate if it is given.
+func LoadClientConfig(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	pool, err := loadCertPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	buf, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(buf) {
+		return nil, fmt.Errorf("no certificate found in CA file %q", caFile)
+	}
+	return pool, nil
+}
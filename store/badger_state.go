@@ -0,0 +1,187 @@
+package store
+
+import (
+	"bytes"
+
+	"github.com/dgraph-io/badger/v2"
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+	"github.com/sirupsen/logrus"
+)
+
+// BadgerState stores Raft persistent state (current term and voted for) and key-value pairs in a Badger database.
+type BadgerState struct {
+	db *badger.DB
+}
+
+type BadgerStateConfig struct {
+	Dir      string
+	ReadOnly bool
+}
+
+func NewBadgerState(config BadgerStateConfig) (*BadgerState, error) {
+	logrus.Infof("Badger state DB file set to: %s", config.Dir)
+	db, err := badger.Open(badger.DefaultOptions(config.Dir).WithReadOnly(config.ReadOnly).WithLogger(logrus.StandardLogger()))
+	if err != nil {
+		return nil, err
+	}
+	return &BadgerState{db: db}, nil
+}
+
+func (b *BadgerState) GetCurrentTerm() (uint64, error) {
+	var term uint64
+	if err := b.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(currentTermKey)
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			term = bytesToUint64(val)
+			return nil
+		})
+	}); err != nil && err != badger.ErrKeyNotFound {
+		return 0, err
+	}
+	return term, nil
+}
+
+func (b *BadgerState) SetCurrentTerm(term uint64) error {
+	return b.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(currentTermKey, uint64ToBytes(term))
+	})
+}
+
+func (b *BadgerState) GetVotedFor() (string, error) {
+	var votedFor string
+	if err := b.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(votedForKey)
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			votedFor = string(val)
+			return nil
+		})
+	}); err != nil && err != badger.ErrKeyNotFound {
+		return "", err
+	}
+	return votedFor, nil
+}
+
+func (b *BadgerState) SetVotedFor(candidateID string) error {
+	return b.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(votedForKey, []byte(candidateID))
+	})
+}
+
+func (b *BadgerState) SetValue(key []byte, value []byte) error {
+	return b.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(kvKey(key), value)
+	})
+}
+
+func (b *BadgerState) GetValue(key []byte) ([]byte, error) {
+	var value []byte
+	if err := b.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(kvKey(key))
+		if err != nil {
+			return err
+		}
+		value, err = item.ValueCopy(nil)
+		return err
+	}); err != nil && err != badger.ErrKeyNotFound {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (b *BadgerState) DeleteValue(key []byte) error {
+	return b.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(kvKey(key))
+	})
+}
+
+func (b *BadgerState) ApplyKVs(index uint64, kvs []*konsen.KV) error {
+	return b.db.Update(func(txn *badger.Txn) error {
+		for _, kv := range kvs {
+			var err error
+			if kv.GetDelete() {
+				err = txn.Delete(kvKey(kv.GetKey()))
+			} else {
+				err = txn.Set(kvKey(kv.GetKey()), kv.GetValue())
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return txn.Set(appliedIndexKey, uint64ToBytes(index))
+	})
+}
+
+func (b *BadgerState) AppliedIndex() (uint64, error) {
+	var index uint64
+	if err := b.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(appliedIndexKey)
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			index = bytesToUint64(val)
+			return nil
+		})
+	}); err != nil && err != badger.ErrKeyNotFound {
+		return 0, err
+	}
+	return index, nil
+}
+
+func (b *BadgerState) ScanValues(startKey []byte, endKey []byte, fn func(key []byte, value []byte) bool) error {
+	return b.db.View(func(txn *badger.Txn) error {
+		return scanBadgerValues(txn, startKey, endKey, fn)
+	})
+}
+
+func (b *BadgerState) SnapshotValues() (KVSnapshot, error) {
+	return &badgerSnapshot{txn: b.db.NewTransaction(false)}, nil
+}
+
+// badgerSnapshot is a KVSnapshot of a read-only Badger transaction, which reads the database as of its start.
+type badgerSnapshot struct {
+	txn *badger.Txn
+}
+
+func (s *badgerSnapshot) ScanValues(startKey []byte, endKey []byte, fn func(key []byte, value []byte) bool) error {
+	return scanBadgerValues(s.txn, startKey, endKey, fn)
+}
+
+func (s *badgerSnapshot) Close() error {
+	s.txn.Discard()
+	return nil
+}
+
+func scanBadgerValues(txn *badger.Txn, startKey []byte, endKey []byte, fn func(key []byte, value []byte) bool) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = kvKeyPrefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	for it.Seek(kvKey(startKey)); it.Valid(); it.Next() {
+		key := it.Item().Key()[len(kvKeyPrefix):]
+		if endKey != nil && bytes.Compare(key, endKey) >= 0 {
+			return nil
+		}
+		var cont bool
+		if err := it.Item().Value(func(val []byte) error {
+			cont = fn(key, val)
+			return nil
+		}); err != nil {
+			return err
+		}
+		if !cont {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (b *BadgerState) Close() error {
+	return b.db.Close()
+}
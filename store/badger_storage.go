@@ -7,81 +7,38 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// Badger is a storage implementation that stores Raft logs and state in two separate Badger databases.
 type Badger struct {
-	logDB   *badger.DB
-	stateDB *badger.DB
+	*BadgerState
+
+	logDB *badger.DB
 }
 
 type BadgerConfig struct {
 	LogDir   string
 	StateDir string
+	ReadOnly bool
 }
 
 func NewBadger(config BadgerConfig) (*Badger, error) {
 	logrus.Infof("Badger log DB file set to: %s", config.LogDir)
-	logDB, err := badger.Open(badger.DefaultOptions(config.LogDir))
+	logDB, err := badger.Open(badger.DefaultOptions(config.LogDir).WithReadOnly(config.ReadOnly).WithLogger(logrus.StandardLogger()))
 	if err != nil {
 		return nil, err
 	}
 
-	logrus.Infof("Badger state DB file set to: %s", config.StateDir)
-	stateDB, err := badger.Open(badger.DefaultOptions(config.StateDir))
+	state, err := NewBadgerState(BadgerStateConfig{Dir: config.StateDir, ReadOnly: config.ReadOnly})
 	if err != nil {
+		logDB.Close()
 		return nil, err
 	}
 
 	return &Badger{
-		logDB:   logDB,
-		stateDB: stateDB,
+		BadgerState: state,
+		logDB:       logDB,
 	}, nil
 }
 
-func (b *Badger) GetCurrentTerm() (uint64, error) {
-	var term uint64
-	if err := b.stateDB.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(currentTermKey)
-		if err != nil {
-			return err
-		}
-		return item.Value(func(val []byte) error {
-			term = bytesToUint64(val)
-			return nil
-		})
-	}); err != nil && err != badger.ErrKeyNotFound {
-		return 0, err
-	}
-	return term, nil
-}
-
-func (b *Badger) SetCurrentTerm(term uint64) error {
-	return b.stateDB.Update(func(txn *badger.Txn) error {
-		return txn.Set(currentTermKey, uint64ToBytes(term))
-	})
-}
-
-func (b *Badger) GetVotedFor() (string, error) {
-	var votedFor string
-	if err := b.stateDB.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(votedForKey)
-		if err != nil {
-			return err
-		}
-		return item.Value(func(val []byte) error {
-			votedFor = string(val)
-			return nil
-		})
-	}); err != nil && err != badger.ErrKeyNotFound {
-		return "", err
-	}
-	return votedFor, nil
-}
-
-func (b *Badger) SetVotedFor(candidateID string) error {
-	return b.stateDB.Update(func(txn *badger.Txn) error {
-		return txn.Set(votedForKey, []byte(candidateID))
-	})
-}
-
 func (b *Badger) GetLog(logIndex uint64) (*konsen.Log, error) {
 	var log *konsen.Log
 	if err := b.logDB.View(func(txn *badger.Txn) error {
@@ -153,6 +110,24 @@ func (b *Badger) WriteLogs(logs []*konsen.Log) error {
 	})
 }
 
+func (b *Badger) FirstLogIndex() (uint64, error) {
+	var index uint64
+	if err := b.logDB.View(func(txn *badger.Txn) error {
+		itOpt := badger.DefaultIteratorOptions
+		itOpt.PrefetchValues = false
+		it := txn.NewIterator(itOpt)
+		defer it.Close()
+		it.Rewind()
+		if it.Valid() {
+			index = bytesToUint64(it.Item().Key())
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return index, nil
+}
+
 func (b *Badger) LastLogIndex() (uint64, error) {
 	var index uint64
 	if err := b.logDB.View(func(txn *badger.Txn) error {
@@ -217,16 +192,8 @@ func (b *Badger) DeleteLogsFrom(minLogIndex uint64) error {
 
 }
 
-func (b *Badger) SetValue(key []byte, value []byte) error {
-	panic("implement me")
-}
-
-func (b *Badger) GetValue(key []byte) ([]byte, error) {
-	panic("implement me")
-}
-
 func (b *Badger) Close() error {
-	stateDBErr := b.stateDB.Close()
+	stateDBErr := b.BadgerState.Close()
 	logDBErr := b.logDB.Close()
 	if stateDBErr != nil {
 		return stateDBErr
@@ -1,6 +1,8 @@
 package store
 
 import (
+	"bytes"
+
 	"github.com/boltdb/bolt"
 	"github.com/golang/protobuf/proto"
 	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
@@ -23,15 +25,23 @@ type BoltDB struct {
 
 type BoltDBConfig struct {
 	FilePath string
+	ReadOnly bool
 }
 
 func NewBoltDB(config BoltDBConfig) (*BoltDB, error) {
 	logrus.Infof("BoltDB local log file set to: %s", config.FilePath)
-	db, err := bolt.Open(config.FilePath, 0600, nil)
+	db, err := bolt.Open(config.FilePath, 0600, &bolt.Options{ReadOnly: config.ReadOnly})
 	if err != nil {
 		return nil, err
 	}
 
+	if config.ReadOnly {
+		return &BoltDB{
+			filePath: config.FilePath,
+			db:       db,
+		}, nil
+	}
+
 	if err := db.Update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists(logsBucketName)
 		if err != nil {
@@ -186,6 +196,22 @@ func (b *BoltDB) DeleteLogsFrom(minLogIndex uint64) error {
 	})
 }
 
+func (b *BoltDB) FirstLogIndex() (uint64, error) {
+	var index uint64
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(logsBucketName).Cursor()
+		buf, _ := c.First()
+		if buf == nil {
+			return nil
+		}
+		index = bytesToUint64(buf)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return index, nil
+}
+
 func (b *BoltDB) LastLogIndex() (uint64, error) {
 	var index uint64
 	if err := b.db.View(func(tx *bolt.Tx) error {
@@ -246,6 +272,89 @@ func (b *BoltDB) GetValue(key []byte) ([]byte, error) {
 	return value, nil
 }
 
+func (b *BoltDB) DeleteValue(key []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(kvBucketName)
+		return b.Delete(key)
+	})
+}
+
+func (b *BoltDB) ApplyKVs(index uint64, kvs []*konsen.KV) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		kvBucket := tx.Bucket(kvBucketName)
+		for _, kv := range kvs {
+			var err error
+			if kv.GetDelete() {
+				err = kvBucket.Delete(kv.GetKey())
+			} else {
+				err = kvBucket.Put(kv.GetKey(), kv.GetValue())
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(statesBucketName).Put(appliedIndexKey, uint64ToBytes(index))
+	})
+}
+
+func (b *BoltDB) AppliedIndex() (uint64, error) {
+	var index uint64
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(statesBucketName)
+		buf := b.Get(appliedIndexKey)
+		if buf == nil {
+			return nil
+		}
+		index = bytesToUint64(buf)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return index, nil
+}
+
+func (b *BoltDB) ScanValues(startKey []byte, endKey []byte, fn func(key []byte, value []byte) bool) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return scanBoltValues(tx, startKey, endKey, fn)
+	})
+}
+
+// SnapshotValues returns a snapshot of a read-only Bolt transaction. The database file can not grow its memory map
+// while the snapshot is open, writes that need to do so wait for it to be closed.
+func (b *BoltDB) SnapshotValues() (KVSnapshot, error) {
+	tx, err := b.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	return &boltSnapshot{tx: tx}, nil
+}
+
+// boltSnapshot is a KVSnapshot of a read-only Bolt transaction.
+type boltSnapshot struct {
+	tx *bolt.Tx
+}
+
+func (s *boltSnapshot) ScanValues(startKey []byte, endKey []byte, fn func(key []byte, value []byte) bool) error {
+	return scanBoltValues(s.tx, startKey, endKey, fn)
+}
+
+func (s *boltSnapshot) Close() error {
+	return s.tx.Rollback()
+}
+
+func scanBoltValues(tx *bolt.Tx, startKey []byte, endKey []byte, fn func(key []byte, value []byte) bool) error {
+	c := tx.Bucket(kvBucketName).Cursor()
+	for k, v := c.Seek(startKey); k != nil; k, v = c.Next() {
+		if endKey != nil && bytes.Compare(k, endKey) >= 0 {
+			return nil
+		}
+		if !fn(k, v) {
+			return nil
+		}
+	}
+	return nil
+}
+
 func (b *BoltDB) Close() error {
 	return b.db.Close()
 }
@@ -0,0 +1,37 @@
+package store
+
+import "io"
+
+// Composite is a Storage that delegates to separate log, stable and key-value stores, which allows to mix backends,
+// e.g. keeping logs in a WAL while the rest lives in Badger.
+type Composite struct {
+	LogStore
+	StableStore
+	KVStore
+}
+
+// NewComposite combines the given stores into a Storage.
+func NewComposite(logs LogStore, stable StableStore, kv KVStore) *Composite {
+	return &Composite{
+		LogStore:    logs,
+		StableStore: stable,
+		KVStore:     kv,
+	}
+}
+
+// Close closes every underlying store that implements io.Closer, stores shared by multiple roles are closed once.
+func (c *Composite) Close() error {
+	var firstErr error
+	closed := make(map[io.Closer]bool)
+	for _, s := range []interface{}{c.LogStore, c.StableStore, c.KVStore} {
+		closer, ok := s.(io.Closer)
+		if !ok || closed[closer] {
+			continue
+		}
+		closed[closer] = true
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,235 @@
+package store
+
+import (
+	"sync"
+	"sync/atomic"
+
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+)
+
+// LogCache is a LogStore that wraps another LogStore, keeping a bounded number of the most recent log entries as well
+// as the last log index and term in memory. Writes go through to the underlying store. In steady state, replication
+// only reads entries near the end of the log, which are then all served from memory.
+type LogCache struct {
+	store    LogStore
+	capacity int
+
+	mu        sync.RWMutex
+	entries   []*konsen.Log // Contiguous suffix of the log: entries[i] has index entries[0].Index+i.
+	lastIndex uint64        // Index of the last log entry.
+	lastTerm  uint64        // Term of the last log entry.
+
+	hits   uint64
+	misses uint64
+}
+
+// NewLogCache creates a LogCache that caches up to capacity entries of the given store.
+func NewLogCache(store LogStore, capacity int) (*LogCache, error) {
+	c := &LogCache{
+		store:    store,
+		capacity: capacity,
+	}
+	if err := c.reloadLast(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Stats returns the number of reads served from the cache and the number of reads that fell through to the
+// underlying store.
+func (c *LogCache) Stats() (hits uint64, misses uint64) {
+	return atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+}
+
+// reloadLast reloads last log index and term from the underlying store.
+func (c *LogCache) reloadLast() error {
+	lastIndex, err := c.store.LastLogIndex()
+	if err != nil {
+		return err
+	}
+	lastTerm, err := c.store.LastLogTerm()
+	if err != nil {
+		return err
+	}
+	c.lastIndex, c.lastTerm = lastIndex, lastTerm
+	return nil
+}
+
+// cached returns the cached entry at given index, or nil if it is not cached.
+func (c *LogCache) cached(logIndex uint64) *konsen.Log {
+	if len(c.entries) == 0 {
+		return nil
+	}
+	first := c.entries[0].GetIndex()
+	if logIndex < first || logIndex > c.entries[len(c.entries)-1].GetIndex() {
+		return nil
+	}
+	return c.entries[logIndex-first]
+}
+
+// truncateFrom drops cached entries with index greater equal than given index.
+func (c *LogCache) truncateFrom(logIndex uint64) {
+	if len(c.entries) == 0 {
+		return
+	}
+	first := c.entries[0].GetIndex()
+	if logIndex <= first {
+		c.entries = nil
+		return
+	}
+	if n := logIndex - first; n < uint64(len(c.entries)) {
+		c.entries = c.entries[:n]
+	}
+}
+
+func (c *LogCache) hit() {
+	atomic.AddUint64(&c.hits, 1)
+}
+
+func (c *LogCache) miss() {
+	atomic.AddUint64(&c.misses, 1)
+}
+
+func (c *LogCache) GetLog(logIndex uint64) (*konsen.Log, error) {
+	c.mu.RLock()
+	if log := c.cached(logIndex); log != nil {
+		c.mu.RUnlock()
+		c.hit()
+		return log, nil
+	}
+	lastIndex := c.lastIndex
+	c.mu.RUnlock()
+
+	if logIndex > lastIndex {
+		c.hit()
+		return nil, nil
+	}
+	c.miss()
+	return c.store.GetLog(logIndex)
+}
+
+func (c *LogCache) GetLogsFrom(minLogIndex uint64) ([]*konsen.Log, error) {
+	c.mu.RLock()
+	if minLogIndex > c.lastIndex {
+		c.mu.RUnlock()
+		c.hit()
+		return nil, nil
+	}
+	if len(c.entries) > 0 && minLogIndex >= c.entries[0].GetIndex() {
+		entries := c.entries[minLogIndex-c.entries[0].GetIndex():]
+		logs := make([]*konsen.Log, len(entries))
+		copy(logs, entries)
+		c.mu.RUnlock()
+		c.hit()
+		return logs, nil
+	}
+	c.mu.RUnlock()
+
+	c.miss()
+	return c.store.GetLogsFrom(minLogIndex)
+}
+
+func (c *LogCache) GetLogTerm(logIndex uint64) (uint64, error) {
+	c.mu.RLock()
+	if logIndex == c.lastIndex {
+		term := c.lastTerm
+		c.mu.RUnlock()
+		c.hit()
+		return term, nil
+	}
+	if log := c.cached(logIndex); log != nil {
+		c.mu.RUnlock()
+		c.hit()
+		return log.GetTerm(), nil
+	}
+	lastIndex := c.lastIndex
+	c.mu.RUnlock()
+
+	if logIndex == 0 || logIndex > lastIndex {
+		c.hit()
+		return 0, nil
+	}
+	c.miss()
+	return c.store.GetLogTerm(logIndex)
+}
+
+func (c *LogCache) WriteLog(log *konsen.Log) error {
+	return c.WriteLogs([]*konsen.Log{log})
+}
+
+func (c *LogCache) WriteLogs(logs []*konsen.Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.store.WriteLogs(logs); err != nil {
+		// The underlying store may be partially written, start over from what it reports.
+		c.entries = nil
+		if reloadErr := c.reloadLast(); reloadErr != nil {
+			return reloadErr
+		}
+		return err
+	}
+
+	first := logs[0].GetIndex()
+	if first <= c.lastIndex {
+		// Overwriting existing entries, whether entries after the written ones are kept depends on the underlying
+		// store, so reload the last index and term from it.
+		c.truncateFrom(first)
+		if err := c.reloadLast(); err != nil {
+			c.entries = nil
+			return err
+		}
+	} else {
+		last := logs[len(logs)-1]
+		c.lastIndex, c.lastTerm = last.GetIndex(), last.GetTerm()
+	}
+
+	if len(c.entries) > 0 && c.entries[len(c.entries)-1].GetIndex()+1 != first {
+		c.entries = nil
+	}
+	c.entries = append(c.entries, logs...)
+	if c.lastIndex != c.entries[len(c.entries)-1].GetIndex() {
+		// The cache must be a suffix of the log.
+		c.entries = nil
+	}
+	if n := len(c.entries) - c.capacity; n > 0 {
+		// Evicted entries are released once append outgrows the backing array.
+		c.entries = c.entries[n:]
+	}
+	return nil
+}
+
+func (c *LogCache) FirstLogIndex() (uint64, error) {
+	return c.store.FirstLogIndex()
+}
+
+func (c *LogCache) LastLogIndex() (uint64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.lastIndex, nil
+}
+
+func (c *LogCache) LastLogTerm() (uint64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.lastTerm, nil
+}
+
+func (c *LogCache) DeleteLogsFrom(minLogIndex uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := c.store.DeleteLogsFrom(minLogIndex)
+	c.truncateFrom(minLogIndex)
+	if reloadErr := c.reloadLast(); reloadErr != nil {
+		c.entries = nil
+		return reloadErr
+	}
+	return err
+}
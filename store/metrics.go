@@ -0,0 +1,31 @@
+package store
+
+import (
+	"time"
+
+	"github.com/lizhaoliu/konsen/v2/metrics"
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+)
+
+// MetricsLogStore is a LogStore that wraps another LogStore and observes the latency of writes to it.
+type MetricsLogStore struct {
+	LogStore
+	metrics *metrics.Metrics
+}
+
+// NewMetricsLogStore creates a MetricsLogStore on top of the given store, which observes writes with the given metrics.
+func NewMetricsLogStore(store LogStore, m *metrics.Metrics) *MetricsLogStore {
+	return &MetricsLogStore{LogStore: store, metrics: m}
+}
+
+func (m *MetricsLogStore) WriteLog(log *konsen.Log) error {
+	start := time.Now()
+	defer func() { m.metrics.StorageWriteSeconds.Observe(time.Since(start).Seconds()) }()
+	return m.LogStore.WriteLog(log)
+}
+
+func (m *MetricsLogStore) WriteLogs(logs []*konsen.Log) error {
+	start := time.Now()
+	defer func() { m.metrics.StorageWriteSeconds.Observe(time.Since(start).Seconds()) }()
+	return m.LogStore.WriteLogs(logs)
+}
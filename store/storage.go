@@ -4,20 +4,8 @@ import (
 	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
 )
 
-// Storage provides an interface for a set of local persistent storage operations.
-type Storage interface {
-	// GetCurrentTerm returns the latest term server has seen (initialized to 0 on first boot, increases monotonically).
-	GetCurrentTerm() (uint64, error)
-
-	// SetCurrentTerm sets the current term.
-	SetCurrentTerm(term uint64) error
-
-	// GetVotedFor returns the candidate ID that received a vote in current term, empty/blank if none.
-	GetVotedFor() (string, error)
-
-	// SetVotedFor sets the candidate ID that received a vote in current term.
-	SetVotedFor(candidateID string) error
-
+// LogStore provides an interface for Raft log operations.
+type LogStore interface {
 	// GetLog returns the log entry on given index.
 	GetLog(logIndex uint64) (*konsen.Log, error)
 
@@ -33,6 +21,9 @@ type Storage interface {
 	// WriteLogs writes the given log entries into storage.
 	WriteLogs(logs []*konsen.Log) error
 
+	// FirstLogIndex returns the first(oldest) log entry's index, 0 if there is no log.
+	FirstLogIndex() (uint64, error)
+
 	// LastLogIndex returns the last(newest) log entry's index.
 	LastLogIndex() (uint64, error)
 
@@ -41,12 +32,66 @@ type Storage interface {
 
 	// DeleteLogsFrom deletes logs with index greater equal than given index.
 	DeleteLogsFrom(minLogIndex uint64) error
+}
 
-	// Following methods are not required by Raft.
+// StableStore provides an interface for Raft persistent state other than logs.
+type StableStore interface {
+	// GetCurrentTerm returns the latest term server has seen (initialized to 0 on first boot, increases monotonically).
+	GetCurrentTerm() (uint64, error)
+
+	// SetCurrentTerm sets the current term.
+	SetCurrentTerm(term uint64) error
 
+	// GetVotedFor returns the candidate ID that received a vote in current term, empty/blank if none.
+	GetVotedFor() (string, error)
+
+	// SetVotedFor sets the candidate ID that received a vote in current term.
+	SetVotedFor(candidateID string) error
+}
+
+// KVStore provides an interface for the key-value store that committed logs are applied to, it is not required by
+// Raft.
+type KVStore interface {
 	// SetValue stores a key-value pair.
 	SetValue(key []byte, value []byte) error
 
 	// GetValue returns value of a key.
 	GetValue(key []byte) ([]byte, error)
+
+	// DeleteValue deletes a key, deleting a non-existent key is not an error.
+	DeleteValue(key []byte) error
+
+	// ScanValues calls fn for every key-value pair with startKey <= key < endKey in ascending key order, a nil endKey
+	// means no upper bound. Scan stops when fn returns false. Key and value are only valid during the call.
+	ScanValues(startKey []byte, endKey []byte, fn func(key []byte, value []byte) bool) error
+
+	// ApplyKVs sets (or deletes) key-value pairs from the log entry at given index, and records the index as the
+	// applied index, atomically.
+	ApplyKVs(index uint64, kvs []*konsen.KV) error
+
+	// AppliedIndex returns the index of the latest log entry applied to the store, 0 if none.
+	AppliedIndex() (uint64, error)
+
+	// SnapshotValues returns a consistent view of the key-value pairs as of now, which later writes do not change.
+	SnapshotValues() (KVSnapshot, error)
+}
+
+// KVSnapshot is a point-in-time view of a KVStore, it must be closed after use. It may hold back the reclamation of
+// space in the store, so it should not be kept longer than needed.
+type KVSnapshot interface {
+	// ScanValues is KVStore.ScanValues on the snapshot.
+	ScanValues(startKey []byte, endKey []byte, fn func(key []byte, value []byte) bool) error
+
+	// Close releases the snapshot.
+	Close() error
+}
+
+// Storage provides an interface for a set of local persistent storage operations.
+type Storage interface {
+	LogStore
+	StableStore
+	KVStore
+
+	// Close releases all resources held by the storage.
+	Close() error
 }
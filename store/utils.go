@@ -3,8 +3,12 @@ package store
 import "encoding/binary"
 
 var (
-	currentTermKey = []byte("current_term")
-	votedForKey    = []byte("voted_for")
+	currentTermKey  = []byte("current_term")
+	votedForKey     = []byte("voted_for")
+	appliedIndexKey = []byte("applied_index")
+
+	// kvKeyPrefix separates application key-value pairs from Raft state in databases that share a single key space.
+	kvKeyPrefix = []byte("kv/")
 )
 
 func bytesToUint64(b []byte) uint64 {
@@ -16,3 +20,23 @@ func uint64ToBytes(v uint64) []byte {
 	binary.BigEndian.PutUint64(b, v)
 	return b
 }
+
+// PrefixEnd returns the smallest key that is greater than all keys with the given prefix, or nil if there is no such
+// key (the prefix is empty or all 0xff).
+func PrefixEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+func kvKey(key []byte) []byte {
+	k := make([]byte, 0, len(kvKeyPrefix)+len(key))
+	k = append(k, kvKeyPrefix...)
+	return append(k, key...)
+}
@@ -0,0 +1,322 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+)
+
+const (
+	walSegmentExt = ".wal"
+
+	// Every record in a segment file is laid out as: payload length (4 bytes) | payload CRC (4 bytes) | payload, where
+	// payload is a marshaled konsen.Log.
+	walRecordHeaderSize = 8
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errTornRecord indicates a record is incomplete or fails the CRC check, typically caused by a crash in the middle of
+// a write.
+var errTornRecord = errors.New("torn or corrupted WAL record")
+
+// walSegment is an append-only file that holds a contiguous range of log entries.
+type walSegment struct {
+	path       string
+	file       *os.File
+	firstIndex uint64     // Index of the first entry in the segment.
+	entries    []walEntry // In-memory index of the segment, entries[i] is the entry with log index firstIndex+i.
+	size       int64      // Size of valid data in the segment file.
+}
+
+// walEntry locates a log entry in its segment file.
+type walEntry struct {
+	offset int64  // Offset of the record in the segment file.
+	length uint32 // Length of the record payload.
+	term   uint64 // Term of the log entry, kept in memory so term lookups never hit the disk.
+}
+
+func walSegmentPath(dir string, firstIndex uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", firstIndex, walSegmentExt))
+}
+
+// listWALSegments returns the first indices of all segment files in the directory, in ascending order.
+func listWALSegments(dir string) ([]uint64, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+walSegmentExt))
+	if err != nil {
+		return nil, err
+	}
+	var indices []uint64
+	for _, name := range names {
+		base := strings.TrimSuffix(filepath.Base(name), walSegmentExt)
+		index, err := strconv.ParseUint(base, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unrecognized WAL segment file %q", name)
+		}
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	return indices, nil
+}
+
+// createWALSegment creates a new empty segment whose first entry will have the given index.
+func createWALSegment(dir string, firstIndex uint64) (*walSegment, error) {
+	path := walSegmentPath(dir, firstIndex)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syncDir(dir); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &walSegment{
+		path:       path,
+		file:       file,
+		firstIndex: firstIndex,
+	}, nil
+}
+
+// openWALSegment opens an existing segment and rebuilds its index by scanning all records. If repairTail is true, a
+// torn record at the end of the segment and everything after it is truncated, otherwise an error is returned.
+func openWALSegment(dir string, firstIndex uint64, readOnly bool, repairTail bool) (*walSegment, error) {
+	path := walSegmentPath(dir, firstIndex)
+	flag := os.O_RDWR
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	seg := &walSegment{
+		path:       path,
+		file:       file,
+		firstIndex: firstIndex,
+	}
+	if err := seg.scan(); err != nil {
+		if err != errTornRecord || !repairTail {
+			file.Close()
+			return nil, fmt.Errorf("failed to scan WAL segment %q: %w", path, err)
+		}
+		if readOnly {
+			// Leave the file untouched, simply ignore the torn tail.
+			return seg, nil
+		}
+		if err := seg.truncate(seg.size); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return seg, nil
+}
+
+// scan reads all records in the segment and rebuilds the in-memory index. On errTornRecord, the index holds all
+// valid records preceding the torn one, and size is the offset of the torn record. A bad record that is followed by
+// more data, or whose length runs past valid records, cannot be the result of an interrupted append, and is reported
+// as corruption.
+func (s *walSegment) scan() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReaderSize(s.file, 1<<20)
+	header := make([]byte, walRecordHeaderSize)
+	var offset int64
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			if err == io.ErrUnexpectedEOF {
+				return errTornRecord
+			}
+			return err
+		}
+		length := binary.BigEndian.Uint32(header[0:4])
+		crc := binary.BigEndian.Uint32(header[4:8])
+		// A record running past the end of the file is torn, its length is not trusted for the allocation. Unless a valid
+		// record follows it, in which case the length itself is corrupted.
+		if int64(length) > info.Size()-offset-walRecordHeaderSize {
+			rest, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			if hasWALRecord(rest, s.firstIndex+uint64(len(s.entries))) {
+				return fmt.Errorf("%w: bad WAL record length at offset %d", ErrCorruptedLog, offset)
+			}
+			return errTornRecord
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return errTornRecord
+			}
+			return err
+		}
+		end := offset + walRecordHeaderSize + int64(length)
+		log := &konsen.Log{}
+		if crc32.Checksum(payload, crcTable) != crc || proto.Unmarshal(payload, log) != nil {
+			if end < info.Size() {
+				return fmt.Errorf("%w: bad WAL record at offset %d", ErrCorruptedLog, offset)
+			}
+			return errTornRecord
+		}
+		if expected := s.firstIndex + uint64(len(s.entries)); log.GetIndex() != expected {
+			return fmt.Errorf("log index %d at offset %d mismatches expected index %d", log.GetIndex(), offset, expected)
+		}
+		s.entries = append(s.entries, walEntry{offset: offset, length: length, term: log.GetTerm()})
+		offset = end
+		s.size = offset
+	}
+}
+
+// hasWALRecord returns true if a valid record of a log entry with index greater than given one starts anywhere in buf.
+func hasWALRecord(buf []byte, index uint64) bool {
+	for p := 0; p+walRecordHeaderSize < len(buf); p++ {
+		length := int(binary.BigEndian.Uint32(buf[p : p+4]))
+		payload := buf[p+walRecordHeaderSize:]
+		if length == 0 || length > len(payload) {
+			continue
+		}
+		payload = payload[:length]
+		// The index is the first field of a marshaled log, it rules out most offsets before the CRC is computed.
+		if payload[0] != 0x08 {
+			continue
+		}
+		if i, n := binary.Uvarint(payload[1:]); n <= 0 || i <= index {
+			continue
+		}
+		if crc32.Checksum(payload, crcTable) == binary.BigEndian.Uint32(buf[p+4:p+8]) {
+			return true
+		}
+	}
+	return false
+}
+
+// lastIndex returns index of the last entry in the segment, or firstIndex-1 if the segment is empty.
+func (s *walSegment) lastIndex() uint64 {
+	return s.firstIndex + uint64(len(s.entries)) - 1
+}
+
+// contains returns true if the entry with given index is in the segment.
+func (s *walSegment) contains(index uint64) bool {
+	return index >= s.firstIndex && index < s.firstIndex+uint64(len(s.entries))
+}
+
+// read reads log entries in [fromIndex, toIndex] from the segment with a single disk read.
+func (s *walSegment) read(fromIndex uint64, toIndex uint64) ([]*konsen.Log, error) {
+	from := s.entries[fromIndex-s.firstIndex]
+	to := s.entries[toIndex-s.firstIndex]
+	buf := make([]byte, to.offset+walRecordHeaderSize+int64(to.length)-from.offset)
+	if _, err := s.file.ReadAt(buf, from.offset); err != nil {
+		return nil, err
+	}
+	logs := make([]*konsen.Log, 0, toIndex-fromIndex+1)
+	for i := fromIndex; i <= toIndex; i++ {
+		e := s.entries[i-s.firstIndex]
+		start := e.offset - from.offset
+		header := buf[start : start+walRecordHeaderSize]
+		payload := buf[start+walRecordHeaderSize : start+walRecordHeaderSize+int64(e.length)]
+		if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+			return nil, fmt.Errorf("%w: CRC mismatch of log at index %d in WAL segment %q", ErrCorruptedLog, i, s.path)
+		}
+		log := &konsen.Log{}
+		if err := proto.Unmarshal(payload, log); err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	return logs, nil
+}
+
+// append writes encoded records to the end of the segment, and adds the corresponding entries to the index.
+func (s *walSegment) append(buf []byte, entries []walEntry) error {
+	if _, err := s.file.WriteAt(buf, s.size); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		e.offset += s.size
+		s.entries = append(s.entries, e)
+	}
+	s.size += int64(len(buf))
+	return nil
+}
+
+// truncateFrom removes the entry with given index and all that follow it.
+func (s *walSegment) truncateFrom(index uint64) error {
+	if index < s.firstIndex {
+		index = s.firstIndex
+	}
+	if !s.contains(index) {
+		return nil
+	}
+	pos := index - s.firstIndex
+	offset := s.entries[pos].offset
+	s.entries = s.entries[:pos]
+	return s.truncate(offset)
+}
+
+func (s *walSegment) truncate(size int64) error {
+	if err := s.file.Truncate(size); err != nil {
+		return err
+	}
+	s.size = size
+	return s.file.Sync()
+}
+
+func (s *walSegment) sync() error {
+	return s.file.Sync()
+}
+
+func (s *walSegment) close() error {
+	return s.file.Close()
+}
+
+// remove closes and deletes the segment file.
+func (s *walSegment) remove() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	return os.Remove(s.path)
+}
+
+// encodeWALRecord appends the encoded record of given log entry to buf.
+func encodeWALRecord(buf []byte, log *konsen.Log) ([]byte, walEntry, error) {
+	payload, err := proto.Marshal(log)
+	if err != nil {
+		return nil, walEntry{}, err
+	}
+	var header [walRecordHeaderSize]byte
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:8], crc32.Checksum(payload, crcTable))
+	entry := walEntry{offset: int64(len(buf)), length: uint32(len(payload)), term: log.GetTerm()}
+	buf = append(buf, header[:]...)
+	buf = append(buf, payload...)
+	return buf, entry, nil
+}
+
+// syncDir fsyncs a directory so that file creations and deletions in it are durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
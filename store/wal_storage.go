@@ -0,0 +1,359 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lizhaoliu/konsen/v2/metrics"
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultWALSegmentSize = 64 << 20
+
+// WAL is a LogStore implementation that keeps Raft logs in append-only segment files. It only stores logs, and is
+// meant to be combined with a StableStore and a KVStore (e.g. BadgerState) through Composite.
+// Log entries are indexed in memory (file offset and term of every entry), so appends cost a single sequential write
+// plus one fsync per batch, term lookups never touch the disk, and reads are served with one positional read per
+// segment. Truncating the log suffix truncates a file in place, and compaction deletes whole segment files.
+type WAL struct {
+	dir         string
+	segmentSize int64
+	noSync      bool
+	readOnly    bool
+	metrics     *metrics.Metrics
+
+	mu       sync.RWMutex
+	segments []*walSegment // Segments sorted by first index, the last segment is the one being appended to.
+}
+
+type WALConfig struct {
+	LogDir      string           // Directory of the log segment files.
+	SegmentSize int64            // Size in bytes after which a new segment is started (defaults to 64MB).
+	NoSync      bool             // Do not fsync after writes, this is unsafe and only meant for benchmarking.
+	ReadOnly    bool             // Open log segments read-only, torn tails are ignored instead of repaired.
+	Metrics     *metrics.Metrics // Metrics to observe fsyncs with, fsyncs are not observed if nil.
+}
+
+func NewWAL(config WALConfig) (*WAL, error) {
+	if config.SegmentSize <= 0 {
+		config.SegmentSize = defaultWALSegmentSize
+	}
+
+	logrus.Infof("WAL log dir set to: %s", config.LogDir)
+	if !config.ReadOnly {
+		if err := os.MkdirAll(config.LogDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	indices, err := listWALSegments(config.LogDir)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WAL{
+		dir:         config.LogDir,
+		segmentSize: config.SegmentSize,
+		noSync:      config.NoSync,
+		readOnly:    config.ReadOnly,
+		metrics:     config.Metrics,
+	}
+	for i, index := range indices {
+		// Only the last segment may have a torn tail (a crash while appending), anything else is a corruption.
+		isLast := i == len(indices)-1
+		seg, err := openWALSegment(config.LogDir, index, config.ReadOnly, isLast)
+		if err != nil {
+			w.closeSegments()
+			return nil, err
+		}
+		if n := len(w.segments); n > 0 && w.segments[n-1].lastIndex()+1 != seg.firstIndex {
+			seg.close()
+			w.closeSegments()
+			return nil, fmt.Errorf("WAL segment %q is not contiguous with the previous segment", seg.path)
+		}
+		w.segments = append(w.segments, seg)
+	}
+
+	return w, nil
+}
+
+// sync fsyncs a segment, and observes the time it takes.
+func (w *WAL) sync(seg *walSegment) error {
+	if w.metrics != nil {
+		start := time.Now()
+		defer func() { w.metrics.StorageFsyncSeconds.Observe(time.Since(start).Seconds()) }()
+	}
+	return seg.sync()
+}
+
+// findSegment returns the position of the segment that contains the entry with given index, or -1 if not found.
+func (w *WAL) findSegment(index uint64) int {
+	lo, hi := 0, len(w.segments)-1
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		seg := w.segments[mid]
+		switch {
+		case index < seg.firstIndex:
+			hi = mid - 1
+		case index > seg.lastIndex() || len(seg.entries) == 0:
+			lo = mid + 1
+		default:
+			return mid
+		}
+	}
+	return -1
+}
+
+// firstIndex returns the index of the first entry, or 0 if the log is empty.
+func (w *WAL) firstIndex() uint64 {
+	for _, seg := range w.segments {
+		if len(seg.entries) > 0 {
+			return seg.firstIndex
+		}
+	}
+	return 0
+}
+
+// lastIndex returns the index of the last entry, or 0 if the log is empty.
+func (w *WAL) lastIndex() uint64 {
+	if len(w.segments) == 0 {
+		return 0
+	}
+	return w.segments[len(w.segments)-1].lastIndex()
+}
+
+func (w *WAL) GetLog(logIndex uint64) (*konsen.Log, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	i := w.findSegment(logIndex)
+	if i < 0 {
+		return nil, nil
+	}
+	logs, err := w.segments[i].read(logIndex, logIndex)
+	if err != nil {
+		return nil, err
+	}
+	return logs[0], nil
+}
+
+func (w *WAL) GetLogsFrom(minLogIndex uint64) ([]*konsen.Log, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if first := w.firstIndex(); minLogIndex < first {
+		minLogIndex = first
+	}
+	i := w.findSegment(minLogIndex)
+	if i < 0 {
+		return nil, nil
+	}
+	var logs []*konsen.Log
+	for ; i < len(w.segments); i++ {
+		seg := w.segments[i]
+		if len(seg.entries) == 0 {
+			continue
+		}
+		from := seg.firstIndex
+		if minLogIndex > from {
+			from = minLogIndex
+		}
+		segLogs, err := seg.read(from, seg.lastIndex())
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, segLogs...)
+	}
+	return logs, nil
+}
+
+func (w *WAL) GetLogTerm(logIndex uint64) (uint64, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	i := w.findSegment(logIndex)
+	if i < 0 {
+		return 0, nil
+	}
+	seg := w.segments[i]
+	return seg.entries[logIndex-seg.firstIndex].term, nil
+}
+
+func (w *WAL) WriteLog(log *konsen.Log) error {
+	return w.WriteLogs([]*konsen.Log{log})
+}
+
+// WriteLogs appends the given contiguous log entries. Existing entries with index greater equal than the first given
+// entry are overwritten. All entries are written with one write call and one fsync per segment.
+func (w *WAL) WriteLogs(logs []*konsen.Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	if w.readOnly {
+		return fmt.Errorf("WAL is opened read-only")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i := 1; i < len(logs); i++ {
+		if logs[i].GetIndex() != logs[i-1].GetIndex()+1 {
+			return fmt.Errorf("log entries to write are not contiguous: index %d follows %d", logs[i].GetIndex(), logs[i-1].GetIndex())
+		}
+	}
+
+	first := logs[0].GetIndex()
+	if len(w.segments) > 0 {
+		last := w.lastIndex()
+		if first <= last {
+			if err := w.deleteLogsFrom(first); err != nil {
+				return err
+			}
+		} else if first != last+1 {
+			return fmt.Errorf("log entry at index %d leaves a gap after last index %d", first, last)
+		}
+	}
+
+	var buf []byte
+	var entries []walEntry
+	// flush writes out the pending records to the active segment.
+	flush := func() error {
+		if len(buf) == 0 {
+			return nil
+		}
+		seg := w.segments[len(w.segments)-1]
+		if err := seg.append(buf, entries); err != nil {
+			return err
+		}
+		buf, entries = buf[:0], entries[:0]
+		if w.noSync {
+			return nil
+		}
+		return w.sync(seg)
+	}
+
+	for _, log := range logs {
+		if len(w.segments) == 0 || w.segments[len(w.segments)-1].size+int64(len(buf)) >= w.segmentSize {
+			if err := flush(); err != nil {
+				return err
+			}
+			seg, err := createWALSegment(w.dir, log.GetIndex())
+			if err != nil {
+				return err
+			}
+			w.segments = append(w.segments, seg)
+		}
+		var entry walEntry
+		var err error
+		buf, entry, err = encodeWALRecord(buf, log)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+	return flush()
+}
+
+func (w *WAL) FirstLogIndex() (uint64, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.firstIndex(), nil
+}
+
+func (w *WAL) LastLogIndex() (uint64, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.lastIndex(), nil
+}
+
+func (w *WAL) LastLogTerm() (uint64, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	for i := len(w.segments) - 1; i >= 0; i-- {
+		seg := w.segments[i]
+		if len(seg.entries) > 0 {
+			return seg.entries[len(seg.entries)-1].term, nil
+		}
+	}
+	return 0, nil
+}
+
+// DeleteLogsFrom truncates the log suffix starting from the given index: the segment that holds the index is
+// truncated in place, and all segments after it are deleted.
+func (w *WAL) DeleteLogsFrom(minLogIndex uint64) error {
+	if w.readOnly {
+		return fmt.Errorf("WAL is opened read-only")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.deleteLogsFrom(minLogIndex)
+}
+
+func (w *WAL) deleteLogsFrom(minLogIndex uint64) error {
+	for len(w.segments) > 0 {
+		seg := w.segments[len(w.segments)-1]
+		if seg.firstIndex < minLogIndex {
+			break
+		}
+		if err := seg.remove(); err != nil {
+			return err
+		}
+		w.segments = w.segments[:len(w.segments)-1]
+	}
+	if len(w.segments) > 0 {
+		if err := w.segments[len(w.segments)-1].truncateFrom(minLogIndex); err != nil {
+			return err
+		}
+	}
+	return syncDir(w.dir)
+}
+
+// DeleteLogsBefore deletes log entries with index less than the given index, used by log compaction. Only whole
+// segments are deleted, so entries before the given index may be retained if they share a segment with entries that
+// are kept. The segment being appended to is never deleted.
+func (w *WAL) DeleteLogsBefore(maxLogIndex uint64) error {
+	if w.readOnly {
+		return fmt.Errorf("WAL is opened read-only")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := 0
+	for n < len(w.segments)-1 && w.segments[n+1].firstIndex <= maxLogIndex {
+		if err := w.segments[n].remove(); err != nil {
+			return err
+		}
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	w.segments = append(w.segments[:0], w.segments[n:]...)
+	return syncDir(w.dir)
+}
+
+func (w *WAL) closeSegments() error {
+	var firstErr error
+	for _, seg := range w.segments {
+		if err := seg.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	w.segments = nil
+	return firstErr
+}
+
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.closeSegments()
+}
@@ -0,0 +1,331 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+)
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "konsen-store-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func openWAL(t *testing.T, config WALConfig) *WAL {
+	t.Helper()
+	w, err := NewWAL(config)
+	if err != nil {
+		t.Fatalf("NewWAL: %v", err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+func testLogs(from uint64, to uint64, term uint64) []*konsen.Log {
+	var logs []*konsen.Log
+	for i := from; i <= to; i++ {
+		log := &konsen.Log{Index: i, Term: term, Data: []byte(fmt.Sprintf("data-%d", i))}
+		log.Checksum = LogChecksum(log)
+		logs = append(logs, log)
+	}
+	return logs
+}
+
+// checkLogs verifies that the WAL holds exactly the given entries.
+func checkLogs(t *testing.T, w *WAL, want []*konsen.Log) {
+	t.Helper()
+	first, _ := w.FirstLogIndex()
+	last, _ := w.LastLogIndex()
+	if len(want) == 0 {
+		if first != 0 || last != 0 {
+			t.Fatalf("got log indices [%d, %d], want an empty log", first, last)
+		}
+		return
+	}
+	if first != want[0].GetIndex() || last != want[len(want)-1].GetIndex() {
+		t.Fatalf("got log indices [%d, %d], want [%d, %d]", first, last, want[0].GetIndex(), want[len(want)-1].GetIndex())
+	}
+	lastTerm, _ := w.LastLogTerm()
+	if lastTerm != want[len(want)-1].GetTerm() {
+		t.Fatalf("got last log term %d, want %d", lastTerm, want[len(want)-1].GetTerm())
+	}
+	logs, err := w.GetLogsFrom(0)
+	if err != nil {
+		t.Fatalf("GetLogsFrom: %v", err)
+	}
+	if len(logs) != len(want) {
+		t.Fatalf("got %d logs, want %d", len(logs), len(want))
+	}
+	for i, log := range logs {
+		if log.GetIndex() != want[i].GetIndex() || log.GetTerm() != want[i].GetTerm() || string(log.GetData()) != string(want[i].GetData()) {
+			t.Fatalf("got log %v, want %v", log, want[i])
+		}
+		if err := VerifyLogChecksum(log); err != nil {
+			t.Fatal(err)
+		}
+		one, err := w.GetLog(want[i].GetIndex())
+		if err != nil || one.GetIndex() != want[i].GetIndex() || string(one.GetData()) != string(want[i].GetData()) {
+			t.Fatalf("GetLog(%d) = %v, %v", want[i].GetIndex(), one, err)
+		}
+		term, err := w.GetLogTerm(want[i].GetIndex())
+		if err != nil || term != want[i].GetTerm() {
+			t.Fatalf("GetLogTerm(%d) = %d, %v, want %d", want[i].GetIndex(), term, err, want[i].GetTerm())
+		}
+	}
+}
+
+// lastSegmentPath returns the path of the segment file with the highest first index.
+func lastSegmentPath(t *testing.T, dir string) string {
+	t.Helper()
+	indices, err := listWALSegments(dir)
+	if err != nil || len(indices) == 0 {
+		t.Fatalf("listWALSegments: %v, %v", indices, err)
+	}
+	return walSegmentPath(dir, indices[len(indices)-1])
+}
+
+func TestWALRoundTrip(t *testing.T) {
+	dir := tempDir(t)
+	// Small segments, so that the logs span several of them.
+	config := WALConfig{LogDir: dir, SegmentSize: 256}
+	w := openWAL(t, config)
+	checkLogs(t, w, nil)
+
+	logs := testLogs(1, 50, 1)
+	if err := w.WriteLogs(logs[:20]); err != nil {
+		t.Fatal(err)
+	}
+	for _, log := range logs[20:] {
+		if err := w.WriteLog(log); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkLogs(t, w, logs)
+	if n := len(w.segments); n < 2 {
+		t.Fatalf("got %d segments, want more than one", n)
+	}
+	if log, err := w.GetLog(51); log != nil || err != nil {
+		t.Fatalf("GetLog(51) = %v, %v, want nil", log, err)
+	}
+	if err := w.WriteLog(testLogs(60, 60, 1)[0]); err == nil {
+		t.Fatal("writing a log after a gap succeeded")
+	}
+
+	w.Close()
+	checkLogs(t, openWAL(t, config), logs)
+}
+
+func TestWALTruncation(t *testing.T) {
+	dir := tempDir(t)
+	config := WALConfig{LogDir: dir, SegmentSize: 256}
+	w := openWAL(t, config)
+	logs := testLogs(1, 50, 1)
+	if err := w.WriteLogs(logs); err != nil {
+		t.Fatal(err)
+	}
+
+	// Truncating the suffix in the middle of a segment.
+	if err := w.DeleteLogsFrom(31); err != nil {
+		t.Fatal(err)
+	}
+	checkLogs(t, w, logs[:30])
+
+	// Overwriting a suffix with entries of a higher term.
+	overwrite := testLogs(26, 40, 2)
+	if err := w.WriteLogs(overwrite); err != nil {
+		t.Fatal(err)
+	}
+	want := append(append([]*konsen.Log(nil), logs[:25]...), overwrite...)
+	checkLogs(t, w, want)
+
+	// Compaction only deletes whole segments before the index.
+	if err := w.DeleteLogsBefore(20); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := w.FirstLogIndex()
+	if first <= 1 || first > 20 {
+		t.Fatalf("got first log index %d after compaction, want in (1, 20]", first)
+	}
+	checkLogs(t, w, want[first-1:])
+
+	w.Close()
+	checkLogs(t, openWAL(t, config), want[first-1:])
+
+	// Deleting everything.
+	w = openWAL(t, config)
+	if err := w.DeleteLogsFrom(0); err != nil {
+		t.Fatal(err)
+	}
+	checkLogs(t, w, nil)
+}
+
+func TestWALTornTail(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		keep int // Number of the 10 written logs that survive.
+		tear func(t *testing.T, path string)
+	}{
+		{"partial header", 10, func(t *testing.T, path string) {
+			appendToFile(t, path, []byte{0, 0, 0})
+		}},
+		{"partial payload", 9, func(t *testing.T, path string) {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Truncate(path, info.Size()-3); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"oversize length", 10, func(t *testing.T, path string) {
+			var header [walRecordHeaderSize]byte
+			binary.BigEndian.PutUint32(header[0:4], 0xffffffff)
+			appendToFile(t, path, header[:])
+		}},
+		{"bad CRC of last record", 9, func(t *testing.T, path string) {
+			flipLastByte(t, path)
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := tempDir(t)
+			config := WALConfig{LogDir: dir}
+			w := openWAL(t, config)
+			logs := testLogs(1, 10, 1)
+			if err := w.WriteLogs(logs); err != nil {
+				t.Fatal(err)
+			}
+			w.Close()
+			path := lastSegmentPath(t, dir)
+			tc.tear(t, path)
+
+			// Only the torn record is lost, the file is truncated to the last valid record.
+			w = openWAL(t, config)
+			want := logs[:tc.keep]
+			checkLogs(t, w, want)
+			next := testLogs(uint64(len(want))+1, 12, 1)
+			if err := w.WriteLogs(next); err != nil {
+				t.Fatal(err)
+			}
+			w.Close()
+			checkLogs(t, openWAL(t, config), append(append([]*konsen.Log(nil), want...), next...))
+		})
+	}
+}
+
+func TestWALTornTailReadOnly(t *testing.T) {
+	dir := tempDir(t)
+	w := openWAL(t, WALConfig{LogDir: dir})
+	logs := testLogs(1, 10, 1)
+	if err := w.WriteLogs(logs); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	path := lastSegmentPath(t, dir)
+	flipLastByte(t, path)
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkLogs(t, openWAL(t, WALConfig{LogDir: dir, ReadOnly: true}), logs[:9])
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() != before.Size() {
+		t.Fatalf("read-only WAL changed segment size from %d to %d", before.Size(), after.Size())
+	}
+}
+
+func TestWALCorruption(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		offset int64 // Offset of the corruption in the 5th of the 10 records.
+		buf    []byte
+	}{
+		// A record in the middle of a segment that fails its CRC can not be a torn append.
+		{"bad CRC", walRecordHeaderSize, []byte{0xff}},
+		// Neither can a record running past the end of the segment followed by valid records.
+		{"oversize length", 0, []byte{0xff, 0xff, 0xff, 0xff}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := tempDir(t)
+			config := WALConfig{LogDir: dir}
+			w := openWAL(t, config)
+			if err := w.WriteLogs(testLogs(1, 10, 1)); err != nil {
+				t.Fatal(err)
+			}
+			path := lastSegmentPath(t, dir)
+			offset := w.segments[0].entries[4].offset + tc.offset
+			w.Close()
+			f, err := os.OpenFile(path, os.O_RDWR, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, len(tc.buf))
+			if _, err := f.ReadAt(buf, offset); err != nil {
+				t.Fatal(err)
+			}
+			for i := range buf {
+				buf[i] ^= tc.buf[i]
+			}
+			if _, err := f.WriteAt(buf, offset); err != nil {
+				t.Fatal(err)
+			}
+			before, err := f.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			if w, err := NewWAL(config); err == nil {
+				w.Close()
+				t.Fatal("opening a WAL with a corrupted record succeeded")
+			} else if !errors.Is(err, ErrCorruptedLog) {
+				t.Fatalf("got error %v, want %v", err, ErrCorruptedLog)
+			}
+			// The records after the corrupted one are not truncated.
+			after, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.Size() != before.Size() {
+				t.Fatalf("got segment size %d after opening, want %d", after.Size(), before.Size())
+			}
+		})
+	}
+}
+
+func appendToFile(t *testing.T, path string, buf []byte) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func flipLastByte(t *testing.T, path string) {
+	t.Helper()
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf[len(buf)-1] ^= 0xff
+	if err := ioutil.WriteFile(path, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
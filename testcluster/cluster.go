@@ -0,0 +1,321 @@
+// Package testcluster runs a cluster of konsen nodes in one process on localhost, for development and integration
+// tests. Nodes can be killed, restarted and partitioned from each other.
+package testcluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lizhaoliu/konsen/v2/core"
+	"github.com/lizhaoliu/konsen/v2/node"
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+	log "github.com/sirupsen/logrus"
+)
+
+// Interval between checks of the nodes' status while waiting for a leader.
+const leaderPollInterval = 50 * time.Millisecond
+
+// Cluster is a cluster of nodes in this process, named node1, node2, and so on.
+type Cluster struct {
+	dir       string
+	removeDir bool
+	names     []string
+	nodes     map[string]*node.Node
+	network   *network
+
+	mu sync.Mutex // Serializes starting and stopping nodes.
+}
+
+// ClusterConfig
+type ClusterConfig struct {
+	Nodes int    // Number of nodes, defaults to 3.
+	Dir   string // Directory of the nodes' data, a temporary one that is removed by Close if empty.
+	// Ports of the i-th node (from 0) are RaftBasePort+i and HTTPBasePort+i, free ports are picked if they are 0.
+	RaftBasePort int
+	HTTPBasePort int
+	Logger       *log.Logger                   // Logger of the nodes, the standard logger if unset.
+	Configure    func(config *core.NodeConfig) // Changes the configuration of each node before it is validated.
+}
+
+// NewCluster creates the nodes of a cluster, which are not started.
+func NewCluster(config ClusterConfig) (*Cluster, error) {
+	if config.Nodes == 0 {
+		config.Nodes = 3
+	}
+	if config.Nodes < 0 || config.Nodes%2 != 1 {
+		return nil, fmt.Errorf("number of nodes must be a positive odd number, got %d", config.Nodes)
+	}
+	if config.Logger == nil {
+		config.Logger = log.StandardLogger()
+	}
+
+	c := &Cluster{
+		dir:     config.Dir,
+		nodes:   make(map[string]*node.Node),
+		network: newNetwork(),
+	}
+	if c.dir == "" {
+		dir, err := ioutil.TempDir("", "konsen-testcluster-")
+		if err != nil {
+			return nil, err
+		}
+		c.dir, c.removeDir = dir, true
+	}
+
+	raftPorts, err := ports(config.RaftBasePort, config.Nodes)
+	if err != nil {
+		return nil, err
+	}
+	httpPorts, err := ports(config.HTTPBasePort, config.Nodes)
+	if err != nil {
+		return nil, err
+	}
+	cluster := core.ClusterConfig{Servers: make(map[string]string), HttpServers: make(map[string]string)}
+	for i := 0; i < config.Nodes; i++ {
+		name := "node" + strconv.Itoa(i+1)
+		c.names = append(c.names, name)
+		cluster.Servers[name] = net.JoinHostPort("127.0.0.1", strconv.Itoa(raftPorts[i]))
+		cluster.HttpServers[name] = net.JoinHostPort("127.0.0.1", strconv.Itoa(httpPorts[i]))
+	}
+
+	for _, name := range c.names {
+		name := name
+		nodeConfig := core.DefaultNodeConfig()
+		nodeConfig.ClusterConfig = cluster
+		nodeConfig.LocalServerName = name
+		nodeConfig.Listen.Pprof = ""
+		nodeConfig.Storage.Dir = filepath.Join(c.dir, name)
+		if config.Configure != nil {
+			config.Configure(nodeConfig)
+		}
+		n, err := node.NewNode(node.NodeConfig{
+			Config: nodeConfig,
+			Logger: config.Logger.WithField("node", name),
+			WrapClient: func(server string, client core.RaftService) core.RaftService {
+				return &faultyClient{from: name, to: server, network: c.network, client: client}
+			},
+		})
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		c.nodes[name] = n
+	}
+	return c, nil
+}
+
+// ports returns n consecutive ports from base, or n free ports if base is 0.
+func ports(base int, n int) ([]int, error) {
+	result := make([]int, n)
+	if base != 0 {
+		for i := range result {
+			result[i] = base + i
+		}
+		return result, nil
+	}
+	// Listeners are kept open until all ports are picked, so that the ports are distinct.
+	var listeners []net.Listener
+	defer func() {
+		for _, lis := range listeners {
+			lis.Close()
+		}
+	}()
+	for i := range result {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, fmt.Errorf("failed to pick a free port: %v", err)
+		}
+		listeners = append(listeners, lis)
+		result[i] = lis.Addr().(*net.TCPAddr).Port
+	}
+	return result, nil
+}
+
+// Start starts all nodes that are not running.
+func (c *Cluster) Start(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, name := range c.names {
+		if n := c.nodes[name]; !n.Running() {
+			if err := n.Start(ctx); err != nil {
+				return fmt.Errorf("failed to start %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Close stops all running nodes, and removes the data directory if it is a temporary one.
+func (c *Cluster) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var errs []error
+	for _, name := range c.names {
+		if n := c.nodes[name]; n != nil && n.Running() {
+			if err := n.Stop(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("failed to stop %s: %v", name, err))
+			}
+		}
+	}
+	if c.removeDir {
+		if err := os.RemoveAll(c.dir); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// Dir returns the directory of the nodes' data.
+func (c *Cluster) Dir() string {
+	return c.dir
+}
+
+// Names returns the names of all nodes.
+func (c *Cluster) Names() []string {
+	return append([]string(nil), c.names...)
+}
+
+// Node returns the node with given name, nil if there is none.
+func (c *Cluster) Node(name string) *node.Node {
+	return c.nodes[name]
+}
+
+// HTTPEndpoints returns the HTTP endpoints of all nodes, e.g. for client.ClientConfig.
+func (c *Cluster) HTTPEndpoints() []string {
+	endpoints := make([]string, len(c.names))
+	for i, name := range c.names {
+		endpoints[i] = c.nodes[name].Config().HttpServers[name]
+	}
+	return endpoints
+}
+
+// Kill stops a node, which keeps its data.
+func (c *Cluster) Kill(ctx context.Context, name string) error {
+	n, err := c.node(name)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return n.Stop(ctx)
+}
+
+// Restart starts a node that has been killed, with the data it had.
+func (c *Cluster) Restart(ctx context.Context, name string) error {
+	n, err := c.node(name)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return n.Start(ctx)
+}
+
+// Partition disconnects nodes in different groups from each other, nodes that are not in any group are connected to
+// all others. It replaces the previous partition.
+func (c *Cluster) Partition(groups ...[]string) error {
+	for _, group := range groups {
+		for _, name := range group {
+			if _, err := c.node(name); err != nil {
+				return err
+			}
+		}
+	}
+	c.network.partition(groups)
+	return nil
+}
+
+// Isolate disconnects a node from all others.
+func (c *Cluster) Isolate(name string) error {
+	var others []string
+	for _, other := range c.names {
+		if other != name {
+			others = append(others, other)
+		}
+	}
+	return c.Partition([]string{name}, others)
+}
+
+// Heal reconnects all nodes.
+func (c *Cluster) Heal() {
+	c.network.partition(nil)
+}
+
+// Connected returns whether requests from a node can reach another.
+func (c *Cluster) Connected(from string, to string) bool {
+	return c.network.connected(from, to)
+}
+
+// Status returns the status of each running node.
+func (c *Cluster) Status(ctx context.Context) map[string]*core.Status {
+	statuses := make(map[string]*core.Status)
+	for _, name := range c.names {
+		sm := c.nodes[name].StateMachine()
+		if sm == nil {
+			continue
+		}
+		if status, err := sm.GetStatus(ctx); err == nil {
+			statuses[name] = status
+		}
+	}
+	return statuses
+}
+
+// Leader returns the running node that is leader in the highest term, empty if there is none.
+func (c *Cluster) Leader(ctx context.Context) string {
+	var leader string
+	var term uint64
+	for name, status := range c.Status(ctx) {
+		if status.Role == konsen.Role_LEADER && status.Term >= term {
+			leader, term = name, status.Term
+		}
+	}
+	return leader
+}
+
+// WaitForLeader waits until there is a running leader that a majority of nodes agree on, and returns it.
+func (c *Cluster) WaitForLeader(ctx context.Context) (string, error) {
+	ticker := time.NewTicker(leaderPollInterval)
+	defer ticker.Stop()
+	for {
+		statuses := c.Status(ctx)
+		votes := make(map[string]int)
+		for name, status := range statuses {
+			if status.Leader != "" && c.Connected(name, status.Leader) {
+				votes[status.Leader]++
+			}
+		}
+		for leader, n := range votes {
+			// Followers keep naming a leader that has been killed until they time out.
+			if status, ok := statuses[leader]; ok && status.Role == konsen.Role_LEADER && n > len(c.names)/2 {
+				return leader, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("no leader elected: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Cluster) node(name string) (*node.Node, error) {
+	n, ok := c.nodes[name]
+	if !ok {
+		return nil, errors.New("unknown node " + strconv.Quote(name))
+	}
+	return n, nil
+}
@@ -0,0 +1,362 @@
+package testcluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/lizhaoliu/konsen/v2/core"
+	"github.com/lizhaoliu/konsen/v2/node"
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+	log "github.com/sirupsen/logrus"
+)
+
+// Timeout of each step of the tests, such as electing a leader or replicating a write.
+const testTimeout = 10 * time.Second
+
+// newTestCluster starts a cluster of n nodes with short election timeouts, which is closed when the test ends. Nodes
+// store their data in Bolt files, which is quicker to open than Badger and does not log.
+func newTestCluster(t *testing.T, n int) *Cluster {
+	t.Helper()
+	logger := log.New()
+	logger.SetOutput(ioutil.Discard)
+	c, err := NewCluster(ClusterConfig{
+		Nodes:  n,
+		Logger: logger,
+		Configure: func(config *core.NodeConfig) {
+			config.Storage.Engine = "boltdb"
+			config.Raft.HeartbeatInterval = core.Duration(50 * time.Millisecond)
+			config.Raft.ElectionTimeoutMin = core.Duration(500 * time.Millisecond)
+			config.Raft.ElectionTimeoutMax = core.Duration(time.Second)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := c.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func waitForLeader(t *testing.T, c *Cluster) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	leader, err := c.WaitForLeader(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leader
+}
+
+func propose(t *testing.T, n *node.Node, key string, value string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if _, err := n.Propose(ctx, node.Write{Key: []byte(key), Value: []byte(value)}); err != nil {
+		t.Fatalf("failed to write %q: %v", key, err)
+	}
+}
+
+// eventually retries check until it succeeds, or fails the test with its last error after testTimeout.
+func eventually(t *testing.T, check func() error) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(leaderPollInterval)
+	}
+}
+
+// checkLocalValue checks the value of a key in the local state machine of a node.
+func checkLocalValue(n *node.Node, key string, want string) error {
+	return checkValue(n, key, core.Stale, want)
+}
+
+// checkValue checks the value of a key read from a node with given consistency.
+func checkValue(n *node.Node, key string, consistency core.ReadConsistency, want string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	value, _, err := n.Read(ctx, []byte(key), consistency)
+	if err != nil {
+		return fmt.Errorf("failed to read %q from %s with consistency %v: %w", key, n.Config().LocalServerName, consistency, err)
+	}
+	if string(value) != want {
+		return fmt.Errorf("got %q = %q on %s, want %q", key, value, n.Config().LocalServerName, want)
+	}
+	return nil
+}
+
+func TestLeaderElection(t *testing.T) {
+	c := newTestCluster(t, 3)
+	waitForLeader(t, c)
+
+	var leader string
+	var term uint64
+	// Nodes other than the majority that elected the leader hear from it with its next heartbeat.
+	eventually(t, func() error {
+		statuses := c.Status(context.Background())
+		if len(statuses) != 3 {
+			return fmt.Errorf("got status of %d nodes, want 3", len(statuses))
+		}
+		leader = c.Leader(context.Background())
+		if leader == "" {
+			return fmt.Errorf("no leader")
+		}
+		term = statuses[leader].Term
+		for name, status := range statuses {
+			if status.Leader != leader || status.Term != term {
+				return fmt.Errorf("%s follows %q in term %d, want %q in term %d", name, status.Leader, status.Term, leader, term)
+			}
+			if name != leader && status.Role != konsen.Role_FOLLOWER {
+				return fmt.Errorf("%s is %v, want %v", name, status.Role, konsen.Role_FOLLOWER)
+			}
+		}
+		return nil
+	})
+
+	// The others elect a new leader in a later term once the leader is gone.
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := c.Kill(ctx, leader); err != nil {
+		t.Fatal(err)
+	}
+	newLeader := waitForLeader(t, c)
+	if newLeader == leader {
+		t.Fatalf("killed leader %s is still the leader", leader)
+	}
+	if status := c.Status(ctx)[newLeader]; status.Term <= term {
+		t.Fatalf("new leader %s is in term %d, want a term after %d", newLeader, status.Term, term)
+	}
+}
+
+// A write committed by the majority side of a partition is kept after the partition heals, and the write sent to the
+// old leader on the minority side is never committed.
+func TestWriteSurvivesPartition(t *testing.T) {
+	c := newTestCluster(t, 3)
+	oldLeader := waitForLeader(t, c)
+	propose(t, c.Node(oldLeader), "k", "1")
+
+	if err := c.Isolate(oldLeader); err != nil {
+		t.Fatal(err)
+	}
+	newLeader := waitForLeader(t, c)
+	if newLeader == oldLeader {
+		t.Fatalf("isolated leader %s is still the leader", oldLeader)
+	}
+	propose(t, c.Node(newLeader), "k", "2")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := c.Node(oldLeader).Propose(ctx, node.Write{Key: []byte("lost"), Value: []byte("x")}); err == nil {
+		t.Fatalf("write to isolated leader %s succeeded", oldLeader)
+	}
+
+	c.Heal()
+	for _, name := range c.Names() {
+		n := c.Node(name)
+		eventually(t, func() error { return checkLocalValue(n, "k", "2") })
+	}
+	// The old leader has caught up with the new one, which replaced the uncommitted log.
+	for _, name := range c.Names() {
+		if err := checkLocalValue(c.Node(name), "lost", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if status, err := c.Node(oldLeader).Status(context.Background()); err != nil || status.Role == konsen.Role_LEADER {
+		t.Fatalf("got status %+v, %v of old leader %s, want it to have stepped down", status, err, oldLeader)
+	}
+}
+
+// Nodes keep their data when they are killed, and catch up with the writes they missed after they are restarted.
+func TestReadAfterRestart(t *testing.T) {
+	c := newTestCluster(t, 3)
+	leader := waitForLeader(t, c)
+	propose(t, c.Node(leader), "a", "1")
+
+	var follower string
+	for _, name := range c.Names() {
+		if name != leader {
+			follower = name
+			break
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := c.Kill(ctx, follower); err != nil {
+		t.Fatal(err)
+	}
+	// Written by the remaining majority while the follower is down.
+	propose(t, c.Node(leader), "b", "2")
+	if err := c.Restart(ctx, follower); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() error { return checkLocalValue(c.Node(follower), "b", "2") })
+	if err := checkLocalValue(c.Node(follower), "a", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted leader reads its writes once a leader is elected again.
+	if err := c.Kill(ctx, leader); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Restart(ctx, leader); err != nil {
+		t.Fatal(err)
+	}
+	newLeader := waitForLeader(t, c)
+	for key, want := range map[string]string{"a": "1", "b": "2"} {
+		value, _, err := c.Node(newLeader).Read(ctx, []byte(key), core.Linearizable)
+		if err != nil {
+			t.Fatalf("failed to read %q from %s: %v", key, newLeader, err)
+		}
+		if string(value) != want {
+			t.Fatalf("got %q = %q, want %q", key, value, want)
+		}
+	}
+	eventually(t, func() error { return checkLocalValue(c.Node(leader), "b", "2") })
+}
+
+// Writes that would fail to be applied are rejected before they are proposed, and the nodes keep serving writes.
+func TestInvalidWrites(t *testing.T) {
+	c := newTestCluster(t, 3)
+	leader := waitForLeader(t, c)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	for _, name := range c.Names() {
+		for _, w := range []node.Write{
+			{Key: nil, Value: []byte("v")},
+			{Key: nil, Delete: true},
+			{Key: make([]byte, core.MaxKeySize+1), Value: []byte("v")},
+			{Key: []byte("k"), Value: make([]byte, core.MaxValueSize+1)},
+		} {
+			if _, err := c.Node(name).Propose(ctx, w); !errors.Is(err, core.ErrInvalidArgument) {
+				t.Fatalf("got error %v writing key of %d bytes and value of %d bytes to %s, want %v",
+					err, len(w.Key), len(w.Value), name, core.ErrInvalidArgument)
+			}
+		}
+	}
+
+	propose(t, c.Node(leader), "k", "v")
+	for _, name := range c.Names() {
+		n := c.Node(name)
+		eventually(t, func() error { return checkLocalValue(n, "k", "v") })
+	}
+}
+
+// A single node is elected by its own vote, commits writes and confirms its leadership for reads by itself, and stays
+// the leader.
+func TestSingleNode(t *testing.T) {
+	c := newTestCluster(t, 1)
+	leader := waitForLeader(t, c)
+	n := c.Node(leader)
+	propose(t, n, "k", "v")
+
+	for _, consistency := range []core.ReadConsistency{
+		core.Linearizable,
+		core.LeaderLocal,
+		core.Stale,
+		{Level: konsen.Consistency_BOUNDED_STALENESS, MaxStaleness: time.Second},
+	} {
+		if err := checkValue(n, "k", consistency, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The leader does not time out without peers to hear from.
+	status, err := n.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Second)
+	if after, err := n.Status(context.Background()); err != nil || after.Term != status.Term || after.Role != konsen.Role_LEADER {
+		t.Fatalf("got status %+v, %v after the election timeout, want leader of term %d", after, err, status.Term)
+	}
+}
+
+// An isolated leader can not confirm its leadership for linearizable reads, or serve bounded staleness reads once its
+// last confirmation is too old, while the new leader serves the writes made after the change.
+func TestReadsAcrossLeaderChange(t *testing.T) {
+	c := newTestCluster(t, 3)
+	oldLeader := waitForLeader(t, c)
+	propose(t, c.Node(oldLeader), "k", "1")
+	if err := checkValue(c.Node(oldLeader), "k", core.Linearizable, "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Isolate(oldLeader); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, _, err := c.Node(oldLeader).Read(ctx, []byte("k"), core.Linearizable); err == nil {
+		t.Fatalf("linearizable read from isolated leader %s succeeded", oldLeader)
+	}
+	boundedStaleness := core.ReadConsistency{Level: konsen.Consistency_BOUNDED_STALENESS, MaxStaleness: 500 * time.Millisecond}
+	if err := checkValue(c.Node(oldLeader), "k", boundedStaleness, "1"); !errors.Is(err, core.ErrTooStale) {
+		t.Fatalf("got error %v from isolated leader %s, want %v", err, oldLeader, core.ErrTooStale)
+	}
+
+	newLeader := waitForLeader(t, c)
+	propose(t, c.Node(newLeader), "k", "2")
+	if err := checkValue(c.Node(newLeader), "k", core.Linearizable, "2"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range c.Names() {
+		if name != oldLeader {
+			n := c.Node(name)
+			eventually(t, func() error { return checkValue(n, "k", boundedStaleness, "2") })
+		}
+	}
+
+	// The old leader catches up once it hears from the new one.
+	c.Heal()
+	eventually(t, func() error { return checkValue(c.Node(oldLeader), "k", boundedStaleness, "2") })
+}
+
+// A partitioned follower does not serve bounded staleness reads by index lag once it has not heard from the leader
+// within the election timeout, however few entries behind the last commit index it has heard of it is.
+func TestIndexLagReadsAcrossPartition(t *testing.T) {
+	c := newTestCluster(t, 3)
+	leader := waitForLeader(t, c)
+	propose(t, c.Node(leader), "k", "1")
+	var follower string
+	for _, name := range c.Names() {
+		if name != leader {
+			follower = name
+			break
+		}
+	}
+	indexLag := core.ReadConsistency{Level: konsen.Consistency_BOUNDED_STALENESS, MaxIndexLag: 10}
+	eventually(t, func() error { return checkValue(c.Node(follower), "k", indexLag, "1") })
+
+	if err := c.Isolate(follower); err != nil {
+		t.Fatal(err)
+	}
+	propose(t, c.Node(leader), "k", "2")
+	eventually(t, func() error {
+		if err := checkValue(c.Node(follower), "k", indexLag, "1"); !errors.Is(err, core.ErrTooStale) {
+			return fmt.Errorf("got error %v from isolated follower %s, want %v", err, follower, core.ErrTooStale)
+		}
+		return nil
+	})
+
+	c.Heal()
+	eventually(t, func() error { return checkValue(c.Node(follower), "k", indexLag, "2") })
+}
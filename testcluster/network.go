@@ -0,0 +1,83 @@
+package testcluster
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/lizhaoliu/konsen/v2/core"
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+)
+
+// ErrPartitioned is returned by requests between nodes that are partitioned from each other.
+var ErrPartitioned = errors.New("nodes are partitioned")
+
+// network tracks which nodes can not reach each other.
+type network struct {
+	mu      sync.RWMutex
+	blocked map[[2]string]bool // Pairs of nodes that can not reach each other, in both orders.
+}
+
+func newNetwork() *network {
+	return &network{blocked: make(map[[2]string]bool)}
+}
+
+func (n *network) connected(from string, to string) bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return !n.blocked[[2]string{from, to}]
+}
+
+// partition disconnects nodes in different groups, and connects nodes in the same group.
+func (n *network) partition(groups [][]string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.blocked = make(map[[2]string]bool)
+	for i, group := range groups {
+		for _, other := range groups[i+1:] {
+			for _, a := range group {
+				for _, b := range other {
+					n.blocked[[2]string{a, b}] = true
+					n.blocked[[2]string{b, a}] = true
+				}
+			}
+		}
+	}
+}
+
+// faultyClient is a client of a peer that fails requests when the peer is partitioned from the local node. Requests
+// in both directions go through such clients, so a partition cuts Raft traffic both ways.
+type faultyClient struct {
+	from    string
+	to      string
+	network *network
+	client  core.RaftService
+}
+
+func (c *faultyClient) AppendEntries(ctx context.Context, in *konsen.AppendEntriesReq) (*konsen.AppendEntriesResp, error) {
+	if !c.network.connected(c.from, c.to) {
+		return nil, ErrPartitioned
+	}
+	return c.client.AppendEntries(ctx, in)
+}
+
+func (c *faultyClient) RequestVote(ctx context.Context, in *konsen.RequestVoteReq) (*konsen.RequestVoteResp, error) {
+	if !c.network.connected(c.from, c.to) {
+		return nil, ErrPartitioned
+	}
+	return c.client.RequestVote(ctx, in)
+}
+
+func (c *faultyClient) AppendData(ctx context.Context, in *konsen.AppendDataReq) (*konsen.AppendDataResp, error) {
+	if !c.network.connected(c.from, c.to) {
+		return nil, ErrPartitioned
+	}
+	return c.client.AppendData(ctx, in)
+}
+
+func (c *faultyClient) TimeoutNow(ctx context.Context, in *konsen.TimeoutNowReq) (*konsen.TimeoutNowResp, error) {
+	if !c.network.connected(c.from, c.to) {
+		return nil, ErrPartitioned
+	}
+	return c.client.TimeoutNow(ctx, in)
+}
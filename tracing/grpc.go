@@ -0,0 +1,93 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// metadataCarrier adapts gRPC metadata to propagation.TextMapCarrier.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key string, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// UnaryServerInterceptor returns a gRPC server interceptor that continues the trace propagated in request metadata,
+// and records a span for each call with given tracer provider. Calls to the given services (e.g. "konsen.Raft") are
+// only traced if they continue a propagated trace, so that heartbeats do not start traces on their own.
+func UnaryServerInterceptor(provider trace.TracerProvider, internalServices ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		ctx = propagator.Extract(ctx, metadataCarrier(md))
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			for _, service := range internalServices {
+				if strings.HasPrefix(info.FullMethod, "/"+service+"/") {
+					return handler(ctx, req)
+				}
+			}
+		}
+		ctx, span := startSpan(provider, ctx, info.FullMethod,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attribute.String("rpc.system", "grpc")))
+		defer span.End()
+
+		resp, err := handler(ctx, req)
+		endRPCSpan(span, err)
+		return resp, err
+	}
+}
+
+// UnaryClientInterceptor returns a gRPC client interceptor that records a span for each call made within a trace, and
+// propagates the trace in request metadata. Calls made outside of any trace are not traced.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		ctx, span := StartSpan(ctx, method,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(attribute.String("rpc.system", "grpc"), attribute.String("net.peer.name", cc.Target())))
+		defer span.End()
+
+		md, ok := metadata.FromOutgoingContext(ctx)
+		if ok {
+			md = md.Copy()
+		} else {
+			md = metadata.MD{}
+		}
+		propagator.Inject(ctx, metadataCarrier(md))
+		err := invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+		endRPCSpan(span, err)
+		return err
+	}
+}
+
+func endRPCSpan(span trace.Span, err error) {
+	s, _ := status.FromError(err)
+	span.SetAttributes(attribute.String("rpc.grpc.status_code", s.Code().String()))
+	if err != nil {
+		span.SetStatus(codes.Error, s.Message())
+	}
+}
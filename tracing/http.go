@@ -0,0 +1,39 @@
+package tracing
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// GinMiddleware returns a gin middleware that continues the trace propagated in request headers, and records a span
+// for each request with given tracer provider, named after the matched route.
+func GinMiddleware(provider trace.TracerProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		name := c.FullPath()
+		if name == "" {
+			name = "unmatched route"
+		}
+		ctx, span := startSpan(provider, ctx, c.Request.Method+" "+name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", c.Request.Method),
+				attribute.String("http.target", c.Request.URL.RequestURI()),
+			))
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		code := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.status_code", code))
+		if code >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(code))
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Package tracing sets up OpenTelemetry tracing, and provides the gRPC interceptors and HTTP middleware that propagate
+// trace context between servers. Each server has its own tracer provider, which starts the spans of the requests it
+// receives, and the spans of the work done for a request are recorded by the tracer provider of the request's span. So
+// servers running in one process (e.g. a test cluster) report their spans as different service instances.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/exporters/stdout"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterNone   = "none"   // Do not export spans.
+	ExporterStdout = "stdout" // Print spans to stdout.
+	ExporterOTLP   = "otlp"   // Send spans to an OTLP collector over gRPC.
+
+	tracerName = "github.com/lizhaoliu/konsen/v2"
+)
+
+// propagator propagates trace context in request headers and metadata.
+var propagator = propagation.TraceContext{}
+
+// Tracing is the OpenTelemetry tracer provider of a server.
+type Tracing struct {
+	provider *sdktrace.TracerProvider
+}
+
+// TracingConfig
+type TracingConfig struct {
+	Exporter     string  // One of ExporterXxx.
+	OTLPEndpoint string  // Endpoint of the OTLP collector, e.g. localhost:4317.
+	ServerName   string  // Local server name, reported as the service instance.
+	SampleRatio  float64 // Fraction of traces started by this server to sample, traces started elsewhere follow the caller.
+}
+
+// NewTracing creates the tracer provider with given exporter, it returns nil if the exporter is ExporterNone.
+func NewTracing(config TracingConfig) (*Tracing, error) {
+	var exporter sdktrace.SpanExporter
+	switch config.Exporter {
+	case "", ExporterNone:
+		return nil, nil
+	case ExporterStdout:
+		e, err := stdout.NewExporter(stdout.WithoutMetricExport())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create stdout exporter: %w", err)
+		}
+		exporter = e
+	case ExporterOTLP:
+		e, err := otlp.NewExporter(context.Background(), otlpgrpc.NewDriver(
+			otlpgrpc.WithInsecure(),
+			otlpgrpc.WithEndpoint(config.OTLPEndpoint),
+		))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		exporter = e
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", config.Exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.ServiceNameKey.String("konsen"),
+			semconv.ServiceInstanceIDKey.String(config.ServerName),
+		)),
+	)
+	return &Tracing{provider: provider}, nil
+}
+
+// TracerProvider returns the tracer provider, which records nothing if t is nil.
+func (t *Tracing) TracerProvider() trace.TracerProvider {
+	if t == nil {
+		return trace.NewNoopTracerProvider()
+	}
+	return t.provider
+}
+
+// Close flushes pending spans and shuts down the exporter.
+func (t *Tracing) Close(ctx context.Context) error {
+	return t.provider.Shutdown(ctx)
+}
+
+// StartSpan starts a span as a child of the span in ctx, with the tracer provider of that span. Nothing is recorded if
+// ctx is not in a trace, so that background work such as heartbeats does not start traces on its own.
+func StartSpan(ctx context.Context, name string, opts ...trace.SpanOption) (context.Context, trace.Span) {
+	parent := trace.SpanFromContext(ctx)
+	if !parent.SpanContext().IsValid() {
+		return ctx, parent
+	}
+	return parent.Tracer().Start(ctx, name, opts...)
+}
+
+// startSpan starts a span with given tracer provider, it starts a new trace if ctx is not in one.
+func startSpan(provider trace.TracerProvider, ctx context.Context, name string, opts ...trace.SpanOption) (context.Context, trace.Span) {
+	return provider.Tracer(tracerName).Start(ctx, name, opts...)
+}
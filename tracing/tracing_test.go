@@ -0,0 +1,71 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newTestProvider() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)), exporter
+}
+
+func spanNames(exporter *tracetest.InMemoryExporter) []string {
+	var names []string
+	for _, s := range exporter.GetSpans() {
+		names = append(names, s.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Spans of a trace that crosses two servers in one process are recorded by the tracer provider of each server.
+func TestSpansRecordedByServerProvider(t *testing.T) {
+	providerA, exporterA := newTestProvider()
+	providerB, exporterB := newTestProvider()
+
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.Use(GinMiddleware(providerB))
+	router.GET("/b", func(c *gin.Context) {
+		_, span := StartSpan(c.Request.Context(), "b.work")
+		span.End()
+	})
+
+	ctx, span := startSpan(providerA, context.Background(), "a.request")
+	_, child := StartSpan(ctx, "a.work")
+	child.End()
+	req := httptest.NewRequest(http.MethodGet, "/b", nil)
+	propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	span.End()
+
+	if got := spanNames(exporterA); len(got) != 2 || got[0] != "a.request" || got[1] != "a.work" {
+		t.Fatalf("got spans %v on server A, want [a.request a.work]", got)
+	}
+	if got := spanNames(exporterB); len(got) != 2 || got[0] != "GET /b" || got[1] != "b.work" {
+		t.Fatalf("got spans %v on server B, want [GET /b b.work]", got)
+	}
+	traceID := span.SpanContext().TraceID()
+	for _, s := range exporterB.GetSpans() {
+		if s.SpanContext.TraceID() != traceID {
+			t.Fatalf("span %q on server B is not in the trace of server A", s.Name)
+		}
+	}
+}
+
+// Nothing is recorded outside of a trace.
+func TestStartSpanWithoutTrace(t *testing.T) {
+	ctx, span := StartSpan(context.Background(), "work")
+	if span.SpanContext().IsValid() || ctx != context.Background() {
+		t.Fatal("a span is started outside of a trace")
+	}
+}
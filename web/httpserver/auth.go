@@ -0,0 +1,217 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lizhaoliu/konsen/v2/core"
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+)
+
+// Authentication API, all but token requires the root role once authentication is enabled:
+//
+//	POST   /v2/auth/enable                                              Enables authentication.
+//	POST   /v2/auth/disable                                             Disables authentication.
+//	POST   /v2/auth/token  {"ttlSeconds": ...}                          Issues a token for the user of the request.
+//	GET    /v2/auth/users                                               Lists users.
+//	PUT    /v2/auth/users/{name}  {"password": ..., "roles": [...]}     Adds or updates a user.
+//	DELETE /v2/auth/users/{name}                                        Deletes a user.
+//	GET    /v2/auth/roles                                               Lists roles.
+//	PUT    /v2/auth/roles/{name}  {"permissions": [...]}                Adds or updates a role.
+//	DELETE /v2/auth/roles/{name}                                        Deletes a role.
+//
+// Requests are authenticated with "Authorization: Basic ..." (user name and password) or "Authorization: Bearer ..."
+// (token) headers. Changes sent to a follower are redirected to the leader with 307 and LeaderHeader, since servers
+// do not forward them to each other.
+const authRelPath = "/auth"
+
+// authChallenge is the WWW-Authenticate header of unauthenticated responses.
+const authChallenge = `Basic realm="konsen"`
+
+// V2User is a user.
+type V2User struct {
+	Name     string   `json:"name"`
+	Password string   `json:"password,omitempty"` // Only in requests, the password is unchanged if empty.
+	Roles    []string `json:"roles"`
+}
+
+// V2Role is a role.
+type V2Role struct {
+	Name        string         `json:"name"`
+	Permissions []V2Permission `json:"permissions"`
+}
+
+// V2Permission grants access to keys starting with a prefix.
+type V2Permission struct {
+	Prefix string `json:"prefix"`
+	Type   string `json:"type"` // One of: read, write, readwrite.
+}
+
+// V2TokenRequest is the body of a token request.
+type V2TokenRequest struct {
+	TTLSeconds int64 `json:"ttlSeconds"` // Lifetime of the token, defaults to an hour.
+}
+
+// V2TokenResponse is the response of a token request.
+type V2TokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// credentialsMiddleware attaches the credentials in Authorization header to the request context.
+func credentialsMiddleware(c *gin.Context) {
+	header := c.GetHeader("Authorization")
+	if header == "" {
+		return
+	}
+	creds := &core.Credentials{}
+	if username, password, ok := c.Request.BasicAuth(); ok {
+		creds.Username, creds.Password = username, password
+	} else if len(header) > len("Bearer ") && strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		creds.Token = header[len("Bearer "):]
+	} else {
+		c.Header("WWW-Authenticate", authChallenge)
+		writeV2ErrorCode(c, http.StatusUnauthorized, ErrorCodeUnauthenticated, "unsupported authorization scheme")
+		c.Abort()
+		return
+	}
+	c.Request = c.Request.WithContext(core.WithCredentials(c.Request.Context(), creds))
+}
+
+func (s *Server) initializeAuth(group *gin.RouterGroup) {
+	group.POST("/enable", s.v2AuthHandler(s.sm.EnableAuth))
+	group.POST("/disable", s.v2AuthHandler(s.sm.DisableAuth))
+	group.POST("/token", s.v2TokenHandler)
+	group.GET("/users", s.v2ListUsersHandler)
+	group.PUT("/users/:name", s.v2PutUserHandler)
+	group.DELETE("/users/:name", s.v2DeleteUserHandler)
+	group.GET("/roles", s.v2ListRolesHandler)
+	group.PUT("/roles/:name", s.v2PutRoleHandler)
+	group.DELETE("/roles/:name", s.v2DeleteRoleHandler)
+}
+
+// v2AuthHandler returns a handler that runs fn with the request context on the leader.
+func (s *Server) v2AuthHandler(fn func(ctx context.Context) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.maybeRedirectToLeader(c) {
+			return
+		}
+		if err := fn(c.Request.Context()); err != nil {
+			writeV2Error(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{})
+	}
+}
+
+func (s *Server) v2TokenHandler(c *gin.Context) {
+	var req V2TokenRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			writeV2ErrorCode(c, http.StatusBadRequest, ErrorCodeInvalid, err.Error())
+			return
+		}
+	}
+	token, expiry, err := s.sm.IssueToken(c.Request.Context(), time.Duration(req.TTLSeconds)*time.Second)
+	if err != nil {
+		writeV2Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, V2TokenResponse{Token: token, ExpiresAt: expiry})
+}
+
+func (s *Server) v2ListUsersHandler(c *gin.Context) {
+	users, err := s.sm.Users(c.Request.Context())
+	if err != nil {
+		writeV2Error(c, err)
+		return
+	}
+	result := make([]V2User, len(users))
+	for i, user := range users {
+		result[i] = V2User{Name: user.GetName(), Roles: user.GetRoles()}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (s *Server) v2PutUserHandler(c *gin.Context) {
+	if s.maybeRedirectToLeader(c) {
+		return
+	}
+	var req V2User
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeV2ErrorCode(c, http.StatusBadRequest, ErrorCodeInvalid, err.Error())
+		return
+	}
+	if err := s.sm.PutUser(c.Request.Context(), c.Param("name"), req.Password, req.Roles); err != nil {
+		writeV2Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (s *Server) v2DeleteUserHandler(c *gin.Context) {
+	if s.maybeRedirectToLeader(c) {
+		return
+	}
+	if err := s.sm.DeleteUser(c.Request.Context(), c.Param("name")); err != nil {
+		writeV2Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (s *Server) v2ListRolesHandler(c *gin.Context) {
+	roles, err := s.sm.Roles(c.Request.Context())
+	if err != nil {
+		writeV2Error(c, err)
+		return
+	}
+	result := make([]V2Role, len(roles))
+	for i, role := range roles {
+		result[i] = V2Role{Name: role.GetName(), Permissions: make([]V2Permission, len(role.GetPermissions()))}
+		for j, p := range role.GetPermissions() {
+			result[i].Permissions[j] = V2Permission{Prefix: string(p.GetPrefix()), Type: strings.ToLower(p.GetType().String())}
+		}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (s *Server) v2PutRoleHandler(c *gin.Context) {
+	if s.maybeRedirectToLeader(c) {
+		return
+	}
+	var req V2Role
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeV2ErrorCode(c, http.StatusBadRequest, ErrorCodeInvalid, err.Error())
+		return
+	}
+	role := &konsen.AuthRole{Name: c.Param("name")}
+	for _, p := range req.Permissions {
+		typ, ok := konsen.Permission_Type_value[strings.ToUpper(p.Type)]
+		if !ok {
+			writeV2ErrorCode(c, http.StatusBadRequest, ErrorCodeInvalid, "invalid permission type "+strconv.Quote(p.Type))
+			return
+		}
+		role.Permissions = append(role.Permissions, &konsen.Permission{Prefix: []byte(p.Prefix), Type: konsen.Permission_Type(typ)})
+	}
+	if err := s.sm.PutRole(c.Request.Context(), role); err != nil {
+		writeV2Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (s *Server) v2DeleteRoleHandler(c *gin.Context) {
+	if s.maybeRedirectToLeader(c) {
+		return
+	}
+	if err := s.sm.DeleteRole(c.Request.Context(), c.Param("name")); err != nil {
+		writeV2Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
@@ -2,40 +2,132 @@ package httpserver
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lizhaoliu/konsen/v2/core"
 	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+	"github.com/lizhaoliu/konsen/v2/store"
+	"github.com/lizhaoliu/konsen/v2/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
 )
 
-const serviceRelPath = "/konsen"
+const (
+	serviceRelPath        = "/konsen"
+	scanRelPath           = "/konsen/scan"
+	consistencyRelPath    = "/admin/consistency"
+	membersRelPath        = "/admin/members"
+	transferLeaderRelPath = "/admin/transfer-leader"
+	metricsRelPath        = "/metrics"
+)
+
+// ErrorCodeHeader is the response header that tells clients why a request failed, it is one of the error codes below.
+const ErrorCodeHeader = "X-Konsen-Error"
+
+const (
+	ErrorCodeNoQuorum   = "no_quorum"  // No leader is elected or the leader is not reachable.
+	ErrorCodeNotLeader  = "not_leader" // The request must be served by the leader.
+	ErrorCodeTimeout    = "timeout"    // The request did not complete in time, it may still take effect later.
+	ErrorCodeOverloaded = "overloaded" // Too many requests are pending on the server, retry later.
+	ErrorCodeTooStale   = "too_stale"  // The server is too far behind the leader for the consistency of the read.
+	ErrorCodeNotFound   = "not_found"  // The key does not exist.
+	ErrorCodeInvalid    = "invalid"    // The request is malformed.
+	ErrorCodeInternal   = "internal"   // Any other error.
+
+	ErrorCodeUnauthenticated  = "unauthenticated"   // Authentication is enabled and the request has no valid credentials.
+	ErrorCodePermissionDenied = "permission_denied" // The user is not allowed to do the operation.
+)
+
+// LeaderHeader is the response header that tells clients the HTTP endpoint of current leader, when a write is sent to
+// a follower.
+const LeaderHeader = "X-Konsen-Leader"
+
+// RevisionHeader is the response header of the plain API that tells clients the revision a write takes effect at, or a
+// read is served at. Reads with query parameter "min_index" set to it observe the write.
+const RevisionHeader = "X-Konsen-Revision"
+
+// Default read and write timeouts of the HTTP server.
+const defaultTimeout = 10 * time.Second
+
+// Member is a server in the cluster.
+type Member struct {
+	Name         string `json:"name"`         // Server name.
+	Endpoint     string `json:"endpoint"`     // Raft (gRPC) endpoint.
+	HttpEndpoint string `json:"httpEndpoint"` // HTTP endpoint.
+}
+
+// KeyValue is a key-value pair returned by scans.
+type KeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
 
 type Server struct {
 	sm         *core.StateMachine
+	metrics    prometheus.Gatherer
+	cluster    *core.ClusterConfig
+	tls        bool
+	peerTLS    func(server string) *tls.Config
 	router     *gin.Engine
 	httpServer *http.Server
 }
 
 type ServerConfig struct {
 	StateMachine *core.StateMachine
+	Cluster      *core.ClusterConfig
 	Address      string
+	TLS          *tls.Config                     // TLS of the server, plaintext if nil.
+	PeerTLS      func(server string) *tls.Config // TLS of requests to other servers, required if TLS is set.
+	ReadTimeout  time.Duration                   // Defaults to 10 seconds.
+	WriteTimeout time.Duration                   // Defaults to 10 seconds.
+	// Metrics of the server, served on /metrics with the Raft status and the metrics in the default Prometheus registry
+	// (e.g. of the Go runtime).
+	Metrics prometheus.Gatherer
+	// Tracer provider of the spans of requests, spans are not recorded if unset.
+	TracerProvider trace.TracerProvider
+}
+
+func init() {
+	gin.SetMode(gin.ReleaseMode)
 }
 
 func NewServer(config ServerConfig) *Server {
+	if config.ReadTimeout == 0 {
+		config.ReadTimeout = defaultTimeout
+	}
+	if config.WriteTimeout == 0 {
+		config.WriteTimeout = defaultTimeout
+	}
+	if config.TracerProvider == nil {
+		config.TracerProvider = trace.NewNoopTracerProvider()
+	}
 	router := gin.Default()
+	router.Use(tracing.GinMiddleware(config.TracerProvider), credentialsMiddleware)
 
 	httpServer := &http.Server{
 		Addr:         config.Address,
 		Handler:      router,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		ReadTimeout:  config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout,
+		TLSConfig:    config.TLS,
 	}
 	httpServer.SetKeepAlivesEnabled(false)
 
 	s := &Server{
 		sm:         config.StateMachine,
+		metrics:    config.Metrics,
+		cluster:    config.Cluster,
+		tls:        config.TLS != nil,
+		peerTLS:    config.PeerTLS,
 		router:     router,
 		httpServer: httpServer,
 	}
@@ -45,21 +137,58 @@ func NewServer(config ServerConfig) *Server {
 	return s
 }
 
+// metricsHandler serves the metrics of the server and in the default Prometheus registry, plus the Raft status of the
+// state machine, which is labeled with the server name like the metrics of the server.
+func (s *Server) metricsHandler() http.Handler {
+	registry := prometheus.NewRegistry()
+	labels := prometheus.Labels{"server": s.cluster.LocalServerName}
+	prometheus.WrapRegistererWith(labels, registry).MustRegister(core.NewStatusCollector(s.sm))
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, registry}
+	if s.metrics != nil {
+		gatherers = append(gatherers, s.metrics)
+	}
+	return promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{})
+}
+
 func (s *Server) initialize() {
 	s.router.GET(serviceRelPath, s.getHandler)
 	s.router.POST(serviceRelPath, s.postHandler)
+	s.router.DELETE(serviceRelPath, s.deleteHandler)
+	s.router.GET(scanRelPath, s.scanHandler)
+	s.router.GET(consistencyRelPath, s.consistencyHandler)
+	s.router.GET(statusRelPath, s.statusHandler)
+	s.router.GET(clusterStatusRelPath, s.clusterStatusHandler)
+	s.router.GET(debugRaftRelPath, s.debugRaftHandler)
+	s.router.GET(membersRelPath, s.membersHandler)
+	s.router.POST(transferLeaderRelPath, s.transferLeaderHandler)
+	s.router.GET(metricsRelPath, gin.WrapH(s.metricsHandler()))
+
+	v2 := s.router.Group(v2RelPath)
+	v2.GET("/kv", s.v2ListHandler)
+	v2.GET("/kv/*key", s.v2GetHandler)
+	v2.PUT("/kv/*key", s.v2PutHandler)
+	v2.DELETE("/kv/*key", s.v2DeleteHandler)
+	s.initializeAuth(v2.Group(authRelPath))
 }
 
+// getHandler returns the value of the key given by query parameter "key", with the consistency given by query
+// parameters "consistency" and "min_index" (see readConsistency). Reads are stale by default, served from the local
+// state of this server as they always have been, linearizable ones must be asked for.
 func (s *Server) getHandler(c *gin.Context) {
 	key := c.Query("key")
 	if key != "" {
-		ctx := context.Background()
-		buf, err := s.sm.GetValue(ctx, []byte(key))
+		consistency, err := readConsistency(c, core.Stale)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		resp, err := s.sm.Get(c.Request.Context(), &konsen.GetReq{Key: []byte(key), Options: consistency.Options()})
 		if err != nil {
-			c.String(http.StatusInternalServerError, err.Error())
+			writeError(c, err)
 			return
 		}
-		c.String(http.StatusOK, string(buf))
+		setRevisionHeader(c, resp.GetRevision())
+		c.String(http.StatusOK, string(resp.GetValue()))
 	}
 }
 
@@ -77,15 +206,202 @@ func (s *Server) postHandler(c *gin.Context) {
 			})
 		}
 	}
-	kvList := &konsen.KVList{KvList: kvs}
-	ctx := context.Background()
-	if err := s.sm.SetKeyValue(ctx, kvList); err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+	revision, err := s.sm.Write(c.Request.Context(), &konsen.KVList{KvList: kvs})
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	setRevisionHeader(c, revision)
+	c.String(http.StatusOK, "")
+}
+
+// deleteHandler deletes the key given by query parameter "key".
+func (s *Server) deleteHandler(c *gin.Context) {
+	key := c.Query("key")
+	if key == "" {
+		c.String(http.StatusBadRequest, "key is unspecified")
+		return
+	}
+	revision, err := s.sm.Write(c.Request.Context(), &konsen.KVList{KvList: []*konsen.KV{{Key: []byte(key), Delete: true}}})
+	if err != nil {
+		writeError(c, err)
 		return
 	}
+	setRevisionHeader(c, revision)
 	c.String(http.StatusOK, "")
 }
 
+// scanHandler returns key-value pairs with keys starting with query parameter "prefix" in key order, at most "limit"
+// pairs are returned if it is positive. Query parameters "consistency" and "min_index" are the consistency of the read,
+// which is stale by default like getHandler.
+func (s *Server) scanHandler(c *gin.Context) {
+	prefix := []byte(c.Query("prefix"))
+	consistency, err := readConsistency(c, core.Stale)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	limit := 0
+	if l := c.Query("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil {
+			c.String(http.StatusBadRequest, "invalid limit %q", l)
+			return
+		}
+	}
+	resp, err := s.sm.Range(c.Request.Context(), &konsen.RangeReq{
+		StartKey: prefix,
+		EndKey:   store.PrefixEnd(prefix),
+		Limit:    int64(limit),
+		Options:  consistency.Options(),
+	})
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	setRevisionHeader(c, resp.GetRevision())
+	result := make([]KeyValue, len(resp.GetKvs()))
+	for i, kv := range resp.GetKvs() {
+		result[i] = KeyValue{Key: string(kv.GetKey()), Value: string(kv.GetValue())}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// membersHandler lists the servers in the cluster.
+func (s *Server) membersHandler(c *gin.Context) {
+	var members []Member
+	for name, endpoint := range s.cluster.Servers {
+		members = append(members, Member{
+			Name:         name,
+			Endpoint:     endpoint,
+			HttpEndpoint: s.cluster.HttpServers[name],
+		})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	c.JSON(http.StatusOK, members)
+}
+
+// transferLeaderHandler transfers leadership from this server (must be the leader) to the server given by query
+// parameter "target".
+func (s *Server) transferLeaderHandler(c *gin.Context) {
+	target := c.Query("target")
+	if target == "" {
+		c.String(http.StatusBadRequest, "target is unspecified")
+		return
+	}
+	if err := s.sm.TransferLeadership(c.Request.Context(), target); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.String(http.StatusOK, "")
+}
+
+// consistencyHandler reports the results of cross-replica state consistency checks.
+func (s *Server) consistencyHandler(c *gin.Context) {
+	report, err := s.sm.GetConsistencyReport(c.Request.Context())
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// readConsistency returns the consistency of a read given by query parameter "consistency" (see
+// core.ParseReadConsistency), or fallback if it is unset, and "min_index", the log index the server waits to apply
+// before serving the read.
+func readConsistency(c *gin.Context, fallback core.ReadConsistency) (core.ReadConsistency, error) {
+	consistency := fallback
+	if s := c.Query("consistency"); s != "" {
+		parsed, err := core.ParseReadConsistency(s)
+		if err != nil {
+			return core.ReadConsistency{}, err
+		}
+		consistency = parsed
+	}
+	if i := c.Query("min_index"); i != "" {
+		index, err := strconv.ParseUint(i, 10, 64)
+		if err != nil {
+			return core.ReadConsistency{}, fmt.Errorf("invalid min_index %q", i)
+		}
+		consistency.MinIndex = index
+	}
+	return consistency, nil
+}
+
+// setRevisionHeader sets RevisionHeader of a successful response.
+func setRevisionHeader(c *gin.Context, revision uint64) {
+	c.Header(RevisionHeader, strconv.FormatUint(revision, 10))
+}
+
+// writeError writes err as the response, with an error code header if it is a known error.
+func writeError(c *gin.Context, err error) {
+	status, code := errorCode(err)
+	if code != ErrorCodeInternal {
+		c.Header(ErrorCodeHeader, code)
+	}
+	setErrorHeaders(c, code)
+	c.String(status, err.Error())
+}
+
+// setErrorHeaders sets the response headers that come with an error code.
+func setErrorHeaders(c *gin.Context, code string) {
+	switch code {
+	case ErrorCodeUnauthenticated:
+		c.Header("WWW-Authenticate", authChallenge)
+	case ErrorCodeOverloaded:
+		c.Header("Retry-After", "1")
+	}
+}
+
+// errorCode returns the HTTP status and error code of err.
+func errorCode(err error) (int, string) {
+	switch {
+	case errors.Is(err, core.ErrNoQuorum):
+		return http.StatusServiceUnavailable, ErrorCodeNoQuorum
+	case errors.Is(err, core.ErrNotLeader):
+		return http.StatusServiceUnavailable, ErrorCodeNotLeader
+	case errors.Is(err, core.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, ErrorCodeTimeout
+	case errors.Is(err, core.ErrOverloaded):
+		return http.StatusTooManyRequests, ErrorCodeOverloaded
+	case errors.Is(err, core.ErrTooStale):
+		return http.StatusServiceUnavailable, ErrorCodeTooStale
+	case errors.Is(err, core.ErrUnauthenticated):
+		return http.StatusUnauthorized, ErrorCodeUnauthenticated
+	case errors.Is(err, core.ErrPermissionDenied):
+		return http.StatusForbidden, ErrorCodePermissionDenied
+	case errors.Is(err, core.ErrInvalidAuth), errors.Is(err, core.ErrInvalidArgument):
+		return http.StatusBadRequest, ErrorCodeInvalid
+	default:
+		return http.StatusInternalServerError, ErrorCodeInternal
+	}
+}
+
 func (s *Server) Run() error {
+	if s.tls {
+		// Certificates are provided by TLSConfig.
+		return s.httpServer.ListenAndServeTLS("", "")
+	}
 	return s.httpServer.ListenAndServe()
 }
+
+// Serve serves on given listener, like Run.
+func (s *Server) Serve(lis net.Listener) error {
+	if s.tls {
+		return s.httpServer.ServeTLS(lis, "", "")
+	}
+	return s.httpServer.Serve(lis)
+}
+
+// Shutdown stops the server after pending requests are done, or when ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
+// scheme returns the URL scheme of the HTTP servers in the cluster.
+func (s *Server) scheme() string {
+	if s.tls {
+		return "https"
+	}
+	return "http"
+}
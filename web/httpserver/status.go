@@ -0,0 +1,202 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lizhaoliu/konsen/v2/core"
+)
+
+const (
+	statusRelPath        = "/status"
+	clusterStatusRelPath = "/status/cluster"
+	debugRaftRelPath     = "/debug/raft"
+)
+
+// Timeout of getting the status of each server for the cluster status.
+const clusterStatusTimeout = 2 * time.Second
+
+// Status is the Raft status of a server.
+type Status struct {
+	Server        string                     `json:"server"`               // Server name.
+	Role          string                     `json:"role"`                 // Current role.
+	Term          uint64                     `json:"term"`                 // Current term.
+	Leader        string                     `json:"leader"`               // Current leader, empty if unknown.
+	LeaderHttp    string                     `json:"leaderHttp,omitempty"` // HTTP endpoint of current leader.
+	CommitIndex   uint64                     `json:"commitIndex"`          // Index of highest log entry known to be committed.
+	LastApplied   uint64                     `json:"lastApplied"`          // Index of highest log entry applied to state machine.
+	FirstLogIndex uint64                     `json:"firstLogIndex"`        // Index of the first log entry.
+	LastLogIndex  uint64                     `json:"lastLogIndex"`         // Index of the last log entry.
+	LastLogTerm   uint64                     `json:"lastLogTerm"`          // Term of the last log entry.
+	Followers     map[string]*FollowerStatus `json:"followers,omitempty"`  // Replication status of each follower, only on leader.
+	Corruption    string                     `json:"corruption,omitempty"` // Log corruption detected on the server, if any.
+}
+
+// FollowerStatus is the replication status of a follower, as seen by the leader.
+type FollowerStatus struct {
+	NextIndex  uint64 `json:"nextIndex"`  // Index of the next log entry to send to the follower.
+	MatchIndex uint64 `json:"matchIndex"` // Index of highest log entry known to be replicated on the follower.
+	Lag        uint64 `json:"lag"`        // Number of log entries not yet known to be replicated on the follower.
+}
+
+// ClusterStatus is the status of all servers in the cluster.
+type ClusterStatus struct {
+	Leader  string         `json:"leader"` // Leader with the highest term, as reported by itself, empty if none.
+	Term    uint64         `json:"term"`   // Term of the leader.
+	Servers []ServerStatus `json:"servers"`
+}
+
+// ServerStatus is the status of a server, or the error getting it.
+type ServerStatus struct {
+	Name         string  `json:"name"`
+	HttpEndpoint string  `json:"httpEndpoint"`
+	Status       *Status `json:"status,omitempty"`
+	Error        string  `json:"error,omitempty"`
+}
+
+// DebugRaft is the internal Raft state of a server.
+type DebugRaft struct {
+	Status
+	VotedFor       string                  `json:"votedFor"`
+	Logs           []DebugLog              `json:"logs"`           // Most recent log entries.
+	LogCacheHits   uint64                  `json:"logCacheHits"`   // Number of log reads served from the in-memory cache.
+	LogCacheMisses uint64                  `json:"logCacheMisses"` // Number of log reads that fell through to the log storage.
+	Consistency    *core.ConsistencyReport `json:"consistency"`
+}
+
+// DebugLog is a log entry without data.
+type DebugLog struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Bytes int    `json:"bytes"`
+}
+
+func (s *Server) toStatus(status *core.Status) *Status {
+	result := &Status{
+		Server:        status.Server,
+		Role:          status.Role.String(),
+		Term:          status.Term,
+		Leader:        status.Leader,
+		LeaderHttp:    s.cluster.HttpServers[status.Leader],
+		CommitIndex:   status.CommitIndex,
+		LastApplied:   status.LastApplied,
+		FirstLogIndex: status.FirstLogIndex,
+		LastLogIndex:  status.LastLogIndex,
+		LastLogTerm:   status.LastLogTerm,
+		Corruption:    status.Corruption,
+	}
+	if status.Followers != nil {
+		result.Followers = make(map[string]*FollowerStatus)
+		for server, f := range status.Followers {
+			result.Followers[server] = &FollowerStatus{NextIndex: f.NextIndex, MatchIndex: f.MatchIndex, Lag: f.Lag}
+		}
+	}
+	return result
+}
+
+// statusHandler reports the Raft status of this server.
+func (s *Server) statusHandler(c *gin.Context) {
+	status, err := s.sm.GetStatus(c.Request.Context())
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, s.toStatus(status))
+}
+
+// clusterStatusHandler reports the Raft status of all servers in the cluster.
+func (s *Server) clusterStatusHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), clusterStatusTimeout)
+	defer cancel()
+
+	result := ClusterStatus{}
+	for name, endpoint := range s.cluster.HttpServers {
+		result.Servers = append(result.Servers, ServerStatus{Name: name, HttpEndpoint: endpoint})
+	}
+	sort.Slice(result.Servers, func(i, j int) bool { return result.Servers[i].Name < result.Servers[j].Name })
+
+	var wg sync.WaitGroup
+	for i := range result.Servers {
+		wg.Add(1)
+		go func(server *ServerStatus) {
+			defer wg.Done()
+			var err error
+			if server.Name == s.cluster.LocalServerName {
+				var status *core.Status
+				if status, err = s.sm.GetStatus(ctx); err == nil {
+					server.Status = s.toStatus(status)
+				}
+			} else {
+				server.Status, err = s.getRemoteStatus(ctx, server.Name, server.HttpEndpoint)
+			}
+			if err != nil {
+				server.Error = err.Error()
+			}
+		}(&result.Servers[i])
+	}
+	wg.Wait()
+
+	for _, server := range result.Servers {
+		if server.Status != nil && server.Status.Role == "LEADER" && server.Status.Term >= result.Term {
+			result.Leader, result.Term = server.Name, server.Status.Term
+		}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// getRemoteStatus gets the status of the server with given name and HTTP endpoint.
+func (s *Server) getRemoteStatus(ctx context.Context, name string, endpoint string) (*Status, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.scheme()+"://"+endpoint+statusRelPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := http.DefaultClient
+	if s.peerTLS != nil {
+		client = &http.Client{Transport: &http.Transport{TLSClientConfig: s.peerTLS(name)}}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	status := &Status{}
+	if err := json.NewDecoder(resp.Body).Decode(status); err != nil {
+		return nil, fmt.Errorf("failed to decode status: %v", err)
+	}
+	return status, nil
+}
+
+// debugRaftHandler reports the internal Raft state of this server.
+func (s *Server) debugRaftHandler(c *gin.Context) {
+	snapshot, err := s.sm.GetSnapshot(c.Request.Context())
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	result := DebugRaft{
+		Status:         *s.toStatus(&snapshot.Status),
+		VotedFor:       snapshot.VotedFor,
+		Logs:           make([]DebugLog, len(snapshot.LogIndices)),
+		LogCacheHits:   snapshot.LogCacheHits,
+		LogCacheMisses: snapshot.LogCacheMisses,
+	}
+	for i := range snapshot.LogIndices {
+		result.Logs[i] = DebugLog{Index: snapshot.LogIndices[i], Term: snapshot.LogTerms[i], Bytes: snapshot.LogBytes[i]}
+	}
+	if snapshot.Corruption == "" {
+		if result.Consistency, err = s.sm.GetConsistencyReport(c.Request.Context()); err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	c.JSON(http.StatusOK, result)
+}
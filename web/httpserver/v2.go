@@ -0,0 +1,230 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lizhaoliu/konsen/v2/core"
+	konsen "github.com/lizhaoliu/konsen/v2/proto_gen"
+	"github.com/lizhaoliu/konsen/v2/store"
+)
+
+// Versioned JSON API:
+//
+//	GET    /v2/kv/{key}?consistency=&min_index=             Gets the value of a key, 404 if it does not exist.
+//	PUT    /v2/kv/{key}  {"value": ...}                     Sets the value of a key.
+//	DELETE /v2/kv/{key}                                     Deletes a key.
+//	GET    /v2/kv?prefix=&limit=&consistency=&min_index=    Lists key-value pairs with keys starting with prefix.
+//
+// Keys in paths and query parameters are URL escaped, keys and values in JSON bodies are base64 encoded. Writes sent to
+// a follower are redirected to the leader with 307 and LeaderHeader. Reads are linearizable unless the consistency
+// parameter says otherwise (see core.ParseReadConsistency), a server that can not serve a read with its consistency
+// redirects it to the leader. Reads with min_index set to the revision of a write wait for the server to apply it.
+const v2RelPath = "/v2"
+
+// V2KeyValue is a key-value pair.
+type V2KeyValue struct {
+	Key      []byte `json:"key"`
+	Value    []byte `json:"value"`
+	Revision uint64 `json:"revision,omitempty"` // Revision the value is read at.
+}
+
+// V2PutRequest is the body of a put request.
+type V2PutRequest struct {
+	Value []byte `json:"value"`
+}
+
+// V2WriteResponse is the response of a write.
+type V2WriteResponse struct {
+	Revision uint64 `json:"revision"` // Revision the write takes effect at.
+}
+
+// V2ListResponse is the response of a list request.
+type V2ListResponse struct {
+	KVs      []V2KeyValue `json:"kvs"`
+	More     bool         `json:"more"`     // True if there are more key-value pairs than the limit.
+	Revision uint64       `json:"revision"` // Revision the key-value pairs are read at.
+}
+
+// V2Error is the response of a failed request.
+type V2Error struct {
+	Error V2ErrorDetail `json:"error"`
+}
+
+// V2ErrorDetail describes why a request failed.
+type V2ErrorDetail struct {
+	Code    string `json:"code"` // One of the ErrorCodeXxx.
+	Message string `json:"message"`
+}
+
+func (s *Server) v2GetHandler(c *gin.Context) {
+	key, ok := v2Key(c)
+	if !ok {
+		return
+	}
+	consistency, ok := v2ReadConsistency(c)
+	if !ok {
+		return
+	}
+	resp, err := s.sm.Get(c.Request.Context(), &konsen.GetReq{Key: key, Options: consistency.Options()})
+	if err != nil {
+		s.writeV2ReadError(c, err)
+		return
+	}
+	if len(resp.GetValue()) == 0 {
+		writeV2ErrorCode(c, http.StatusNotFound, ErrorCodeNotFound, "key does not exist")
+		return
+	}
+	c.JSON(http.StatusOK, V2KeyValue{Key: key, Value: resp.GetValue(), Revision: resp.GetRevision()})
+}
+
+func (s *Server) v2PutHandler(c *gin.Context) {
+	key, ok := v2Key(c)
+	if !ok {
+		return
+	}
+	var req V2PutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeV2ErrorCode(c, http.StatusBadRequest, ErrorCodeInvalid, err.Error())
+		return
+	}
+	if s.maybeRedirectToLeader(c) {
+		return
+	}
+	resp, err := s.sm.Put(c.Request.Context(), &konsen.PutReq{Key: key, Value: req.Value})
+	if err != nil {
+		writeV2Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, V2WriteResponse{Revision: resp.GetRevision()})
+}
+
+func (s *Server) v2DeleteHandler(c *gin.Context) {
+	key, ok := v2Key(c)
+	if !ok {
+		return
+	}
+	if s.maybeRedirectToLeader(c) {
+		return
+	}
+	resp, err := s.sm.Delete(c.Request.Context(), &konsen.DeleteReq{Key: key})
+	if err != nil {
+		writeV2Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, V2WriteResponse{Revision: resp.GetRevision()})
+}
+
+func (s *Server) v2ListHandler(c *gin.Context) {
+	prefix := []byte(c.Query("prefix"))
+	var limit int64
+	if l := c.Query("limit"); l != "" {
+		var err error
+		if limit, err = strconv.ParseInt(l, 10, 64); err != nil || limit < 0 {
+			writeV2ErrorCode(c, http.StatusBadRequest, ErrorCodeInvalid, "invalid limit "+strconv.Quote(l))
+			return
+		}
+	}
+	consistency, ok := v2ReadConsistency(c)
+	if !ok {
+		return
+	}
+	resp, err := s.sm.Range(c.Request.Context(), &konsen.RangeReq{
+		StartKey: prefix,
+		EndKey:   store.PrefixEnd(prefix),
+		Limit:    limit,
+		Options:  consistency.Options(),
+	})
+	if err != nil {
+		s.writeV2ReadError(c, err)
+		return
+	}
+	result := V2ListResponse{
+		KVs:      make([]V2KeyValue, len(resp.GetKvs())),
+		More:     resp.GetMore(),
+		Revision: resp.GetRevision(),
+	}
+	for i, kv := range resp.GetKvs() {
+		result.KVs[i] = V2KeyValue{Key: kv.GetKey(), Value: kv.GetValue()}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// maybeRedirectToLeader redirects a write to the leader if this server is a follower, returns true if the request has
+// been responded.
+func (s *Server) maybeRedirectToLeader(c *gin.Context) bool {
+	status, err := s.sm.GetStatus(c.Request.Context())
+	if err != nil {
+		writeV2Error(c, err)
+		return true
+	}
+	if status.Role == konsen.Role_LEADER {
+		return false
+	}
+	endpoint, ok := s.cluster.HttpServers[status.Leader]
+	if status.Leader == "" || !ok {
+		writeV2ErrorCode(c, http.StatusServiceUnavailable, ErrorCodeNoQuorum, "no leader is elected yet")
+		return true
+	}
+	s.redirect(c, endpoint)
+	return true
+}
+
+// redirect redirects the request to the server at given HTTP endpoint.
+func (s *Server) redirect(c *gin.Context, endpoint string) {
+	location := *c.Request.URL
+	location.Scheme = s.scheme()
+	location.Host = endpoint
+	c.Header(LeaderHeader, endpoint)
+	c.Redirect(http.StatusTemporaryRedirect, location.String())
+}
+
+// writeV2ReadError writes the error of a read, a read that this server can not serve with its consistency is
+// redirected to the leader if there is one.
+func (s *Server) writeV2ReadError(c *gin.Context, err error) {
+	if errors.Is(err, core.ErrNotLeader) || errors.Is(err, core.ErrTooStale) {
+		status, statusErr := s.sm.GetStatus(c.Request.Context())
+		if statusErr == nil && status.Role != konsen.Role_LEADER {
+			if endpoint, ok := s.cluster.HttpServers[status.Leader]; ok {
+				s.redirect(c, endpoint)
+				return
+			}
+		}
+	}
+	writeV2Error(c, err)
+}
+
+// v2ReadConsistency returns the consistency in query parameters "consistency" and "min_index", or responds with an
+// error if it is invalid.
+func v2ReadConsistency(c *gin.Context) (core.ReadConsistency, bool) {
+	consistency, err := readConsistency(c, core.Linearizable)
+	if err != nil {
+		writeV2ErrorCode(c, http.StatusBadRequest, ErrorCodeInvalid, err.Error())
+		return core.ReadConsistency{}, false
+	}
+	return consistency, true
+}
+
+// v2Key returns the key in request path, or responds with an error if it is empty.
+func v2Key(c *gin.Context) ([]byte, bool) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if key == "" {
+		writeV2ErrorCode(c, http.StatusBadRequest, ErrorCodeInvalid, "key is empty")
+		return nil, false
+	}
+	return []byte(key), true
+}
+
+func writeV2Error(c *gin.Context, err error) {
+	status, code := errorCode(err)
+	setErrorHeaders(c, code)
+	writeV2ErrorCode(c, status, code, err.Error())
+}
+
+func writeV2ErrorCode(c *gin.Context, status int, code string, message string) {
+	c.Header(ErrorCodeHeader, code)
+	c.JSON(status, V2Error{Error: V2ErrorDetail{Code: code, Message: message}})
+}